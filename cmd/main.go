@@ -6,11 +6,13 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/backup"
 	"github.com/holmes89/tags/internal/database"
 	"github.com/holmes89/tags/internal/handlers/rest"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/fx"
 	"net/http"
+	"os"
 )
 
 //Default values for application -> move to config?
@@ -24,6 +26,9 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 	app := NewApp()
 	app.Run()
 	logrus.WithField("error", <-app.Done()).Error("terminated")
@@ -36,8 +41,9 @@ func NewApp() *fx.App {
 	return fx.New(
 		fx.Provide(
 			internal.LoadEnvConfiguration,
-			database.NewBoltConnection,
-			database.NewKVStore,
+			internal.NewSystemClock,
+			backup.NewBackupTarget,
+			database.NewBoltConnectionWithBackup,
 			database.NewGraphDatabase,
 			database.NewRepository,
 			NewMux,
@@ -45,10 +51,16 @@ func NewApp() *fx.App {
 		fx.Invoke(
 			rest.NewResourceHandler,
 			rest.NewTagHandler,
+			rest.NewTypeHandler,
+			rest.NewTrashHandler,
+			rest.NewTransferHandler,
+			rest.NewAdminHandler,
 		),
 		fx.Logger(
 			logger,
 		),
+		// leave the backup of pending writes its own time on top of the other stop hooks
+		fx.StopTimeout(fx.DefaultTimeout+database.ShutdownBackupTimeout),
 	)
 }
 
@@ -56,9 +68,10 @@ func NewApp() *fx.App {
 func NewMux(lc fx.Lifecycle) *mux.Router {
 	logrus.Info("creating mux")
 
-	router := mux.NewRouter()
+	// match on the encoded path so tag names containing slashes can be sent as %2F
+	router := mux.NewRouter().UseEncodedPath()
 
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "If-Match", "If-None-Match", "Idempotency-Key"})
 	originsOk := handlers.AllowedOrigins([]string{defaultCORS})
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "OPTIONS", "DELETE"})
 	cors := handlers.CORS(originsOk, headersOk, methodsOk)
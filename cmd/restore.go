@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/backup"
+	"github.com/holmes89/tags/internal/database"
+	"os"
+	"text/tabwriter"
+)
+
+// restoreCommand lists the backups of the configured target or restores one of them over the database file,
+// it runs without opening the database as the database is replaced
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	list := flags.Bool("list", false, "list the backups instead of restoring one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restore [flags] [name], restoring the latest backup without a name")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	ctx := context.Background()
+	config := internal.LoadEnvConfiguration()
+	clock := internal.NewSystemClock()
+	target, err := backup.NewTarget(ctx, config, clock)
+	if err == nil && target == nil {
+		err = internal.ErrBackupDisabled
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *list {
+		backups, err := target.List(ctx, config.BackupPrefix+"-")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tCREATED")
+		for _, b := range backups {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", b.Name, b.Size, b.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		tw.Flush()
+		return 0
+	}
+
+	if config.DatabaseFile == "" {
+		fmt.Fprintln(os.Stderr, "database file missing")
+		return 1
+	}
+	report, err := database.RestoreDatabase(ctx, clock, target, config.BackupPrefix, flags.Arg(0), config.DatabaseFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"go.uber.org/fx"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// cliActor is recorded as the actor of the changes made by commands
+const cliActor = "cli"
+
+// command is a subcommand run against the database of the configuration instead of serving, the server has
+// to be stopped first as the database is locked while it is open
+type command func(args []string) (func(transfer internal.DataTransfer) error, error)
+
+var commands = map[string]command{
+	"export": exportCommand,
+	"import": importCommand,
+}
+
+// standaloneCommands run on their own, without the repository, returning the exit code
+var standaloneCommands = map[string]func(args []string) int{
+	"restore": restoreCommand,
+}
+
+// runCommand runs the subcommand named by the first argument and returns the exit code
+func runCommand(args []string) int {
+	if standalone, ok := standaloneCommands[args[0]]; ok {
+		return standalone(args[1:])
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected export, import or restore\n", args[0])
+		return 2
+	}
+	run, err := cmd(args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	// only the kv store is opened, the graph catches up with the changes when the server next starts
+	var transfer internal.DataTransfer
+	app := fx.New(
+		fx.Provide(
+			internal.LoadEnvConfiguration,
+			internal.NewSystemClock,
+			database.NewBoltConnection,
+			func(config internal.Configuration, clock internal.Clock, kv database.KVStore) (internal.DataTransfer, error) {
+				return database.NewTransfer(config, clock, kv, cliActor)
+			},
+		),
+		fx.Populate(&transfer),
+		fx.NopLogger,
+	)
+	if err := app.Start(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = run(transfer)
+	if stopErr := app.Stop(context.Background()); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exportCommand(args []string) (func(transfer internal.DataTransfer) error, error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl, csv or nquads, taken from the extension of the output file by default")
+	output := flags.String("o", "", "file to write to instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return func(transfer internal.DataTransfer) error {
+		if *format == "" {
+			*format = formatOf(*output)
+		}
+		var w io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return transfer.Export(w, *format)
+	}, nil
+}
+
+func importCommand(args []string) (func(transfer internal.DataTransfer) error, error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl, csv or nquads, taken from the extension of the input file by default")
+	mode := flags.String("mode", internal.ImportMerge, "merge keeps the records missing from the import, replace deletes them")
+	dryRun := flags.Bool("dry-run", false, "report the changes without making them")
+	confirm := flags.Bool("confirm", false, "confirm a replace, which deletes the records missing from the import")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: import [flags] [file], reading stdin without a file")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	input := flags.Arg(0)
+
+	return func(transfer internal.DataTransfer) error {
+		if *format == "" {
+			*format = formatOf(input)
+		}
+		var r io.Reader = os.Stdin
+		if input != "" && input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		report, err := transfer.Import(r, *format, internal.ImportOptions{Mode: *mode, DryRun: *dryRun, Confirm: *confirm})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+		if len(report.Errors) > 0 {
+			return errors.New("import refused, it has invalid records")
+		}
+		return nil
+	}, nil
+}
+
+// formatOf guesses the format of a file from its extension, defaulting to JSON Lines
+func formatOf(path string) string {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return internal.FormatCSV
+	case ".nq", ".nquads":
+		return internal.FormatNQuads
+	default:
+		return internal.FormatJSONL
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/holmes89/tags
 
-go 1.14
+go 1.16
 
 require (
 	cloud.google.com/go v0.65.0 // indirect
@@ -8,9 +8,14 @@ require (
 	github.com/boltdb/bolt v1.3.1
 	github.com/cayleygraph/cayley v0.7.7
 	github.com/cayleygraph/quad v1.1.0
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/handlers v1.4.2
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/schema v1.1.0
+	github.com/oklog/ulid/v2 v2.1.0
 	github.com/sirupsen/logrus v1.6.0
+	github.com/xeipuuv/gojsonschema v1.2.0
 	go.uber.org/fx v1.13.0
+	golang.org/x/text v0.3.3
+	google.golang.org/api v0.30.0
 )
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attributes hold arbitrary metadata about a resource. Values may be strings, numbers or booleans,
+// strings in RFC 3339 or YYYY-MM-DD format are treated as dates when querying.
+type Attributes map[string]interface{}
+
+// Validate checks every attribute has a key and a scalar value
+func (a Attributes) Validate() error {
+	for key, value := range a {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("%w: attribute keys may not be empty", ErrInvalid)
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("%w: attribute %q must be a string, number or boolean", ErrInvalid, key)
+		}
+	}
+	return nil
+}
+
+// attribute filter operators
+const (
+	AttrExists = ""
+	AttrEqual  = "="
+	AttrGT     = ">"
+	AttrGTE    = ">="
+	AttrLT     = "<"
+	AttrLTE    = "<="
+)
+
+// AttributeFilter matches resources by one attribute, written as "key" for existence, "key=value" for
+// equality or "key>value", "key>=value", "key<value" and "key<=value" for ranges.
+type AttributeFilter struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+func ParseAttributeFilter(filter string) (AttributeFilter, error) {
+	i := strings.IndexAny(filter, "<>=")
+	if i < 0 {
+		if filter == "" {
+			return AttributeFilter{}, fmt.Errorf("%w: empty attribute filter", ErrInvalid)
+		}
+		return AttributeFilter{Key: filter, Op: AttrExists}, nil
+	}
+	f := AttributeFilter{Key: filter[:i], Op: filter[i : i+1]}
+	if f.Op != AttrEqual && strings.HasPrefix(filter[i+1:], "=") {
+		f.Op += "="
+	}
+	f.Value = filter[i+len(f.Op):]
+	if f.Key == "" {
+		return f, fmt.Errorf("%w: attribute filter %q has no key", ErrInvalid, filter)
+	}
+	return f, nil
+}
+
+// ParseAttributeTime parses the date formats accepted in attribute values
+func ParseAttributeTime(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
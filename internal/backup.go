@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// Backup is a copy of the database held by a backup target
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupTarget stores backups as named objects. Open and Delete return ErrNotFound for a missing backup.
+type BackupTarget interface {
+	// Write stores size bytes read from r under name, replacing a backup of the same name
+	Write(ctx context.Context, name string, r io.Reader, size int64) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the backups whose name starts with prefix ordered by name
+	List(ctx context.Context, prefix string) ([]Backup, error)
+	Delete(ctx context.Context, name string) error
+}
+
+var (
+	// ErrBackupDisabled is returned by backup operations when no backup target is configured
+	ErrBackupDisabled = errors.New("backups not enabled")
+	// ErrBackupCorrupt is returned when a backup fails verification, the database is left as it was
+	ErrBackupCorrupt = errors.New("backup failed verification")
+)
+
+// RestoreReport describes a restored backup, the records are counted while it is verified
+type RestoreReport struct {
+	Name       string    `json:"name"`
+	Tags       int       `json:"tags"`
+	Resources  int       `json:"resources"`
+	RestoredAt time.Time `json:"restored_at"`
+}
+
+type BackupRestorer interface {
+	FindBackups(ctx context.Context) ([]Backup, error)
+	// RestoreBackup replaces the database with a backup once it is verified, the latest backup when name
+	// is empty
+	RestoreBackup(ctx context.Context, name string) (RestoreReport, error)
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+
+	"cloud.google.com/go/storage"
+	"github.com/holmes89/tags/internal"
+	"google.golang.org/api/iterator"
+)
+
+// GCSTarget keeps backups as objects of a Google Cloud Storage bucket
+type GCSTarget struct {
+	bucket *storage.BucketHandle
+}
+
+func NewGCSTarget(ctx context.Context, bucket string) (*GCSTarget, error) {
+	if bucket == "" {
+		return nil, errors.New("bucket name missing")
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCSTarget{bucket: client.Bucket(bucket)}, nil
+}
+
+// Write uploads the backup, the object only becomes visible once the writer is closed without error
+func (t *GCSTarget) Write(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := t.bucket.Object(name).NewWriter(ctx)
+	if _, err := io.Copy(writer, r); err != nil {
+		// cancelling the context abandons the upload
+		cancel()
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (t *GCSTarget) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	reader, err := t.bucket.Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, internal.ErrNotFound
+	}
+	return reader, err
+}
+
+func (t *GCSTarget) List(ctx context.Context, prefix string) ([]internal.Backup, error) {
+	backups := []internal.Backup{}
+	it := t.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, internal.Backup{Name: attrs.Name, Size: attrs.Size, CreatedAt: attrs.Created})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name < backups[j].Name
+	})
+	return backups, nil
+}
+
+func (t *GCSTarget) Delete(ctx context.Context, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	err := t.bucket.Object(name).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return internal.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// partialSuffix marks a backup which is still being written
+const partialSuffix = ".partial"
+
+// LocalTarget keeps backups as files of a directory
+type LocalTarget struct {
+	dir string
+}
+
+func NewLocalTarget(dir string) (*LocalTarget, error) {
+	if dir == "" {
+		return nil, errors.New("backup directory missing")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &LocalTarget{dir: dir}, nil
+}
+
+// Write writes the backup beside its final name and renames it once complete, so a partial backup is never
+// listed
+func (t *LocalTarget) Write(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(t.dir, name+".*"+partialSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(t.dir, name))
+}
+
+func (t *LocalTarget) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil, internal.ErrNotFound
+	}
+	return f, err
+}
+
+func (t *LocalTarget) List(ctx context.Context, prefix string) ([]internal.Backup, error) {
+	files, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := []internal.Backup{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), prefix) || strings.HasSuffix(f.Name(), partialSuffix) {
+			continue
+		}
+		backups = append(backups, internal.Backup{Name: f.Name(), Size: f.Size(), CreatedAt: f.ModTime().UTC()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name < backups[j].Name
+	})
+	return backups, nil
+}
+
+func (t *LocalTarget) Delete(ctx context.Context, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return internal.ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,302 @@
+package backup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// unsignedPayload skips hashing the body when signing, the body of a backup is streamed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type S3Options struct {
+	// Endpoint is the URL of the service, AWS is used when empty
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path rather than the host name
+	PathStyle bool
+	// Client makes the requests, http.DefaultClient when nil
+	Client *http.Client
+	// Clock dates the signatures, the system clock when nil
+	Clock internal.Clock
+}
+
+// S3Target keeps backups as objects of an S3 compatible bucket, requests are signed with AWS signature
+// version 4 when credentials are set
+type S3Target struct {
+	options S3Options
+	base    *url.URL
+}
+
+func NewS3Target(options S3Options) (*S3Target, error) {
+	if options.Bucket == "" {
+		return nil, errors.New("bucket name missing")
+	}
+	if options.Region == "" {
+		return nil, errors.New("region missing")
+	}
+	endpoint := options.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + options.Region + ".amazonaws.com"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.Clock == nil {
+		options.Clock = internal.NewSystemClock()
+	}
+	return &S3Target{options: options, base: base}, nil
+}
+
+func (t *S3Target) Write(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	req, err := t.request(ctx, http.MethodPut, name, nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (t *S3Target) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	req, err := t.request(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (t *S3Target) List(ctx context.Context, prefix string) ([]internal.Backup, error) {
+	backups := []internal.Backup{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		req, err := t.request(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bucket listing: %v", err)
+		}
+		for _, c := range result.Contents {
+			backups = append(backups, internal.Backup{Name: c.Key, Size: c.Size, CreatedAt: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name < backups[j].Name
+	})
+	return backups, nil
+}
+
+// Delete removes a backup, S3 does not report whether the object existed
+func (t *S3Target) Delete(ctx context.Context, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	req, err := t.request(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// request builds a signed request for an object, or for the bucket when key is empty
+func (t *S3Target) request(ctx context.Context, method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := *t.base
+	p := strings.TrimSuffix(u.Path, "/")
+	if t.options.PathStyle {
+		p += "/" + t.options.Bucket
+	} else {
+		u.Host = t.options.Bucket + "." + u.Host
+	}
+	p += "/" + key
+	u.Path, u.RawPath = p, ""
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	t.sign(req, t.options.Clock.Now().UTC())
+	return req, nil
+}
+
+// do sends a request, a response with an error status is returned as an error
+func (t *S3Target) do(req *http.Request) (*http.Response, error) {
+	resp, err := t.options.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, internal.ErrNotFound
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// sign adds the headers of AWS signature version 4 to the request, requests are anonymous without
+// credentials. The payload is left unsigned unless the request already carries its hash.
+func (t *S3Target) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if t.options.AccessKey == "" {
+		return
+	}
+
+	canonicalRequest, signedHeaders := canonicalRequest(req, payloadHash)
+	scope := now.Format("20060102") + "/" + t.options.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+t.options.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, t.options.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+t.options.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalRequest returns the canonical form of a request signature version 4 signs and the names of
+// the headers it signs, every header of the request but a previous signature is signed
+func canonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	delete(headers, "authorization")
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// S3 encodes each segment of the path once, keeping the slashes
+	segments := strings.Split(req.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join([]string{
+		req.Method,
+		strings.Join(segments, "/"),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes a query as signature version 4 expects, sorted by key with spaces as %20
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes every byte but the unreserved characters of RFC 3986
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"", "expected 5 fields"},
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"@often", "expected 5 fields"},
+		{"60 * * * *", "minute: \"60\" out of range 0-59"},
+		{"* 24 * * *", "hour: \"24\" out of range 0-23"},
+		{"* * 0 * *", "day of month: \"0\" out of range 1-31"},
+		{"* * 32 * *", "day of month: \"32\" out of range 1-31"},
+		{"* * * 13 *", "month: \"13\" out of range 1-12"},
+		{"* * * * 8", "day of week: \"8\" out of range 0-7"},
+		{"5-1 * * * *", "minute: \"5-1\" out of range 0-59"},
+		{"a * * * *", "minute: invalid value \"a\""},
+		{"1-b * * * *", "minute: invalid value \"b\""},
+		{"1,,2 * * * *", "minute: invalid value \"\""},
+		{"*/0 * * * *", "minute: invalid step \"0\""},
+		{"*/x * * * *", "minute: invalid step \"x\""},
+		{"* * * JAN *", "month: invalid value \"JAN\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseSchedule(tt.spec)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSchedule(%q) returned %v, want an error containing %q", tt.spec, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	ny := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, newYork)
+	}
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", utc(2021, 1, 1, 10, 0).Add(30 * time.Second), utc(2021, 1, 1, 10, 1)},
+		{"strictly after", "0 * * * *", utc(2021, 1, 1, 10, 0), utc(2021, 1, 1, 11, 0)},
+		{"step", "*/15 * * * *", utc(2021, 1, 1, 10, 16), utc(2021, 1, 1, 10, 30)},
+		{"list and range", "0 9-17/4,22 * * *", utc(2021, 1, 1, 18, 0), utc(2021, 1, 1, 22, 0)},
+		{"end of day", "30 1 * * *", utc(2021, 1, 1, 23, 59), utc(2021, 1, 2, 1, 30)},
+		{"end of month", "0 0 * * *", utc(2021, 4, 30, 12, 0), utc(2021, 5, 1, 0, 0)},
+		{"skips short months", "0 0 31 * *", utc(2021, 1, 31, 12, 0), utc(2021, 3, 31, 0, 0)},
+		{"end of year", "0 0 1 1 *", utc(2020, 6, 1, 0, 0), utc(2021, 1, 1, 0, 0)},
+		{"new year's eve", "59 23 31 12 *", utc(2020, 12, 31, 23, 59), utc(2021, 12, 31, 23, 59)},
+		{"leap day", "0 0 29 2 *", utc(2021, 3, 1, 0, 0), utc(2024, 2, 29, 0, 0)},
+		{"never", "0 0 30 2 *", utc(2021, 1, 1, 0, 0), time.Time{}},
+		{"day of week", "0 0 * * 1", utc(2021, 1, 1, 0, 0), utc(2021, 1, 4, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", utc(2021, 1, 1, 0, 0), utc(2021, 1, 3, 0, 0)},
+		{"day of month or week", "0 0 13 * 5", utc(2021, 1, 9, 0, 0), utc(2021, 1, 13, 0, 0)},
+		// a stepped * still only narrows the other day field
+		{"stepped day of month and week", "0 0 */2 * 1", utc(2021, 1, 1, 0, 0), utc(2021, 1, 11, 0, 0)},
+		{"macro", "@monthly", utc(2021, 12, 15, 0, 0), utc(2022, 1, 1, 0, 0)},
+		// 02:30 does not exist on the day clocks go forward, the next one is the day after
+		{"spring forward skipped", "30 2 * * *", ny(2021, 3, 14, 0, 0), ny(2021, 3, 15, 2, 30)},
+		{"spring forward hourly", "0 * * * *", ny(2021, 3, 14, 1, 30), ny(2021, 3, 14, 3, 0)},
+		{"fall back", "30 1 * * *", ny(2021, 11, 7, 0, 0), ny(2021, 11, 7, 1, 30)},
+		// the hour repeated when clocks go back matches twice
+		{"fall back repeated", "30 1 * * *", ny(2021, 11, 7, 1, 30), ny(2021, 11, 7, 1, 30).Add(time.Hour)},
+		{"fall back hourly", "0 * * * *", ny(2021, 11, 7, 1, 30).Add(time.Hour), ny(2021, 11, 7, 2, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+			if !got.IsZero() && got.Location() != tt.from.Location() {
+				t.Errorf("Next(%v) is in %v, want the location of the time given", tt.from, got.Location())
+			}
+		})
+	}
+}
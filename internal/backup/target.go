@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// NewBackupTarget creates the target selected by the configuration, it is nil when backups are disabled
+func NewBackupTarget(config internal.Configuration, clock internal.Clock) internal.BackupTarget {
+	target, err := NewTarget(context.Background(), config, clock)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to create backup target")
+	}
+	if target == nil {
+		return nil
+	}
+	logrus.WithField("target", config.BackupTarget).Info("backup target created")
+	return target
+}
+
+func NewTarget(ctx context.Context, config internal.Configuration, clock internal.Clock) (internal.BackupTarget, error) {
+	switch config.BackupTarget {
+	case "":
+		return nil, nil
+	case internal.BackupLocal:
+		return NewLocalTarget(config.BackupDir)
+	case internal.BackupS3:
+		return NewS3Target(S3Options{
+			Endpoint:  config.S3Endpoint,
+			Region:    config.S3Region,
+			Bucket:    config.BucketName,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			PathStyle: config.S3PathStyle,
+			Clock:     clock,
+		})
+	case internal.BackupGCS:
+		return NewGCSTarget(ctx, config.BucketName)
+	default:
+		return nil, fmt.Errorf("unknown backup target %q", config.BackupTarget)
+	}
+}
+
+// validName refuses names which would escape the directory or bucket prefix of a target
+func validName(name string) error {
+	if name == "" || path.Base(name) != name || name == "." || name == ".." {
+		return fmt.Errorf("%w: invalid backup name %q", internal.ErrInvalid, name)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package internal
+
+import "errors"
+
+// bulk operations
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkTag    = "tag"
+	BulkUntag  = "untag"
+)
+
+// ErrAborted is the result of the operations of an all or nothing bulk request which were not applied
+// because another operation failed
+var ErrAborted = errors.New("bulk request aborted")
+
+// BulkOperation is a single write of a bulk request. Create and update take the resource, tag and untag
+// take the id of the resource and the tag names.
+type BulkOperation struct {
+	Op       string    `json:"op"`
+	Resource *Resource `json:"resource,omitempty"`
+	ID       string    `json:"id,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// BulkResult is the outcome of one operation, Err is nil when it was applied
+type BulkResult struct {
+	Index    int
+	Op       string
+	ID       string
+	Resource *Resource
+	Err      error
+}
+
+type ResourceBulkWriter interface {
+	// BulkResources applies the operations in order, when atomic is set either every operation is applied or
+	// none are
+	BulkResources(ops []BulkOperation, atomic bool) ([]BulkResult, error)
+}
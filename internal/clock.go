@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock tells the repository the current time, it is injected so tests can control timestamps
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+func NewSystemClock() Clock {
+	return systemClock{}
+}
+
+// ParseSince accepts either an RFC 3339 timestamp or a duration such as 24h counted back from now
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%w: %q is neither a timestamp nor a duration", ErrInvalid, value)
+	}
+	return now.Add(-d), nil
+}
@@ -1,9 +1,14 @@
 package internal
 
-import "math/rand"
+import (
+	"math/rand"
+	"regexp"
+)
 
 type Color string
 
+var colorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
 const (
 	Black          Color = "#000000"
 	Blue           Color = "#0000FF"
@@ -76,3 +81,8 @@ func GetRandomColor() Color {
 	idx := rand.Intn(len(allColors))
 	return allColors[idx]
 }
+
+// Valid checks that the color is a hex encoded RGB value such as #1E90FF
+func (c Color) Valid() bool {
+	return colorPattern.MatchString(string(c))
+}
@@ -1,15 +1,145 @@
 package internal
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTrashRetention    = 30 * 24 * time.Hour
+	defaultIdempotencyTTL    = 24 * time.Hour
+	defaultHistoryLimit      = 100
+	defaultBackupPrefix      = "tags"
+	defaultBackupRetention   = 7
+	defaultBackupDebounce    = 30 * time.Second
+	defaultBackupMaxInterval = 10 * time.Minute
+	defaultS3Region          = "us-east-1"
+)
+
+// backup targets
+const (
+	BackupLocal = "local"
+	BackupS3    = "s3"
+	BackupGCS   = "gcs"
+)
 
 type Configuration struct {
 	DatabaseFile string
+	GraphPath    string
 	BucketName   string
+	TagPolicy    TagPolicy
+	// IDFormat is the format of server generated resource ids, see NewIDGenerator
+	IDFormat string
+	// TrashRetention is how long deleted resources and tags can be restored, zero keeps them forever
+	TrashRetention time.Duration
+	// IdempotencyTTL is how long responses are kept for replay to requests retried with an idempotency key
+	IdempotencyTTL time.Duration
+	// HistoryLimit is the number of revisions kept for each resource, the oldest are dropped first. Zero
+	// keeps every revision.
+	HistoryLimit int
+	// BackupTarget is where backups are written, one of local, s3 or gcs, backups are disabled when empty.
+	// The s3 and gcs targets write to BucketName.
+	BackupTarget string
+	// BackupDir is the directory of the local target
+	BackupDir string
+	// BackupPrefix starts the name of every backup, it is followed by the time of the backup
+	BackupPrefix string
+	// BackupRetention is the number of backups kept, older ones are deleted after each backup. Zero keeps
+	// every backup.
+	BackupRetention int
+	// BackupDebounce is how long writes have to stop before they are backed up, zero only backs up on
+	// BackupSchedule and at shutdown
+	BackupDebounce time.Duration
+	// BackupMaxInterval bounds how long a write waits for a backup while writes keep coming, zero waits for
+	// the debounce
+	BackupMaxInterval time.Duration
+	// BackupSchedule is a cron expression of times to back up pending writes at, see backup.ParseSchedule
+	BackupSchedule string
+	// AdminToken is the bearer token guarding the import and backup endpoints, they are refused when it is empty
+	AdminToken string
+	// S3Endpoint is the URL of an S3 compatible service, AWS is used when empty
+	S3Endpoint  string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+	// S3PathStyle addresses the bucket in the path rather than the host name, as most S3 compatible
+	// services expect. It defaults to true when an endpoint is set.
+	S3PathStyle bool
 }
 
 func LoadEnvConfiguration() Configuration {
-	return Configuration{
-		DatabaseFile: os.Getenv("DB_FILE"),
-		BucketName:   os.Getenv("BUCKET_NAME"),
+	config := Configuration{
+		DatabaseFile:   os.Getenv("DB_FILE"),
+		GraphPath:      os.Getenv("GRAPH_PATH"),
+		BucketName:     os.Getenv("BUCKET_NAME"),
+		TagPolicy:      DefaultTagPolicy(),
+		IDFormat:       os.Getenv("ID_FORMAT"),
+		TrashRetention: defaultTrashRetention,
+		IdempotencyTTL: defaultIdempotencyTTL,
+		HistoryLimit:   defaultHistoryLimit,
+
+		BackupTarget:      os.Getenv("BACKUP_TARGET"),
+		BackupDir:         os.Getenv("BACKUP_DIR"),
+		BackupPrefix:      defaultBackupPrefix,
+		BackupRetention:   defaultBackupRetention,
+		BackupDebounce:    defaultBackupDebounce,
+		BackupMaxInterval: defaultBackupMaxInterval,
+		BackupSchedule:    os.Getenv("BACKUP_SCHEDULE"),
+		AdminToken:        os.Getenv("ADMIN_TOKEN"),
+		S3Endpoint:        os.Getenv("S3_ENDPOINT"),
+		S3Region:          defaultS3Region,
+		S3AccessKey:       os.Getenv("AWS_ACCESS_KEY_ID"),
+		S3SecretKey:       os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if config.GraphPath == "" && config.DatabaseFile != "" {
+		config.GraphPath = config.DatabaseFile + ".graph"
+	}
+	if v, err := strconv.ParseBool(os.Getenv("TAG_CASE_FOLD")); err == nil {
+		config.TagPolicy.CaseFold = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("TAG_SLUG")); err == nil {
+		config.TagPolicy.Slug = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("TAG_MAX_LENGTH")); err == nil {
+		config.TagPolicy.MaxLength = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("TRASH_RETENTION")); err == nil {
+		config.TrashRetention = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil && v > 0 {
+		config.IdempotencyTTL = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("HISTORY_LIMIT")); err == nil && v >= 0 {
+		config.HistoryLimit = v
+	}
+	if v := os.Getenv("BACKUP_PREFIX"); v != "" {
+		config.BackupPrefix = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BACKUP_RETENTION")); err == nil && v >= 0 {
+		config.BackupRetention = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("BACKUP_DEBOUNCE")); err == nil && v >= 0 {
+		config.BackupDebounce = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("BACKUP_MAX_INTERVAL")); err == nil && v >= 0 {
+		config.BackupMaxInterval = v
+	}
+	if v := os.Getenv("S3_REGION"); v != "" {
+		config.S3Region = v
+	}
+	config.S3PathStyle = config.S3Endpoint != ""
+	if v, err := strconv.ParseBool(os.Getenv("S3_PATH_STYLE")); err == nil {
+		config.S3PathStyle = v
+	}
+	if v, ok := os.LookupEnv("TAG_RESERVED"); ok {
+		config.TagPolicy.Reserved = nil
+		for _, word := range strings.Split(v, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				config.TagPolicy.Reserved = append(config.TagPolicy.Reserved, word)
+			}
+		}
 	}
+	return config
 }
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func taggedSince(t *testing.T, repo *repository, params internal.ResourceParams) []string {
+	t.Helper()
+	resources, _, err := repo.FindAllResources(&params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, re := range resources {
+		ids = append(ids, re.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestFindTaggedSince(t *testing.T) {
+	env := newTestRepository(t)
+	r1 := mustCreateResource(t, env.repo, "r1", "go")
+	r3 := mustCreateResource(t, env.repo, "r3", "urgent")
+
+	env.clock.Advance(48 * time.Hour)
+	if _, err := env.repo.AddTagToResource(r1, "urgent"); err != nil {
+		t.Fatal(err)
+	}
+	env.clock.Advance(time.Hour)
+	mustCreateResource(t, env.repo, "r2", "urgent")
+	// updating r3 keeps when its tag was assigned
+	r3.Name = "renamed"
+	if _, err := env.repo.UpdateResource(r3); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := taggedSince(t, env.repo, internal.ResourceParams{Tag: "urgent", TaggedSince: "24h"}); len(got) != 2 || got[0] != "r1" || got[1] != "r2" {
+		t.Errorf("tagged urgent within 24h: %v, want [r1 r2]", got)
+	}
+	if got := taggedSince(t, env.repo, internal.ResourceParams{Tag: "go", TaggedSince: "24h"}); len(got) != 0 {
+		t.Errorf("tagged go within 24h: %v, want none", got)
+	}
+	since := env.clock.Now().Add(-72 * time.Hour).Format(time.RFC3339)
+	if got := taggedSince(t, env.repo, internal.ResourceParams{TaggedSince: since}); len(got) != 3 {
+		t.Errorf("tagged since %s: %v, want every resource", since, got)
+	}
+
+	env.clock.Advance(48 * time.Hour)
+	if got := taggedSince(t, env.repo, internal.ResourceParams{Tag: "urgent", TaggedSince: "24h"}); len(got) != 0 {
+		t.Errorf("tagged urgent within 24h two days later: %v, want none", got)
+	}
+}
+
+func TestActorAndTimestamps(t *testing.T) {
+	env := newTestRepository(t)
+	created := env.clock.Now()
+	res, err := env.repo.WithActor("claimed:alice").CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note", Tags: []internal.Tag{{Name: "go"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.CreatedAt.Equal(created) || !res.UpdatedAt.Equal(created) || res.CreatedBy != "claimed:alice" {
+		t.Errorf("created %v by %q, updated %v", res.CreatedAt, res.CreatedBy, res.UpdatedAt)
+	}
+	if tag := res.Tags[0]; tag.TaggedAt == nil || !tag.TaggedAt.Equal(created) || tag.TaggedBy != "claimed:alice" {
+		t.Errorf("tag assigned %v by %q", tag.TaggedAt, tag.TaggedBy)
+	}
+
+	env.clock.Advance(time.Minute)
+	res, err = env.repo.WithActor("claimed:bob").AddTagToResource(res, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.CreatedAt.Equal(created) || res.CreatedBy != "claimed:alice" {
+		t.Errorf("creation changed to %v by %q", res.CreatedAt, res.CreatedBy)
+	}
+	if !res.UpdatedAt.Equal(env.clock.Now()) || res.UpdatedBy != "claimed:bob" {
+		t.Errorf("updated %v by %q", res.UpdatedAt, res.UpdatedBy)
+	}
+
+	if _, err := env.repo.WithActor("claimed:carol").MergeTag("cli", "go"); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := env.repo.FindResourceHistory("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := revisions[len(revisions)-1]
+	if last.By != "claimed:carol" {
+		t.Errorf("merge recorded by %q, want claimed:carol", last.By)
+	}
+}
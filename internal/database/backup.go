@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal/backup"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+// backupTimeFormat orders backup names by the time they were taken
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// ShutdownBackupTimeout bounds the backup of pending writes when the app stops. It does not run under the
+// context of the stop hook, the app has to allow it in its stop timeout.
+const ShutdownBackupTimeout = time.Minute
+
+// backupName is the name of a backup taken at t
+func backupName(prefix string, t time.Time) string {
+	return prefix + "-" + t.UTC().Format(backupTimeFormat) + ".bolt"
+}
+
+// backupScheduler coalesces writes into backups. A backup runs once writes stop for the debounce, once the
+// oldest pending write waited the max interval, at the scheduled times and when the app stops, each only
+// when there are writes since the last backup. Backups run one at a time on the scheduler goroutine.
+type backupScheduler struct {
+	kv          *boltkv
+	debounce    time.Duration
+	maxInterval time.Duration
+	schedule    *backup.Schedule
+	// changes holds a signal of writes not yet seen by the scheduler
+	changes chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newBackupScheduler(lc fx.Lifecycle, kv *boltkv, debounce, maxInterval time.Duration, schedule *backup.Schedule) *backupScheduler {
+	s := &backupScheduler{
+		kv:          kv,
+		debounce:    debounce,
+		maxInterval: maxInterval,
+		schedule:    schedule,
+		changes:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go s.run()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			select {
+			case s.stop <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			select {
+			case <-s.done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	return s
+}
+
+// notify records a committed write, it never blocks the writer
+func (s *backupScheduler) notify() {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (s *backupScheduler) run() {
+	defer close(s.done)
+	var pending bool
+	var first, last time.Time
+	for {
+		var wait <-chan time.Time
+		var timer *time.Timer
+		if pending {
+			if due, ok := s.due(first, last); ok {
+				timer = time.NewTimer(time.Until(due))
+				wait = timer.C
+			}
+		}
+		select {
+		case <-s.changes:
+			last = time.Now()
+			if !pending {
+				pending, first = true, last
+			}
+		case <-wait:
+			pending = false
+			s.kv.runBackup(context.Background())
+		case <-s.stop:
+			// a write signalled alongside the stop may not have been picked yet
+			select {
+			case <-s.changes:
+				pending = true
+			default:
+			}
+			if pending {
+				logrus.Info("backing up pending writes before stopping")
+				ctx, cancel := context.WithTimeout(context.Background(), ShutdownBackupTimeout)
+				s.kv.runBackup(ctx)
+				cancel()
+			}
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// due returns when pending writes are backed up, it is false when they wait for the app to stop
+func (s *backupScheduler) due(first, last time.Time) (time.Time, bool) {
+	var due time.Time
+	earliest := func(t time.Time) {
+		if !t.IsZero() && (due.IsZero() || t.Before(due)) {
+			due = t
+		}
+	}
+	if s.debounce > 0 {
+		earliest(last.Add(s.debounce))
+		if s.maxInterval > 0 {
+			earliest(first.Add(s.maxInterval))
+		}
+	}
+	if s.schedule != nil {
+		earliest(s.schedule.Next(first))
+	}
+	return due, !due.IsZero()
+}
+
+// changed tells the scheduler about a committed write
+func (b *boltkv) changed() {
+	if b.backups != nil {
+		b.backups.notify()
+	}
+}
+
+func (b *boltkv) runBackup(ctx context.Context) {
+	name := backupName(b.backupPrefix, b.clock.Now())
+	logger := logrus.WithField("name", name)
+	logger.Info("running backup")
+	if err := b.writeBackup(ctx, name); err != nil {
+		logger.WithError(err).Error("unable to backup file")
+		return
+	}
+	logger.Info("backup complete")
+	if err := b.pruneBackups(ctx); err != nil {
+		logrus.WithError(err).Error("unable to delete old backups")
+	}
+}
+
+// writeBackup copies the database to a temporary file next to it and uploads the copy, the read
+// transaction is released before the upload so a slow target does not hold it
+func (b *boltkv) writeBackup(ctx context.Context, name string) error {
+	f, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var size int64
+	err = b.view(func(tx *bolt.Tx) error {
+		var err error
+		size, err = tx.WriteTo(f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return b.target.Write(ctx, name, f, size)
+}
+
+// pruneBackups deletes the oldest backups beyond the retention
+func (b *boltkv) pruneBackups(ctx context.Context) error {
+	if b.backupRetention == 0 {
+		return nil
+	}
+	backups, err := b.target.List(ctx, b.backupPrefix+"-")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(backups)-b.backupRetention; i++ {
+		if err := b.target.Delete(ctx, backups[i].Name); err != nil {
+			return err
+		}
+		logrus.WithField("name", backups[i].Name).Info("old backup deleted")
+	}
+	return nil
+}
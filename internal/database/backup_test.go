@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/backup"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestRunBackup(t *testing.T) {
+	env := newTestRepository(t)
+	target, err := backup.NewLocalTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.kv.clock, env.kv.target = env.clock, target
+	env.kv.backupPrefix, env.kv.backupRetention = "tags", 2
+	mustCreateResource(t, env.repo, "r1", "go")
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		env.kv.runBackup(ctx)
+		env.clock.Advance(time.Hour)
+	}
+
+	backups, err := target.List(ctx, "tags-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		backupName("tags", time.Date(2020, 6, 1, 13, 0, 0, 0, time.UTC)),
+		backupName("tags", time.Date(2020, 6, 1, 14, 0, 0, 0, time.UTC)),
+	}
+	if len(backups) != 2 || backups[0].Name != want[0] || backups[1].Name != want[1] {
+		t.Fatalf("kept %v, want the two most recent %v", backups, want)
+	}
+
+	tmp, report, err := fetchBackup(ctx, target, backups[1].Name, env.config.DatabaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp)
+	if report.Resources != 1 || report.Tags != 1 {
+		t.Errorf("backup holds %d resources and %d tags, want 1 and 1", report.Resources, report.Tags)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(env.config.DatabaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".backup-") {
+			t.Errorf("snapshot %s left behind", f.Name())
+		}
+	}
+}
+
+// TestBackupOnStop covers writes waiting for the app to stop, they are backed up by the stop hook
+func TestBackupOnStop(t *testing.T) {
+	target, err := backup.NewLocalTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := internal.Configuration{
+		DatabaseFile:    filepath.Join(t.TempDir(), "db.bolt"),
+		BackupPrefix:    "tags",
+		BackupRetention: 2,
+	}
+	clock := &testClock{now: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	ctx := context.Background()
+
+	lc := fxtest.NewLifecycle(t)
+	kv := NewBoltConnectionWithBackup(lc, config, clock, target)
+	lc.RequireStart()
+	lc.RequireStop()
+	if backups, err := target.List(ctx, "tags-"); err != nil || len(backups) != 0 {
+		t.Fatalf("stopping without writes backed up %v %v", backups, err)
+	}
+
+	lc = fxtest.NewLifecycle(t)
+	kv = NewBoltConnectionWithBackup(lc, config, clock, target)
+	lc.RequireStart()
+	if err := kv.PutTag("go", &internal.Tag{Name: "go"}); err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStop()
+	backups, err := target.List(ctx, "tags-")
+	if err != nil || len(backups) != 1 || backups[0].Name != backupName("tags", clock.Now()) {
+		t.Fatalf("stopping after a write backed up %v %v, want one backup", backups, err)
+	}
+}
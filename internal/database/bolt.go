@@ -1,82 +1,109 @@
 package database
 
 import (
-	"cloud.google.com/go/storage"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/backup"
 	"github.com/sirupsen/logrus"
 	"go.uber.org/fx"
-	"io"
-	"os"
+	"sync"
+	"time"
 )
 
 var (
 	tagBucket      = []byte("tags")
 	resourceBucket = []byte("resources")
+	aliasBucket    = []byte("aliases")
+	metaBucket     = []byte("meta")
+	typeBucket     = []byte("types")
 )
 
 type KVStore interface {
 	GetResource(id string) (internal.Resource, error)
 	GetAllResources() ([]internal.Resource, error)
-	PutResource(id string, resource internal.Resource) error
+	GetResourcesUpdatedSince(since time.Time) ([]internal.Resource, error)
+	ListResources(page Page, include func(id string) bool) ([]internal.Resource, string, error)
+	MutateResource(id string, cond internal.Precondition, change Change, fn ResourceMutation) (internal.Resource, error)
+	Batch(fn func(batch KVBatch) error) error
+	Snapshot(fn func(snap KVSnapshot) error) error
+	GetRevisions(id string) ([]internal.Revision, error)
+	GetRevision(id string, revision uint64) (internal.Revision, error)
+	GetRevisionAt(id string, at time.Time) (internal.Revision, error)
 	GetTag(id string) (internal.Tag, error)
 	GetAllTags() ([]internal.Tag, error)
-	PutTag(id string, tag internal.Tag) error
+	ListTags(page Page, include func(name string) bool) ([]internal.Tag, string, error)
+	PutTag(id string, tag *internal.Tag, ops ...GraphOp) error
+	ReplaceTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error
+	RenameTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error
+	GetAlias(alias string) (string, error)
+	GetAliases(tag string) ([]string, error)
+	PutAlias(alias string, tag string) error
+	DeleteAlias(alias string) error
+	GetAllAliases() (map[string]string, error)
+	GetType(name string) (internal.ResourceType, error)
+	GetAllTypes() ([]internal.ResourceType, error)
+	PutType(name string, t internal.ResourceType) error
+	DeleteType(name string) error
+	GetMeta(key string) (string, error)
+	PutMeta(key string, value string) error
+	GetTrash(kind string) ([]internal.TrashItem, error)
+	GetTrashItem(kind string, id string) (internal.TrashItem, error)
+	PurgeTrash(before time.Time) (int, error)
+	ReserveIdempotencyKey(req internal.IdempotentRequest, expired time.Time) (internal.IdempotentRequest, bool, error)
+	PutIdempotentRequest(req internal.IdempotentRequest) error
+	DeleteIdempotencyKey(key string) error
+	PurgeIdempotencyKeys(before time.Time) (int, error)
+	GetRetagJob(id string) (internal.RetagJob, error)
+	GetRetagJobs() ([]internal.RetagJob, error)
+	PutRetagJob(job internal.RetagJob) error
+	PurgeRetagJobs(before time.Time) (int, error)
+	PendingGraphOps() ([]PendingGraphOp, error)
+	AckGraphOps(through uint64) error
+	ListBackups(ctx context.Context) ([]internal.Backup, error)
+	RestoreBackup(ctx context.Context, name string) (internal.RestoreReport, error)
 }
 
 type boltkv struct {
-	conn     *bolt.DB
-	fileName string
-	bucket   *storage.BucketHandle
+	// mu is held for reading by transactions and for writing while a restore replaces conn
+	mu   sync.RWMutex
+	conn *bolt.DB
+	path string
+	// historyLimit is the number of revisions kept per resource, zero keeps them all
+	historyLimit int
+	// clock dates backups and restores
+	clock internal.Clock
+	// backups are written to target after changes when it is set
+	target          internal.BackupTarget
+	backupPrefix    string
+	backupRetention int
+	backups         *backupScheduler
 }
 
-func NewBoltConnectionWithBackup(lc fx.Lifecycle, config internal.Configuration) KVStore {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		logrus.WithError(err).Fatal("unable to create google bucket client")
-	}
-	bucket := client.Bucket(config.BucketName)
-
-	if err := downloadDatabase(bucket, config.BucketName); err != nil {
-		logrus.WithError(err).Warn("unable to download database a new one will be created")
-	}
+// NewBoltConnectionWithBackup opens the database and backs it up to the configured target after changes
+func NewBoltConnectionWithBackup(lc fx.Lifecycle, config internal.Configuration, clock internal.Clock, target internal.BackupTarget) KVStore {
 	conn := newBoltConnection(lc, config)
-	conn.bucket = bucket
-	return conn
-}
-
-func downloadDatabase(bucket *storage.BucketHandle, name string) error {
-	logrus.WithField("name", name).Info("downloading database")
-	ctx := context.Background()
-	reader, err := bucket.Object(name).NewReader(ctx)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create reader")
-		return errors.New("unable to download file")
-	}
-
-	defer reader.Close()
-	if err := os.Remove(name); err != nil {
-		logrus.WithError(err).Error("unable to remove file")
-	}
-
-	f, err := os.Open(name)
-	if err != nil {
-		logrus.WithError(err).Error("unable to create file")
-		return errors.New("unable to download file")
+	conn.clock = clock
+	if target == nil {
+		logrus.Info("backup not enabled")
+		return conn
 	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, reader); err != nil {
-		logrus.WithError(err).Error("unable to write file")
-		return errors.New("unable to download file")
+	var schedule *backup.Schedule
+	if config.BackupSchedule != "" {
+		var err error
+		if schedule, err = backup.ParseSchedule(config.BackupSchedule); err != nil {
+			logrus.WithError(err).Fatal("invalid backup schedule")
+		}
 	}
-	logrus.WithField("name", name).Info("database downloaded")
-	return nil
+	conn.target = target
+	conn.backupPrefix = config.BackupPrefix
+	conn.backupRetention = config.BackupRetention
+	conn.backups = newBackupScheduler(lc, conn, config.BackupDebounce, config.BackupMaxInterval, schedule)
+	return conn
 }
 
 func newBoltConnection(lc fx.Lifecycle, configuration internal.Configuration) *boltkv {
@@ -91,33 +118,110 @@ func newBoltConnection(lc fx.Lifecycle, configuration internal.Configuration) *b
 	if err != nil {
 		logrus.WithError(err).Fatal("unable to open database")
 	}
+	err = conn.Update(createBuckets)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to create buckets")
+	}
+
+	kv := &boltkv{
+		conn:         conn,
+		path:         dbFile,
+		historyLimit: configuration.HistoryLimit,
+	}
 	lc.Append(fx.Hook{
-		OnStart: func(context.Context) error {
-			return nil
-		},
 		OnStop: func(ctx context.Context) error {
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
 			logrus.Info("closing database")
-			return conn.Close()
+			return kv.conn.Close()
 		},
 	})
-	err = conn.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(resourceBucket)
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-		_, err = tx.CreateBucketIfNotExists(tagBucket)
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+	return kv
+}
+
+// createBuckets creates the buckets and indexes missing from a database
+func createBuckets(tx *bolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(resourceBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(tagBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(outboxBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(aliasBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(typeBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(historyBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(idempotencyBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	_, err = tx.CreateBucketIfNotExists(retagJobBucket)
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	if err := createTrashBuckets(tx); err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	if err := createIndexes(tx, resourceBucket, resourceIndexes); err != nil {
+		return fmt.Errorf("create index: %s", err)
+	}
+	if err := createIndexes(tx, tagBucket, tagIndexes); err != nil {
+		return fmt.Errorf("create index: %s", err)
+	}
+	return nil
+}
+
+// view and update run fn on the open database, a restore waits for them before replacing it
+func (b *boltkv) view(fn func(tx *bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.conn.View(fn)
+}
+
+func (b *boltkv) update(fn func(tx *bolt.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.conn.Update(fn)
+}
+
+// purgeBucket deletes the entries of a bucket for which expired returns true and returns their keys. The
+// keys are collected before deleting as bolt cursors skip entries when deleting while iterating.
+func purgeBucket(bucket *bolt.Bucket, expired func(v []byte) (bool, error)) ([][]byte, error) {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		ok, err := expired(v)
+		if ok {
+			keys = append(keys, append([]byte(nil), k...))
 		}
-		return nil
+		return err
 	})
 	if err != nil {
-		logrus.WithError(err).Fatal("unable to create buckets")
+		return nil, err
 	}
-
-	return &boltkv{
-		conn: conn,
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return nil, err
+		}
 	}
+	return keys, nil
 }
 
 func NewBoltConnection(lc fx.Lifecycle, configuration internal.Configuration) KVStore {
@@ -126,7 +230,7 @@ func NewBoltConnection(lc fx.Lifecycle, configuration internal.Configuration) KV
 
 func (b *boltkv) GetResource(id string) (internal.Resource, error) {
 	var resource internal.Resource
-	err := b.conn.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(resourceBucket)
 		res := bucket.Get([]byte(id))
 		if res == nil {
@@ -143,7 +247,7 @@ func (b *boltkv) GetResource(id string) (internal.Resource, error) {
 
 func (b *boltkv) GetAllResources() ([]internal.Resource, error) {
 	var resources []internal.Resource
-	err := b.conn.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(resourceBucket)
 		return bucket.ForEach(func(k, v []byte) error {
 			var res internal.Resource
@@ -161,46 +265,231 @@ func (b *boltkv) GetAllResources() ([]internal.Resource, error) {
 	return resources, nil
 }
 
-func (b *boltkv) runBackup() {
-	if b.bucket == nil {
-		logrus.Info("backup not enabled")
-		return
-	}
-	ctx := context.Background()
-	logrus.Info("running backup")
-	writer := b.bucket.Object(b.fileName).NewWriter(ctx)
-	defer writer.Close()
-	err := b.conn.View(func(tx *bolt.Tx) error {
-		_, err := tx.WriteTo(writer)
-		return err
+// GetResourcesUpdatedSince returns the resources last updated at or after the given time, oldest first
+func (b *boltkv) GetResourcesUpdatedSince(t time.Time) ([]internal.Resource, error) {
+	var resources []internal.Resource
+	err := b.view(func(tx *bolt.Tx) error {
+		for _, v := range since(tx, resourceBucket, resourceIndexes["updated"], t) {
+			var res internal.Resource
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			resources = append(resources, res)
+		}
+		return nil
 	})
 	if err != nil {
-		logrus.WithError(err).Error("unable to backup file")
-		return
+		logrus.WithError(err).Error("unable to fetch results for resources")
+		return resources, errors.New("unable to fetch results")
 	}
-	logrus.Info("backup complete")
+	return resources, nil
 }
 
-func (b *boltkv) PutResource(id string, resource internal.Resource) error {
-	return b.conn.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(resourceBucket)
-		rbytes, err := json.Marshal(resource)
+func (b *boltkv) ListResources(page Page, include func(id string) bool) ([]internal.Resource, string, error) {
+	var resources []internal.Resource
+	var next string
+	err := b.view(func(tx *bolt.Tx) error {
+		values, cursor, err := list(tx, resourceBucket, "id", resourceIndexes, page, include)
 		if err != nil {
-			logrus.WithError(err).Error("unable to marshall resource")
-			return errors.New("unable to store resource")
+			return err
 		}
-		if err := bucket.Put([]byte(id), rbytes); err != nil {
-			logrus.WithError(err).Error("unable to write resource")
-			return errors.New("unable to store resource")
+		for _, v := range values {
+			var res internal.Resource
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			resources = append(resources, res)
 		}
-		go b.runBackup()
+		next = cursor
 		return nil
 	})
+	if err == internal.ErrInvalid {
+		return nil, "", err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to list resources")
+		return nil, "", errors.New("unable to fetch results")
+	}
+	return resources, next, nil
+}
+
+// ResourceMutation changes a resource inside a write transaction. It is given the stored resource, or nil
+// when there is none, and returns the resource to store, or nil to delete it, with the graph ops of the change.
+type ResourceMutation func(current *internal.Resource) (*internal.Resource, []GraphOp, error)
+
+// MutateResource reads, changes and stores a resource in a single transaction so concurrent writes cannot
+// overwrite each other. The precondition is checked against the stored revision before fn runs. Every change
+// is appended to the resource history and deleted resources are moved to the trash.
+func (b *boltkv) MutateResource(id string, cond internal.Precondition, change Change, fn ResourceMutation) (internal.Resource, error) {
+	var result internal.Resource
+	err := b.Batch(func(batch KVBatch) error {
+		var err error
+		result, err = batch.MutateResource(id, cond, change, fn)
+		return err
+	})
+	return result, err
+}
+
+// KVBatch makes writes inside a single transaction, see Batch
+type KVBatch interface {
+	GetTag(id string) (internal.Tag, error)
+	PutTag(id string, tag *internal.Tag, ops ...GraphOp) error
+	ReplaceTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error
+	PutAlias(alias string, tag string) error
+	DeleteAlias(alias string) error
+	MutateResource(id string, cond internal.Precondition, change Change, fn ResourceMutation) (internal.Resource, error)
+}
+
+// Batch makes the writes of fn in a single transaction, they are all rolled back when fn returns an error
+func (b *boltkv) Batch(fn func(batch KVBatch) error) error {
+	err := b.update(func(tx *bolt.Tx) error {
+		return fn(txkv{tx: tx, historyLimit: b.historyLimit})
+	})
+	if err == nil {
+		b.changed()
+	}
+	return err
+}
+
+// KVSnapshot iterates the records as of the start of a read transaction, see Snapshot
+type KVSnapshot interface {
+	ForEachTag(fn func(tag internal.Tag) error) error
+	ForEachAlias(fn func(alias string, tag string) error) error
+	ForEachResource(fn func(resource internal.Resource) error) error
+}
+
+// Snapshot runs fn in a read transaction so the records it iterates are consistent with each other. A restore
+// waits for the transaction and writes growing the database may too, fn should return without waiting on
+// anything outside the store.
+func (b *boltkv) Snapshot(fn func(snap KVSnapshot) error) error {
+	return b.view(func(tx *bolt.Tx) error {
+		return fn(txkv{tx: tx, historyLimit: b.historyLimit})
+	})
+}
+
+// txkv reads and writes records within a bolt transaction
+type txkv struct {
+	tx           *bolt.Tx
+	historyLimit int
+}
+
+func (t txkv) MutateResource(id string, cond internal.Precondition, change Change, fn ResourceMutation) (internal.Resource, error) {
+	tx := t.tx
+	var current *internal.Resource
+	var revision uint64
+	if res := tx.Bucket(resourceBucket).Get([]byte(id)); res != nil {
+		current = &internal.Resource{}
+		if err := json.Unmarshal(res, current); err != nil {
+			logrus.WithError(err).Error("unable to unmarshall resource")
+			return internal.Resource{}, errors.New("unable to read resource")
+		}
+		revision = current.Revision
+	}
+	if err := cond.Check(current != nil, revision); err != nil {
+		return internal.Resource{}, err
+	}
+	next, ops, err := fn(current)
+	if err != nil {
+		return internal.Resource{}, err
+	}
+
+	var result internal.Resource
+	if next == nil {
+		if current == nil {
+			return result, internal.ErrNotFound
+		}
+		if err := deleteResource(tx, *current, change, t.historyLimit); err != nil {
+			logrus.WithError(err).Error("unable to delete resource")
+			return result, errors.New("unable to delete resource")
+		}
+		result = *current
+	} else {
+		if err := putResource(tx, id, next, change, t.historyLimit); err != nil {
+			logrus.WithError(err).Error("unable to write resource")
+			return result, errors.New("unable to store resource")
+		}
+		if err := removeTrash(tx, internal.TrashResource, id); err != nil {
+			logrus.WithError(err).Error("unable to update trash")
+			return result, errors.New("unable to store resource")
+		}
+		result = *next
+	}
+	if err := appendGraphOps(tx, ops); err != nil {
+		logrus.WithError(err).Error("unable to write graph ops")
+		return result, errors.New("unable to store resource")
+	}
+	return result, nil
+}
+
+// ForEachTag calls fn with every tag in name order
+func (t txkv) ForEachTag(fn func(tag internal.Tag) error) error {
+	return t.tx.Bucket(tagBucket).ForEach(func(k, v []byte) error {
+		var tag internal.Tag
+		if err := json.Unmarshal(v, &tag); err != nil {
+			return err
+		}
+		return fn(tag)
+	})
+}
+
+// ForEachAlias calls fn with every alias and its tag in alias order
+func (t txkv) ForEachAlias(fn func(alias string, tag string) error) error {
+	return t.tx.Bucket(aliasBucket).ForEach(func(k, v []byte) error {
+		return fn(string(k), string(v))
+	})
+}
+
+// ForEachResource calls fn with every resource in id order
+func (t txkv) ForEachResource(fn func(resource internal.Resource) error) error {
+	return t.tx.Bucket(resourceBucket).ForEach(func(k, v []byte) error {
+		var res internal.Resource
+		if err := json.Unmarshal(v, &res); err != nil {
+			return err
+		}
+		return fn(res)
+	})
+}
+
+// putResource appends the resource to its history and writes it, the tags of a resource do not keep the
+// revision of the tag record
+func putResource(tx *bolt.Tx, id string, resource *internal.Resource, change Change, historyLimit int) error {
+	tags := make([]internal.Tag, len(resource.Tags))
+	for i, t := range resource.Tags {
+		t.Revision = 0
+		tags[i] = t
+	}
+	resource.Tags = tags
+	if err := appendRevision(tx, id, resource, change, historyLimit); err != nil {
+		return err
+	}
+	rbytes, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	return putIndexed(tx, resourceBucket, resourceIndexes, []byte(id), rbytes)
+}
+
+// deleteResource removes a resource, moving it to the trash
+func deleteResource(tx *bolt.Tx, resource internal.Resource, change Change, historyLimit int) error {
+	item := internal.TrashItem{
+		Kind:      internal.TrashResource,
+		ID:        resource.ID,
+		DeletedAt: change.At,
+		DeletedBy: change.By,
+		Resource:  &resource,
+	}
+	if err := putTrash(tx, item); err != nil {
+		return err
+	}
+	if err := deleteIndexed(tx, resourceBucket, resourceIndexes, []byte(resource.ID)); err != nil {
+		return err
+	}
+	return appendRevision(tx, resource.ID, nil, change, historyLimit)
 }
 
 func (b *boltkv) GetTag(id string) (internal.Tag, error) {
 	var tag internal.Tag
-	err := b.conn.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(tagBucket)
 		res := bucket.Get([]byte(id))
 		if res == nil {
@@ -218,7 +507,7 @@ func (b *boltkv) GetTag(id string) (internal.Tag, error) {
 
 func (b *boltkv) GetAllTags() ([]internal.Tag, error) {
 	var tags []internal.Tag
-	err := b.conn.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(tagBucket)
 		return bucket.ForEach(func(k, v []byte) error {
 			var res internal.Tag
@@ -236,19 +525,489 @@ func (b *boltkv) GetAllTags() ([]internal.Tag, error) {
 	return tags, nil
 }
 
-func (b *boltkv) PutTag(id string, tag internal.Tag) error {
-	return b.conn.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(tagBucket)
+func (b *boltkv) ListTags(page Page, include func(name string) bool) ([]internal.Tag, string, error) {
+	var tags []internal.Tag
+	var next string
+	err := b.view(func(tx *bolt.Tx) error {
+		values, cursor, err := list(tx, tagBucket, "name", tagIndexes, page, include)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			var res internal.Tag
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			tags = append(tags, res)
+		}
+		next = cursor
+		return nil
+	})
+	if err == internal.ErrInvalid {
+		return nil, "", err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to list tags")
+		return nil, "", errors.New("unable to fetch tags")
+	}
+	return tags, next, nil
+}
+
+// PutTag stores a tag, setting its revision to the one after the stored tag
+func (b *boltkv) PutTag(id string, tag *internal.Tag, ops ...GraphOp) error {
+	return b.Batch(func(batch KVBatch) error {
+		return batch.PutTag(id, tag, ops...)
+	})
+}
+
+func (t txkv) PutTag(id string, tag *internal.Tag, ops ...GraphOp) error {
+	tx := t.tx
+	revision, err := tagRevision(tx, id)
+	if err != nil {
+		logrus.WithError(err).Error("unable to read tag")
+		return errors.New("unable to store tag")
+	}
+	tag.Revision = revision + 1
+	tbytes, err := json.Marshal(tag)
+	if err != nil {
+		logrus.WithError(err).Error("unable to marshall tag")
+		return errors.New("unable to store tag")
+	}
+	if err := putIndexed(tx, tagBucket, tagIndexes, []byte(id), tbytes); err != nil {
+		logrus.WithError(err).Error("unable to write tag")
+		return errors.New("unable to store tag")
+	}
+	if err := removeTrash(tx, internal.TrashTag, id); err != nil {
+		logrus.WithError(err).Error("unable to update trash")
+		return errors.New("unable to store tag")
+	}
+	if err := appendGraphOps(tx, ops); err != nil {
+		logrus.WithError(err).Error("unable to write graph ops")
+		return errors.New("unable to store tag")
+	}
+	return nil
+}
+
+func (t txkv) GetTag(id string) (internal.Tag, error) {
+	var tag internal.Tag
+	res := t.tx.Bucket(tagBucket).Get([]byte(id))
+	if res == nil {
+		return tag, internal.ErrNotFound
+	}
+	if err := json.Unmarshal(res, &tag); err != nil {
+		logrus.WithError(err).Error("unable to unmarshall tag")
+		return tag, err
+	}
+	return tag, nil
+}
+
+// ReplaceTag swaps the tag stored under id for the given tag in a single transaction, rewriting
+// every resource which references it. A nil tag removes the tag, moving it to the trash.
+func (b *boltkv) ReplaceTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error {
+	return b.Batch(func(batch KVBatch) error {
+		return batch.ReplaceTag(id, tag, cond, change, ops...)
+	})
+}
+
+// RenameTag replaces a tag with one of another name, failing with ErrConflict when a tag already has that
+// name. The check and the rename share a transaction so a tag created meanwhile cannot be overwritten.
+func (b *boltkv) RenameTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error {
+	return b.Batch(func(batch KVBatch) error {
+		if _, err := batch.GetTag(tag.Name); err != internal.ErrNotFound {
+			if err == nil {
+				return internal.ErrConflict
+			}
+			return err
+		}
+		return batch.ReplaceTag(id, tag, cond, change, ops...)
+	})
+}
+
+// ReplaceTag replaces or deletes a tag within the transaction, moving the resources and aliases of the old
+// tag to the new one. The precondition is checked against the revision of the old tag.
+func (t txkv) ReplaceTag(id string, tag *internal.Tag, cond internal.Precondition, change Change, ops ...GraphOp) error {
+	tx := t.tx
+	var affected []internal.Resource
+	existing := tx.Bucket(tagBucket).Get([]byte(id))
+	if existing == nil {
+		if err := cond.Check(false, 0); err != nil {
+			return err
+		}
+		return internal.ErrNotFound
+	}
+	var old internal.Tag
+	if err := json.Unmarshal(existing, &old); err != nil {
+		logrus.WithError(err).Error("unable to unmarshall tag")
+		return errors.New("unable to store tag")
+	}
+	if err := cond.Check(true, old.Revision); err != nil {
+		return err
+	}
+	if tag == nil || tag.Name != id {
+		if err := deleteIndexed(tx, tagBucket, tagIndexes, []byte(id)); err != nil {
+			logrus.WithError(err).Error("unable to delete tag")
+			return errors.New("unable to store tag")
+		}
+	}
+	if tag != nil {
+		revision, err := tagRevision(tx, tag.Name)
+		if err != nil {
+			logrus.WithError(err).Error("unable to read tag")
+			return errors.New("unable to store tag")
+		}
+		if old.Revision > revision {
+			revision = old.Revision
+		}
+		tag.Revision = revision + 1
 		tbytes, err := json.Marshal(tag)
 		if err != nil {
-			logrus.WithError(err).Error("unable to marshall resource")
-			return errors.New("unable to store resource")
+			logrus.WithError(err).Error("unable to marshall tag")
+			return errors.New("unable to store tag")
+		}
+		if err := putIndexed(tx, tagBucket, tagIndexes, []byte(tag.Name), tbytes); err != nil {
+			logrus.WithError(err).Error("unable to write tag")
+			return errors.New("unable to store tag")
+		}
+		if err := removeTrash(tx, internal.TrashTag, tag.Name); err != nil {
+			logrus.WithError(err).Error("unable to update trash")
+			return errors.New("unable to store tag")
+		}
+	}
+
+	err := tx.Bucket(resourceBucket).ForEach(func(k, v []byte) error {
+		var res internal.Resource
+		if err := json.Unmarshal(v, &res); err != nil {
+			return err
+		}
+		for _, t := range res.Tags {
+			if t.Name == id {
+				affected = append(affected, res)
+				break
+			}
 		}
-		if err := bucket.Put([]byte(id), tbytes); err != nil {
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to read resources")
+		return errors.New("unable to store tag")
+	}
+
+	for _, res := range affected {
+		updated := res
+		updated.Tags = replaceTag(res.Tags, id, tag)
+		updated.UpdatedAt, updated.UpdatedBy = change.At, change.By
+		if err := putResource(tx, res.ID, &updated, change, t.historyLimit); err != nil {
 			logrus.WithError(err).Error("unable to write resource")
 			return errors.New("unable to store resource")
 		}
-		go b.runBackup()
+	}
+	if tag == nil {
+		item := internal.TrashItem{
+			Kind:      internal.TrashTag,
+			ID:        id,
+			DeletedAt: change.At,
+			DeletedBy: change.By,
+			Tag:       &old,
+		}
+		for _, res := range affected {
+			item.Resources = append(item.Resources, res.ID)
+		}
+		err := tx.Bucket(aliasBucket).ForEach(func(k, v []byte) error {
+			if string(v) == id {
+				item.Aliases = append(item.Aliases, string(k))
+			}
+			return nil
+		})
+		if err == nil {
+			err = putTrash(tx, item)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("unable to write trash")
+			return errors.New("unable to store tag")
+		}
+	}
+	if err := repointAliases(tx, id, tag); err != nil {
+		logrus.WithError(err).Error("unable to update aliases")
+		return errors.New("unable to store tag")
+	}
+	if err := appendGraphOps(tx, ops); err != nil {
+		logrus.WithError(err).Error("unable to write graph ops")
+		return errors.New("unable to store tag")
+	}
+	return nil
+}
+
+// tagRevision returns the revision of a stored tag, zero when there is none
+func tagRevision(tx *bolt.Tx, id string) (uint64, error) {
+	res := tx.Bucket(tagBucket).Get([]byte(id))
+	if res == nil {
+		return 0, nil
+	}
+	var tag internal.Tag
+	if err := json.Unmarshal(res, &tag); err != nil {
+		return 0, err
+	}
+	return tag.Revision, nil
+}
+
+// repointAliases moves the aliases of a replaced tag to its replacement, or drops them when the tag is deleted
+func repointAliases(tx *bolt.Tx, id string, tag *internal.Tag) error {
+	bucket := tx.Bucket(aliasBucket)
+	var aliases [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if string(v) == id {
+			aliases = append(aliases, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if tag == nil {
+			err = bucket.Delete(alias)
+		} else {
+			err = bucket.Put(alias, []byte(tag.Name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replaceTag(tags []internal.Tag, id string, tag *internal.Tag) []internal.Tag {
+	present := false
+	if tag != nil && tag.Name != id {
+		for _, t := range tags {
+			if t.Name == tag.Name {
+				present = true
+			}
+		}
+	}
+	var replaced []internal.Tag
+	for _, t := range tags {
+		if t.Name != id {
+			replaced = append(replaced, t)
+			continue
+		}
+		if tag != nil && !present {
+			assigned := *tag
+			assigned.TaggedAt, assigned.TaggedBy = t.TaggedAt, t.TaggedBy
+			replaced = append(replaced, assigned)
+		}
+	}
+	return replaced
+}
+
+func (b *boltkv) GetAlias(alias string) (string, error) {
+	var tag string
+	err := b.view(func(tx *bolt.Tx) error {
+		res := tx.Bucket(aliasBucket).Get([]byte(alias))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		tag = string(res)
+		return nil
+	})
+	return tag, err
+}
+
+func (b *boltkv) GetAliases(tag string) ([]string, error) {
+	var aliases []string
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasBucket).ForEach(func(k, v []byte) error {
+			if string(v) == tag {
+				aliases = append(aliases, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch aliases")
+		return nil, errors.New("unable to fetch aliases")
+	}
+	return aliases, nil
+}
+
+func (b *boltkv) GetAllAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasBucket).ForEach(func(k, v []byte) error {
+			aliases[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch aliases")
+		return nil, errors.New("unable to fetch aliases")
+	}
+	return aliases, nil
+}
+
+// PutAlias points an alias at a tag, an alias may not shadow an existing tag or be reassigned
+func (b *boltkv) PutAlias(alias string, tag string) error {
+	return b.Batch(func(batch KVBatch) error {
+		return batch.PutAlias(alias, tag)
+	})
+}
+
+func (t txkv) PutAlias(alias string, tag string) error {
+	if t.tx.Bucket(tagBucket).Get([]byte(alias)) != nil {
+		return internal.ErrConflict
+	}
+	bucket := t.tx.Bucket(aliasBucket)
+	if existing := bucket.Get([]byte(alias)); existing != nil && string(existing) != tag {
+		return internal.ErrConflict
+	}
+	if err := bucket.Put([]byte(alias), []byte(tag)); err != nil {
+		logrus.WithError(err).Error("unable to write alias")
+		return errors.New("unable to store alias")
+	}
+	return nil
+}
+
+func (b *boltkv) DeleteAlias(alias string) error {
+	return b.Batch(func(batch KVBatch) error {
+		return batch.DeleteAlias(alias)
+	})
+}
+
+func (t txkv) DeleteAlias(alias string) error {
+	bucket := t.tx.Bucket(aliasBucket)
+	if bucket.Get([]byte(alias)) == nil {
+		return internal.ErrNotFound
+	}
+	if err := bucket.Delete([]byte(alias)); err != nil {
+		logrus.WithError(err).Error("unable to delete alias")
+		return errors.New("unable to delete alias")
+	}
+	return nil
+}
+
+func (b *boltkv) GetType(name string) (internal.ResourceType, error) {
+	var t internal.ResourceType
+	err := b.view(func(tx *bolt.Tx) error {
+		res := tx.Bucket(typeBucket).Get([]byte(name))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		if err := json.Unmarshal(res, &t); err != nil {
+			logrus.WithError(err).Error("unable to unmarshall type")
+			return err
+		}
 		return nil
 	})
+	return t, err
+}
+
+func (b *boltkv) GetAllTypes() ([]internal.ResourceType, error) {
+	var types []internal.ResourceType
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(typeBucket).ForEach(func(k, v []byte) error {
+			var res internal.ResourceType
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			types = append(types, res)
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch results for types")
+		return types, errors.New("unable to fetch types")
+	}
+	return types, nil
+}
+
+func (b *boltkv) PutType(name string, t internal.ResourceType) error {
+	return b.update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(t)
+		if err != nil {
+			logrus.WithError(err).Error("unable to marshal type")
+			return errors.New("unable to store type")
+		}
+		if err := tx.Bucket(typeBucket).Put([]byte(name), buf); err != nil {
+			logrus.WithError(err).Error("unable to write type")
+			return errors.New("unable to store type")
+		}
+		tx.OnCommit(b.changed)
+		return nil
+	})
+}
+
+func (b *boltkv) DeleteType(name string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(typeBucket)
+		if bucket.Get([]byte(name)) == nil {
+			return internal.ErrNotFound
+		}
+		if err := bucket.Delete([]byte(name)); err != nil {
+			logrus.WithError(err).Error("unable to delete type")
+			return errors.New("unable to delete type")
+		}
+		tx.OnCommit(b.changed)
+		return nil
+	})
+}
+
+// GetMeta returns a value describing the state of the store itself, such as which migrations have run
+func (b *boltkv) GetMeta(key string) (string, error) {
+	var value string
+	err := b.view(func(tx *bolt.Tx) error {
+		res := tx.Bucket(metaBucket).Get([]byte(key))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		value = string(res)
+		return nil
+	})
+	return value, err
+}
+
+func (b *boltkv) PutMeta(key string, value string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(metaBucket).Put([]byte(key), []byte(value)); err != nil {
+			logrus.WithError(err).Error("unable to write meta")
+			return errors.New("unable to store meta")
+		}
+		return nil
+	})
+}
+
+func (b *boltkv) PendingGraphOps() ([]PendingGraphOp, error) {
+	var pending []PendingGraphOp
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			var op GraphOp
+			if err := json.Unmarshal(v, &op); err != nil {
+				return err
+			}
+			pending = append(pending, PendingGraphOp{Seq: binary.BigEndian.Uint64(k), Op: op})
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch pending graph ops")
+		return nil, errors.New("unable to fetch pending graph ops")
+	}
+	return pending, nil
+}
+
+// AckGraphOps removes every pending op up to and including the given sequence number
+func (b *boltkv) AckGraphOps(through uint64) error {
+	err := b.update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= through; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to acknowledge graph op")
+		return errors.New("unable to acknowledge graph op")
+	}
+	return nil
 }
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// bulkChunkSize is the number of operations of a bulk request written in one transaction
+const bulkChunkSize = 500
+
+// bulkItem is a bulk operation checked and resolved against the store, ready to be applied in a transaction
+type bulkItem struct {
+	op       string
+	resource internal.Resource
+	// tags are the tags to create or update the resource with, or the canonical names to tag or untag it with
+	tags   []internal.Tag
+	result *internal.BulkResult
+}
+
+// BulkResources applies the operations in transactions of up to bulkChunkSize operations, the graph is
+// updated once per transaction. In atomic mode every operation is checked first and all of them are
+// written in a single transaction.
+func (r *repository) BulkResources(ops []internal.BulkOperation, atomic bool) ([]internal.BulkResult, error) {
+	results := make([]internal.BulkResult, len(ops))
+	types, err := r.kvstore.GetAllTypes()
+	if err != nil {
+		return nil, err
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*bulkItem
+	failed := false
+	for i, op := range ops {
+		item, err := r.prepareBulk(op)
+		results[i] = internal.BulkResult{Index: i, Op: op.Op, ID: item.resource.ID, Err: err}
+		if err != nil {
+			failed = true
+			continue
+		}
+		item.result = &results[i]
+		items = append(items, item)
+	}
+
+	chunk := bulkChunkSize
+	if atomic {
+		if failed {
+			abortBulk(results)
+			return results, nil
+		}
+		chunk = len(items)
+	}
+
+	for start := 0; start < len(items); start += chunk {
+		end := start + chunk
+		if end > len(items) {
+			end = len(items)
+		}
+		now := r.clock.Now()
+		err := r.kvstore.Batch(func(batch KVBatch) error {
+			for _, item := range items[start:end] {
+				re, err := r.applyBulk(batch, item, types, check, now)
+				if err != nil {
+					item.result.Err = err
+					if atomic || !callerError(err) {
+						return err
+					}
+					continue
+				}
+				item.result.Resource = &re
+			}
+			return nil
+		})
+		if err != nil && atomic {
+			abortBulk(results)
+			if callerError(err) {
+				return results, nil
+			}
+			return results, r.writeError(err)
+		}
+		if err != nil {
+			// the whole chunk was rolled back
+			err = r.writeError(err)
+			for _, item := range items[start:end] {
+				item.result.Resource, item.result.Err = nil, err
+			}
+		}
+		r.syncGraph()
+	}
+	return results, nil
+}
+
+// abortBulk marks every operation which did not fail itself as aborted
+func abortBulk(results []internal.BulkResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = internal.ErrAborted
+		}
+		results[i].Resource = nil
+	}
+}
+
+// prepareBulk validates an operation and resolves its tag names, which cannot be done inside the write
+// transaction
+func (r *repository) prepareBulk(op internal.BulkOperation) (*bulkItem, error) {
+	item := &bulkItem{op: op.Op}
+	switch op.Op {
+	case internal.BulkCreate, internal.BulkUpdate:
+		if op.Resource == nil {
+			return item, fmt.Errorf("%w: %s requires a resource", internal.ErrInvalid, op.Op)
+		}
+		item.resource = *op.Resource
+		if item.resource.ID == "" && op.Op == internal.BulkCreate {
+			item.resource.ID = r.newID()
+		}
+		if item.resource.ID == "" || item.resource.Name == "" || item.resource.Type == "" {
+			return item, fmt.Errorf("%w: resource requires an id, name and type", internal.ErrInvalid)
+		}
+		if err := item.resource.Attributes.Validate(); err != nil {
+			return item, err
+		}
+		for _, t := range item.resource.Tags {
+			if t.Color != "" && !t.Color.Valid() {
+				return item, &internal.ValidationError{Rule: "color", Value: string(t.Color), Msg: "color must be of the form #RRGGBB"}
+			}
+			name, err := r.queryTag(t.Name)
+			if err != nil {
+				return item, err
+			}
+			t.Name = name
+			item.tags = append(item.tags, t)
+		}
+	case internal.BulkTag, internal.BulkUntag:
+		item.resource.ID = op.ID
+		if op.ID == "" || len(op.Tags) == 0 {
+			return item, fmt.Errorf("%w: %s requires an id and tags", internal.ErrInvalid, op.Op)
+		}
+		for _, name := range op.Tags {
+			var err error
+			if op.Op == internal.BulkTag {
+				name, err = r.queryTag(name)
+			} else {
+				name, err = r.lookupTag(name)
+			}
+			if err != nil {
+				return item, err
+			}
+			item.tags = append(item.tags, internal.Tag{Name: name})
+		}
+	default:
+		return item, fmt.Errorf("%w: unknown bulk op %q", internal.ErrInvalid, op.Op)
+	}
+	return item, nil
+}
+
+// applyBulk writes a prepared operation within the batch
+func (r *repository) applyBulk(batch KVBatch, item *bulkItem, types []internal.ResourceType, check func(internal.Resource) error, now time.Time) (internal.Resource, error) {
+	// tags are resolved before the resource is checked and the missing ones only created once it passes, so
+	// a failed operation leaves no tags behind. They are created before the resource so their graph ops come
+	// first.
+	var missing []internal.Tag
+	tags := func(resourceType string, previous []internal.Tag) ([]internal.Tag, error) {
+		var color internal.Color
+		for _, t := range types {
+			if t.Name == resourceType {
+				color = t.DefaultColor
+			}
+		}
+		var tags []internal.Tag
+		seen := make(map[string]bool)
+		for _, def := range item.tags {
+			if seen[def.Name] {
+				continue
+			}
+			seen[def.Name] = true
+			tag, err := batch.GetTag(def.Name)
+			if err == internal.ErrNotFound {
+				if def.Color == "" {
+					def.Color = color
+				}
+				tag = r.newTag(def)
+				missing = append(missing, tag)
+			} else if err != nil {
+				return nil, err
+			}
+			tags = append(tags, r.assigned(tag, previous, now))
+		}
+		return tags, nil
+	}
+	create := func() error {
+		for _, tag := range missing {
+			if err := r.putNewTag(batch, tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	re := item.resource
+	switch item.op {
+	case internal.BulkCreate:
+		return batch.MutateResource(re.ID, internal.Precondition{}, r.change(opCreateResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current != nil {
+				return nil, nil, internal.ErrConflict
+			}
+			var err error
+			if re.Tags, err = tags(re.Type, nil); err != nil {
+				return nil, nil, err
+			}
+			re.CreatedAt, re.UpdatedAt = now, now
+			re.CreatedBy, re.UpdatedBy = r.actor, r.actor
+			if err := check(re); err != nil {
+				return nil, nil, err
+			}
+			if err := create(); err != nil {
+				return nil, nil, err
+			}
+			return &re, []GraphOp{{Op: opCreateResource, Resource: &re}}, nil
+		})
+	case internal.BulkUpdate:
+		return batch.MutateResource(re.ID, internal.Precondition{}, r.change(opUpdateResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current == nil {
+				return nil, nil, internal.ErrNotFound
+			}
+			var err error
+			if re.Tags, err = tags(re.Type, current.Tags); err != nil {
+				return nil, nil, err
+			}
+			re.CreatedAt, re.CreatedBy = current.CreatedAt, current.CreatedBy
+			re.UpdatedAt, re.UpdatedBy = now, r.actor
+			if err := check(re); err != nil {
+				return nil, nil, err
+			}
+			if err := create(); err != nil {
+				return nil, nil, err
+			}
+			return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+		})
+	case internal.BulkTag:
+		return batch.MutateResource(re.ID, internal.Precondition{}, r.change(opAddResourceTag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current == nil {
+				return nil, nil, internal.ErrNotFound
+			}
+			added, err := tags(current.Type, current.Tags)
+			if err != nil {
+				return nil, nil, err
+			}
+			re = *current
+			for _, tag := range added {
+				if !hasTag(current.Tags, tag.Name) {
+					re.Tags = append(re.Tags, tag)
+				}
+			}
+			if len(re.Tags) == len(current.Tags) {
+				return nil, nil, internal.ErrConflict
+			}
+			re.UpdatedAt, re.UpdatedBy = now, r.actor
+			if err := check(re); err != nil {
+				return nil, nil, err
+			}
+			if err := create(); err != nil {
+				return nil, nil, err
+			}
+			return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+		})
+	default:
+		return batch.MutateResource(re.ID, internal.Precondition{}, r.change(opDeleteResourceTag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current == nil {
+				return nil, nil, internal.ErrNotFound
+			}
+			re = *current
+			re.Tags = nil
+			for _, tag := range current.Tags {
+				if !hasTag(item.tags, tag.Name) {
+					re.Tags = append(re.Tags, tag)
+				}
+			}
+			if len(re.Tags) == len(current.Tags) {
+				return nil, nil, internal.ErrNotFound
+			}
+			re.UpdatedAt, re.UpdatedBy = now, r.actor
+			if err := check(re); err != nil {
+				return nil, nil, err
+			}
+			if err := create(); err != nil {
+				return nil, nil, err
+			}
+			return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+		})
+	}
+}
+
+// ensureTag returns the stored tag, creating it and its parents within the batch when missing
+func (r *repository) ensureTag(batch KVBatch, def internal.Tag) (internal.Tag, error) {
+	tag, err := batch.GetTag(def.Name)
+	if err != internal.ErrNotFound {
+		return tag, err
+	}
+	tag = r.newTag(def)
+	return tag, r.putNewTag(batch, tag)
+}
+
+// putNewTag stores a tag built with newTag within the batch, creating its parents when missing
+func (r *repository) putNewTag(batch KVBatch, tag internal.Tag) error {
+	if parent := internal.ParentTagName(tag.Name); parent != "" {
+		if _, err := r.ensureTag(batch, internal.Tag{Name: parent}); err != nil {
+			return err
+		}
+	}
+	return batch.PutTag(tag.Name, &tag, GraphOp{Op: opCreateTag, Tag: &tag})
+}
+
+func hasTag(tags []internal.Tag, name string) bool {
+	for _, t := range tags {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestBulkFailedOperationCreatesNoTags(t *testing.T) {
+	env := newTestRepository(t)
+	if _, err := env.repo.CreateType(internal.ResourceType{Name: "note", AllowedTags: []string{"go", "team:*"}}); err != nil {
+		t.Fatal(err)
+	}
+	mustCreateResource(t, env.repo, "r1", "go")
+
+	results, err := env.repo.BulkResources([]internal.BulkOperation{
+		{Op: internal.BulkCreate, Resource: &internal.Resource{ID: "r2", Name: "r2", Type: "note", Tags: []internal.Tag{{Name: "cli"}}}},
+		{Op: internal.BulkTag, ID: "r1", Tags: []string{"lang/rust"}},
+		{Op: internal.BulkTag, ID: "r1", Tags: []string{"team:web"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var terr *internal.TypeError
+	for i := 0; i < 2; i++ {
+		if !errors.As(results[i].Err, &terr) {
+			t.Errorf("operation %d returned %v, want a TypeError", i, results[i].Err)
+		}
+	}
+	if results[2].Err != nil {
+		t.Errorf("valid operation failed: %v", results[2].Err)
+	}
+	for _, name := range []string{"cli", "lang/rust", "lang"} {
+		if _, err := env.repo.FindTagByName(name); err != internal.ErrNotFound {
+			t.Errorf("tag %s of a failed operation: %v, want ErrNotFound", name, err)
+		}
+	}
+	if _, err := env.repo.FindTagByName("team:web"); err != nil {
+		t.Errorf("tag of the applied operation: %v", err)
+	}
+}
+
+func TestBulkAtomicAbortsEverything(t *testing.T) {
+	env := newTestRepository(t)
+	results, err := env.repo.BulkResources([]internal.BulkOperation{
+		{Op: internal.BulkCreate, Resource: &internal.Resource{ID: "r1", Name: "r1", Type: "note", Tags: []internal.Tag{{Name: "go"}}}},
+		{Op: internal.BulkTag, ID: "missing", Tags: []string{"cli"}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != internal.ErrAborted || results[1].Err != internal.ErrNotFound {
+		t.Errorf("results %v and %v", results[0].Err, results[1].Err)
+	}
+	if _, err := env.repo.FindResourceByID("r1"); err != internal.ErrNotFound {
+		t.Errorf("resource of an aborted request: %v", err)
+	}
+	if _, err := env.repo.FindTagByName("go"); err != internal.ErrNotFound {
+		t.Errorf("tag of an aborted request: %v", err)
+	}
+}
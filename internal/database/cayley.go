@@ -1,46 +1,165 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/graph"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	_ "github.com/cayleygraph/cayley/graph/kv/bolt"
+	"github.com/cayleygraph/cayley/graph/path"
+	"github.com/cayleygraph/cayley/graph/shape"
+	"github.com/cayleygraph/cayley/writer"
 	"github.com/cayleygraph/quad"
 	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/query"
 	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+	"io"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
+// graphSchemaVersion must be incremented whenever the shape of the stored quads changes so existing graphs
+// are rebuilt from the kv store on the next start.
+const graphSchemaVersion = 4
+
+const (
+	graphBackend = "bolt"
+	metaNode     = "meta:graph"
+)
+
 type graphdb struct {
-	conn *cayley.Handle
+	conn    *cayley.Handle
+	rebuild bool
 }
 
 type GraphDB interface {
 	DeleteResourceTag(resource internal.Resource, tag string) error
 	AddResourceTag(resource internal.Resource, tag string) error
 	CreateResource(resource internal.Resource) error
+	UpdateResource(old internal.Resource, resource internal.Resource) error
+	DeleteResource(resource internal.Resource) error
 	CreateTag(tag internal.Tag) error
+	ReplaceTag(old internal.Tag, tag *internal.Tag) error
+	ApplyBatch(ops []GraphOp) error
 	FindAllResources(params internal.ResourceParams) ([]string, error)
+	FindResourcesByQuery(expr query.Expr, descendants bool) ([]string, error)
+	FindTagChildren(name string, recursive bool) ([]string, error)
 	FindAllTags(params internal.TagParams) ([]string, error)
+	Diff(resources []internal.Resource, tags []internal.Tag) ([]quad.Quad, []quad.Quad, error)
+	Repair(missing []quad.Quad, extra []quad.Quad) error
+	NeedsRebuild() bool
+	MarkBuilt() error
 }
 
-func NewGraphDatabase() GraphDB {
-	conn, err := cayley.NewMemoryGraph()
+func NewGraphDatabase(lc fx.Lifecycle, config internal.Configuration) GraphDB {
+	dbPath := config.GraphPath
+	if dbPath == "" {
+		logrus.Fatal("graph path missing")
+	}
+
+	logrus.WithField("path", dbPath).Info("opening graph database")
+	conn, err := openGraph(dbPath)
 	if err != nil {
-		logrus.WithError(err).Fatal("unable to create graph database")
+		logrus.WithError(err).Warn("unable to open graph database, it will be rebuilt")
+		conn, err = resetGraph(dbPath, conn)
+		if err != nil {
+			logrus.WithError(err).Fatal("unable to create graph database")
+		}
 	}
+
+	g := &graphdb{conn: conn}
+	version, err := g.version()
+	if err != nil || version != graphSchemaVersion {
+		logrus.WithFields(logrus.Fields{
+			"version":  version,
+			"expected": graphSchemaVersion,
+		}).Warn("graph schema version mismatch, it will be rebuilt")
+		g.conn, err = resetGraph(dbPath, g.conn)
+		if err != nil {
+			logrus.WithError(err).Fatal("unable to create graph database")
+		}
+		g.rebuild = true
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			logrus.Info("closing graph database")
+			return g.conn.Close()
+		},
+	})
 	logrus.Info("graph database established")
 
-	return &graphdb{conn: conn}
+	return g
 }
 
-func (r *graphdb) DeleteResourceTag(resource internal.Resource, tag string) error {
-	id := resourceKey(resource.ID)
-	tagID := tagKey(tag)
+func openGraph(dbPath string) (*cayley.Handle, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		if err := graph.InitQuadStore(graphBackend, dbPath, nil); err != nil {
+			return nil, err
+		}
+	}
+	qs, err := graph.NewQuadStore(graphBackend, dbPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	// graph ops may be replayed from the outbox so writes need to be idempotent
+	qw, err := writer.NewSingle(qs, graph.IgnoreOpts{IgnoreDup: true, IgnoreMissing: true})
+	if err != nil {
+		qs.Close()
+		return nil, err
+	}
+	return &cayley.Handle{QuadStore: qs, QuadWriter: qw}, nil
+}
 
+func resetGraph(dbPath string, conn *cayley.Handle) (*cayley.Handle, error) {
+	if conn != nil {
+		conn.Close()
+	}
+	if err := os.RemoveAll(dbPath); err != nil {
+		return nil, err
+	}
+	return openGraph(dbPath)
+}
+
+func (r *graphdb) version() (int, error) {
+	value, err := cayley.StartPath(r.conn, quad.String(metaNode)).Out(quad.String("version")).Iterate(nil).FirstValue(nil)
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(nodeID(value))
+}
+
+// NeedsRebuild reports whether the graph was created empty and must be loaded from the kv store
+func (r *graphdb) NeedsRebuild() bool {
+	return r.rebuild
+}
+
+// MarkBuilt stores the schema version once the graph has been fully loaded
+func (r *graphdb) MarkBuilt() error {
+	if err := r.conn.AddQuad(quad.Make(metaNode, "version", fmt.Sprintf("version:%d", graphSchemaVersion), nil)); err != nil {
+		logrus.WithError(err).Error("unable to write graph version")
+		return errors.New("unable to write graph version")
+	}
+	r.rebuild = false
+	return nil
+}
+
+func (r *graphdb) DeleteResourceTag(resource internal.Resource, tag string) error {
 	trans := cayley.NewTransaction()
-	trans.RemoveQuad(quad.Make(id, "tag", tagID, nil))
-	trans.RemoveQuad(quad.Make(tagID, "resource", id, nil))
+	for _, q := range resourceTagQuads(resource.ID, tag) {
+		trans.RemoveQuad(q)
+	}
 
 	if err := r.conn.ApplyTransaction(trans); err != nil {
 		logrus.WithError(err).Error("unable to delete tagged resource")
@@ -50,13 +169,10 @@ func (r *graphdb) DeleteResourceTag(resource internal.Resource, tag string) erro
 }
 
 func (r *graphdb) AddResourceTag(resource internal.Resource, tag string) error {
-	id := resourceKey(resource.ID)
-	tagID := tagKey(tag)
-
 	trans := cayley.NewTransaction()
-
-	trans.AddQuad(quad.Make(id, "tag", tagID, nil))
-	trans.AddQuad(quad.Make(tagID, "resource", id, nil))
+	for _, q := range resourceTagQuads(resource.ID, tag) {
+		trans.AddQuad(q)
+	}
 
 	if err := r.conn.ApplyTransaction(trans); err != nil {
 		logrus.WithError(err).Error("unable to delete tagged resource")
@@ -65,19 +181,57 @@ func (r *graphdb) AddResourceTag(resource internal.Resource, tag string) error {
 	return nil
 }
 
-func (r *graphdb) CreateResource(resource internal.Resource) error {
-	id := resourceKey(resource.ID)
+func resourceTagQuads(resource string, tag string) []quad.Quad {
+	id := resourceKey(resource)
+	tagID := tagKey(tag)
+	return []quad.Quad{
+		quad.Make(id, "tag", tagID, nil),
+		quad.Make(tagID, "resource", id, nil),
+	}
+}
+
+// ApplyBatch applies ops which do not read the graph in a single transaction
+func (r *graphdb) ApplyBatch(ops []GraphOp) error {
 	t := cayley.NewTransaction()
+	for _, op := range ops {
+		switch op.Op {
+		case opCreateResource:
+			addResourceQuads(t, *op.Resource)
+		case opUpdateResource:
+			removeResourceQuads(t, *op.Old)
+			addResourceQuads(t, *op.Resource)
+		case opDeleteResource:
+			removeResourceQuads(t, *op.Resource)
+		case opAddResourceTag:
+			for _, q := range resourceTagQuads(op.Resource.ID, op.TagName) {
+				t.AddQuad(q)
+			}
+		case opDeleteResourceTag:
+			for _, q := range resourceTagQuads(op.Resource.ID, op.TagName) {
+				t.RemoveQuad(q)
+			}
+		case opCreateTag:
+			for _, q := range tagQuads(*op.Tag) {
+				t.AddQuad(q)
+			}
+		default:
+			return fmt.Errorf("graph op %q cannot be batched", op.Op)
+		}
+	}
 
-	logrus.WithField("id", id).Info("adding resource")
-	t.AddQuad(quad.Make(id, "name", "name:"+resource.Name, nil))
-	t.AddQuad(quad.Make(id, "type", "type:"+resource.Type, nil))
-	t.AddQuad(quad.Make("type:"+resource.Type, "resource", id, nil))
-	for _, tag := range resource.Tags {
-		tagID := tagKey(tag.Name)
-		t.AddQuad(quad.Make(id, "tag", tagID, nil))
-		t.AddQuad(quad.Make(tagID, "resource", id, nil))
+	logrus.WithField("ops", len(ops)).Info("applying graph ops")
+	if err := r.conn.ApplyTransaction(t); err != nil {
+		logrus.WithError(err).Error("unable to apply graph ops")
+		return errors.New("unable to apply graph ops")
 	}
+	return nil
+}
+
+func (r *graphdb) CreateResource(resource internal.Resource) error {
+	t := cayley.NewTransaction()
+
+	logrus.WithField("id", resourceKey(resource.ID)).Info("adding resource")
+	addResourceQuads(t, resource)
 	if err := r.conn.ApplyTransaction(t); err != nil {
 		logrus.WithError(err).Error("unable to write resource")
 		return errors.New("unable to add resource")
@@ -86,28 +240,400 @@ func (r *graphdb) CreateResource(resource internal.Resource) error {
 	return nil
 }
 
-func (r *graphdb) CreateTag(tag internal.Tag) error {
+func (r *graphdb) UpdateResource(old internal.Resource, resource internal.Resource) error {
+	t := cayley.NewTransaction()
+
+	logrus.WithField("id", resourceKey(resource.ID)).Info("updating resource")
+	removeResourceQuads(t, old)
+	addResourceQuads(t, resource)
+	if err := r.conn.ApplyTransaction(t); err != nil {
+		logrus.WithError(err).Error("unable to update resource")
+		return errors.New("unable to update resource")
+	}
+
+	return nil
+}
+
+func (r *graphdb) DeleteResource(resource internal.Resource) error {
+	t := cayley.NewTransaction()
+
+	logrus.WithField("id", resourceKey(resource.ID)).Info("deleting resource")
+	removeResourceQuads(t, resource)
+	if err := r.conn.ApplyTransaction(t); err != nil {
+		logrus.WithError(err).Error("unable to delete resource")
+		return errors.New("unable to delete resource")
+	}
+
+	return nil
+}
+
+func resourceQuads(resource internal.Resource) []quad.Quad {
+	id := resourceKey(resource.ID)
+	quads := []quad.Quad{
+		quad.Make(id, "name", nodeKey("name", resource.Name), nil),
+		quad.Make(id, "type", nodeKey("type", resource.Type), nil),
+		quad.Make(nodeKey("type", resource.Type), "resource", id, nil),
+	}
+	for _, tag := range resource.Tags {
+		tagID := tagKey(tag.Name)
+		quads = append(quads, quad.Make(id, "tag", tagID, nil))
+		quads = append(quads, quad.Make(tagID, "resource", id, nil))
+	}
+	for key, value := range resource.Attributes {
+		quads = append(quads, quad.Make(id, attrKey(key), attrValue(value), nil))
+	}
+	return quads
+}
+
+func attrKey(key string) string {
+	return nodeKey("attr", key)
+}
+
+// attrValue converts an attribute to a typed graph value so numbers and dates can be compared as such
+func attrValue(value interface{}) quad.Value {
+	switch v := value.(type) {
+	case float64:
+		return quad.Float(v)
+	case bool:
+		return quad.Bool(v)
+	case string:
+		if t, ok := internal.ParseAttributeTime(v); ok {
+			return quad.Time(t)
+		}
+		return quad.String(v)
+	default:
+		return quad.String(fmt.Sprint(v))
+	}
+}
+
+// attrPath limits a path of resources to those matching an attribute filter
+func attrPath(p *path.Path, filter internal.AttributeFilter) *path.Path {
+	via := quad.String(attrKey(filter.Key))
+	if filter.Op == internal.AttrExists {
+		return p.Has(via)
+	}
+
+	if filter.Op == internal.AttrEqual {
+		var values []quad.Value
+		if f, err := strconv.ParseFloat(filter.Value, 64); err == nil {
+			values = append(values, quad.Float(f))
+		}
+		if b, err := strconv.ParseBool(filter.Value); err == nil {
+			values = append(values, quad.Bool(b))
+		}
+		if t, ok := internal.ParseAttributeTime(filter.Value); ok {
+			values = append(values, quad.Time(t))
+		} else {
+			values = append(values, quad.String(filter.Value))
+		}
+		return p.Has(via, values...)
+	}
+
+	var value quad.Value = quad.String(filter.Value)
+	if f, err := strconv.ParseFloat(filter.Value, 64); err == nil {
+		value = quad.Float(f)
+	} else if t, ok := internal.ParseAttributeTime(filter.Value); ok {
+		value = quad.Time(t)
+	}
+	op := map[string]iterator.Operator{
+		internal.AttrGT:  iterator.CompareGT,
+		internal.AttrGTE: iterator.CompareGTE,
+		internal.AttrLT:  iterator.CompareLT,
+		internal.AttrLTE: iterator.CompareLTE,
+	}[filter.Op]
+	return p.HasFilter(via, false, shape.Comparison{Op: op, Val: value})
+}
+
+func tagQuads(tag internal.Tag) []quad.Quad {
 	id := tagKey(tag.Name)
-	logrus.WithField("id", id).Info("adding tag")
-	if err := r.conn.AddQuad(quad.Make(id, "color", "color:"+tag.Color, nil)); err != nil {
+	quads := []quad.Quad{
+		quad.Make(id, "color", nodeKey("color", string(tag.Color)), nil),
+	}
+	if namespace, _ := internal.SplitTagName(tag.Name); namespace != "" {
+		quads = append(quads, quad.Make(namespaceKey(namespace), "tag", id, nil))
+	}
+	if parent := internal.ParentTagName(tag.Name); parent != "" {
+		quads = append(quads, quad.Make(id, "parent", tagKey(parent), nil))
+		quads = append(quads, quad.Make(tagKey(parent), "child", id, nil))
+	}
+	return quads
+}
+
+func addResourceQuads(t *graph.Transaction, resource internal.Resource) {
+	for _, q := range resourceQuads(resource) {
+		t.AddQuad(q)
+	}
+}
+
+func removeResourceQuads(t *graph.Transaction, resource internal.Resource) {
+	for _, q := range resourceQuads(resource) {
+		t.RemoveQuad(q)
+	}
+}
+
+func (r *graphdb) CreateTag(tag internal.Tag) error {
+	logrus.WithField("id", tagKey(tag.Name)).Info("adding tag")
+	if err := r.conn.AddQuadSet(tagQuads(tag)); err != nil {
 		logrus.WithError(err).Error("unable to write tag graph")
 		return errors.New("unable to add tag")
 	}
 	return nil
 }
 
+// ReplaceTag moves the tag edges of every resource from the old tag to the new one, or simply removes them
+// when tag is nil.
+func (r *graphdb) ReplaceTag(old internal.Tag, tag *internal.Tag) error {
+	oldID := tagKey(old.Name)
+	ids, err := cayley.StartPath(r.conn, quad.String(oldID)).Out(quad.String("resource")).Iterate(nil).AllValues(nil)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find path")
+		return errors.New("unable to replace tag")
+	}
+
+	t := cayley.NewTransaction()
+	logrus.WithField("id", oldID).Info("replacing tag")
+	for _, q := range tagQuads(old) {
+		t.RemoveQuad(q)
+	}
+	if tag != nil {
+		for _, q := range tagQuads(*tag) {
+			t.AddQuad(q)
+		}
+	}
+	if tag == nil || tag.Name != old.Name {
+		for _, id := range ids {
+			t.RemoveQuad(quad.Make(id, quad.String("tag"), quad.String(oldID), nil))
+			t.RemoveQuad(quad.Make(quad.String(oldID), quad.String("resource"), id, nil))
+			if tag == nil {
+				continue
+			}
+			tagID := tagKey(tag.Name)
+			t.AddQuad(quad.Make(id, quad.String("tag"), quad.String(tagID), nil))
+			t.AddQuad(quad.Make(quad.String(tagID), quad.String("resource"), id, nil))
+		}
+	}
+
+	if err := r.conn.ApplyTransaction(t); err != nil {
+		logrus.WithError(err).Error("unable to replace tag")
+		return errors.New("unable to replace tag")
+	}
+	return nil
+}
+
+// Diff compares the quads stored in the graph with the quads expected for the given records, ignoring
+// the graph metadata.
+func (r *graphdb) Diff(resources []internal.Resource, tags []internal.Tag) ([]quad.Quad, []quad.Quad, error) {
+	expected := make(map[quad.Quad]bool)
+	for _, tag := range tags {
+		for _, q := range tagQuads(tag) {
+			expected[q] = true
+		}
+	}
+	for _, resource := range resources {
+		for _, q := range resourceQuads(resource) {
+			expected[q] = true
+		}
+	}
+
+	var extra []quad.Quad
+	reader := graph.NewQuadStoreReader(r.conn.QuadStore)
+	defer reader.Close()
+	for {
+		q, err := reader.ReadQuad()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logrus.WithError(err).Error("unable to read quads")
+			return nil, nil, errors.New("unable to read graph")
+		}
+		if q.Subject == quad.String(metaNode) {
+			continue
+		}
+		if expected[q] {
+			delete(expected, q)
+			continue
+		}
+		extra = append(extra, q)
+	}
+
+	var missing []quad.Quad
+	for q := range expected {
+		missing = append(missing, q)
+	}
+	return missing, extra, nil
+}
+
+// Repair adds the missing quads and removes the extra ones in a single transaction
+func (r *graphdb) Repair(missing []quad.Quad, extra []quad.Quad) error {
+	t := cayley.NewTransaction()
+	for _, q := range missing {
+		t.AddQuad(q)
+	}
+	for _, q := range extra {
+		t.RemoveQuad(q)
+	}
+	if err := r.conn.ApplyTransaction(t); err != nil {
+		logrus.WithError(err).Error("unable to repair graph")
+		return errors.New("unable to repair graph")
+	}
+	return nil
+}
+
 func (r *graphdb) FindAllResources(params internal.ResourceParams) ([]string, error) {
-	return r.findAll("resource", reflect.ValueOf(params))
+	if len(params.Attr) == 0 {
+		return r.findResources(params)
+	}
+
+	p := r.allResources()
+	for _, a := range params.Attr {
+		filter, err := internal.ParseAttributeFilter(a)
+		if err != nil {
+			return nil, err
+		}
+		p = attrPath(p, filter)
+	}
+	ids, err := r.values(p)
+	if err != nil {
+		return nil, err
+	}
+	if params.Type == "" && params.Name == "" && params.Tag == "" {
+		return ids, nil
+	}
+	others, err := r.findResources(params)
+	if err != nil {
+		return nil, err
+	}
+	return intersection(ids, others), nil
+}
+
+func (r *graphdb) findResources(params internal.ResourceParams) ([]string, error) {
+	if !params.Descendants || params.Tag == "" {
+		return r.findAll("resource", reflect.ValueOf(params))
+	}
+
+	ids, err := r.values(r.tagPath(params.Tag, true))
+	if err != nil {
+		return nil, err
+	}
+	params.Tag = ""
+	if params.Type == "" && params.Name == "" {
+		return ids, nil
+	}
+	others, err := r.findAll("resource", reflect.ValueOf(params))
+	if err != nil {
+		return nil, err
+	}
+	return intersection(ids, others), nil
+}
+
+// FindTagChildren returns the names of the tags directly below the given tag, or every descendant when
+// recursive is set.
+func (r *graphdb) FindTagChildren(name string, recursive bool) ([]string, error) {
+	p := cayley.StartPath(r.conn, quad.String(tagKey(name)))
+	if recursive {
+		p = p.FollowRecursive(quad.String("child"), 0, nil)
+	} else {
+		p = p.Out(quad.String("child"))
+	}
+	return r.values(p)
+}
+
+// tagPath leads from a tag to its resources, optionally through every descendant of the tag
+func (r *graphdb) tagPath(name string, descendants bool) *path.Path {
+	p := cayley.StartPath(r.conn, quad.String(tagKey(name)))
+	if descendants {
+		p = p.Or(p.FollowRecursive(quad.String("child"), 0, nil))
+	}
+	return p.Out(quad.String("resource"))
+}
+
+func (r *graphdb) values(p *path.Path) ([]string, error) {
+	var ids []string
+	err := p.Iterate(nil).EachValue(nil, func(value quad.Value) {
+		ids = append(ids, nodeID(value))
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to find path")
+		return nil, errors.New("unable to find results in path")
+	}
+	return ids, nil
 }
 
 func (r *graphdb) FindAllTags(params internal.TagParams) ([]string, error) {
 	return r.findAll("tag", reflect.ValueOf(params))
 }
 
+// FindResourcesByQuery evaluates a parsed tag query against the graph and returns the matching resource ids
+func (r *graphdb) FindResourcesByQuery(expr query.Expr, descendants bool) ([]string, error) {
+	logrus.WithField("query", expr.String()).Info("searching")
+	p, err := r.queryPath(expr, descendants)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := r.values(p)
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithField("count", len(ids)).Info("results")
+	return ids, nil
+}
+
+func (r *graphdb) queryPath(expr query.Expr, descendants bool) (*path.Path, error) {
+	switch e := expr.(type) {
+	case query.Tag:
+		return r.tagPath(e.Name, descendants), nil
+	case query.Namespace:
+		return cayley.StartPath(r.conn, quad.String(namespaceKey(e.Name))).Out(quad.String("tag")).Out(quad.String("resource")), nil
+	case query.And:
+		left, err := r.queryPath(e.Left, descendants)
+		if err != nil {
+			return nil, err
+		}
+		if not, ok := e.Right.(query.Not); ok {
+			right, err := r.queryPath(not.Expr, descendants)
+			if err != nil {
+				return nil, err
+			}
+			return left.Except(right), nil
+		}
+		right, err := r.queryPath(e.Right, descendants)
+		if err != nil {
+			return nil, err
+		}
+		return left.And(right), nil
+	case query.Or:
+		left, err := r.queryPath(e.Left, descendants)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.queryPath(e.Right, descendants)
+		if err != nil {
+			return nil, err
+		}
+		return left.Or(right), nil
+	case query.Not:
+		inner, err := r.queryPath(e.Expr, descendants)
+		if err != nil {
+			return nil, err
+		}
+		return r.allResources().Except(inner), nil
+	default:
+		return nil, fmt.Errorf("unsupported query expression %T", expr)
+	}
+}
+
+func (r *graphdb) allResources() *path.Path {
+	return cayley.StartPath(r.conn).Has(quad.String("type"))
+}
+
 func (r *graphdb) findAll(t string, v reflect.Value) ([]string, error) {
 	var ids []string
 	numOfFields := v.NumField()
 	for i := 0; i < numOfFields; i++ {
+		if v.Field(i).Kind() != reflect.String || v.Type().Field(i).Tag.Get("graph") == "-" {
+			continue
+		}
 		var tids []string
 		path := strings.ToLower(v.Type().Field(i).Name)
 		value := v.Field(i).String()
@@ -119,11 +645,9 @@ func (r *graphdb) findAll(t string, v reflect.Value) ([]string, error) {
 			"value": value,
 			"type":  t,
 		}).Info("searching")
-		query := fmt.Sprintf("%s:%s", path, value)
-		p := cayley.StartPath(r.conn, quad.String(query)).Out(quad.String(t))
+		p := cayley.StartPath(r.conn, quad.String(nodeKey(path, value))).Out(quad.String(t))
 		err := p.Iterate(nil).EachValue(nil, func(value quad.Value) {
-			id := strings.Split((quad.NativeOf(value)).(string), ":")[1]
-			tids = append(tids, id)
+			tids = append(tids, nodeID(value))
 		})
 		if err != nil {
 			logrus.WithError(err).Error("unable to find path")
@@ -139,12 +663,31 @@ func (r *graphdb) findAll(t string, v reflect.Value) ([]string, error) {
 	return ids, nil
 }
 
+// node keys are the node kind and value separated by a colon, colons within the value are escaped so
+// namespaced tags and ids containing colons survive the round trip
+var (
+	keyEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	keyUnescaper = strings.NewReplacer("%3A", ":", "%25", "%")
+)
+
+func nodeKey(kind string, value string) string {
+	return kind + ":" + keyEscaper.Replace(value)
+}
+
+func nodeID(value quad.Value) string {
+	return keyUnescaper.Replace(strings.SplitN((quad.NativeOf(value)).(string), ":", 2)[1])
+}
+
 func resourceKey(id string) string {
-	return fmt.Sprintf("resource:%s", id)
+	return nodeKey("resource", id)
 }
 
 func tagKey(name string) string {
-	return fmt.Sprintf("tag:%s", name)
+	return nodeKey("tag", name)
+}
+
+func namespaceKey(namespace string) string {
+	return nodeKey("namespace", namespace)
 }
 
 func intersection(a []string, b []string) []string {
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cayleygraph/quad"
+	"github.com/holmes89/tags/internal"
+	"go.uber.org/fx/fxtest"
+)
+
+// inspectGraph opens the graph of config without a repository, passes it to fn and closes it again
+func inspectGraph(t *testing.T, config internal.Configuration, fn func(g *graphdb)) {
+	t.Helper()
+	lc := fxtest.NewLifecycle(t)
+	g := NewGraphDatabase(lc, config).(*graphdb)
+	lc.RequireStart()
+	defer lc.RequireStop()
+	fn(g)
+}
+
+func assertTagged(t *testing.T, g GraphDB, tag string, want string) {
+	t.Helper()
+	ids, err := g.FindAllResources(internal.ResourceParams{Tag: tag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(ids); got != want {
+		t.Errorf("graph finds %s tagged %s, want %s", got, tag, want)
+	}
+}
+
+func TestGraphPersistsAcrossRestarts(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	env.stop()
+
+	inspectGraph(t, env.config, func(g *graphdb) {
+		if g.NeedsRebuild() {
+			t.Error("graph of the current schema version is rebuilt")
+		}
+		if version, err := g.version(); err != nil || version != graphSchemaVersion {
+			t.Errorf("graph version %d %v, want %d", version, err, graphSchemaVersion)
+		}
+		assertTagged(t, g, "go", "[r1]")
+	})
+}
+
+func TestGraphRebuiltOnVersionMismatch(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	env.stop()
+
+	inspectGraph(t, env.config, func(g *graphdb) {
+		if err := g.conn.RemoveQuad(quad.Make(metaNode, "version", fmt.Sprintf("version:%d", graphSchemaVersion), nil)); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.conn.AddQuad(quad.Make(metaNode, "version", fmt.Sprintf("version:%d", graphSchemaVersion-1), nil)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	inspectGraph(t, env.config, func(g *graphdb) {
+		if !g.NeedsRebuild() {
+			t.Error("graph of an older schema version is not rebuilt")
+		}
+	})
+
+	env = openTestRepository(t, env.config, env.clock)
+	if env.repo.gdb.NeedsRebuild() {
+		t.Error("repository left the graph unbuilt")
+	}
+	assertTagged(t, env.repo.gdb, "go", "[r1]")
+}
+
+func TestGraphRebuiltWhenCorrupt(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	env.stop()
+
+	if err := ioutil.WriteFile(filepath.Join(env.config.GraphPath, "indexes.bolt"), []byte("not a bolt database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env = openTestRepository(t, env.config, env.clock)
+	assertTagged(t, env.repo.gdb, "go", "[r1]")
+}
+
+func TestNodeKeyEscaping(t *testing.T) {
+	keys := make(map[string]string)
+	for _, value := range []string{"go", "env:prod", "env%3Aprod", "100%", "%3A", "a:b:c"} {
+		key := nodeKey("tag", value)
+		if got := nodeID(quad.String(key)); got != value {
+			t.Errorf("%q escaped to %q and back to %q", value, key, got)
+		}
+		if other, ok := keys[key]; ok {
+			t.Errorf("%q and %q share the key %q", value, other, key)
+		}
+		keys[key] = value
+	}
+}
+
+func TestNamespacedTags(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "urn:svc:payments", "env:prod", "team:payments")
+	mustCreateResource(t, env.repo, "100%3A", "env:dev", "team:payments")
+	mustCreateResource(t, env.repo, "plain", "prod")
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"env:prod", "[urn:svc:payments]"},
+		{"env:*", "[100%3A urn:svc:payments]"},
+		{"team:* AND NOT env:prod", "[100%3A]"},
+		{"prod", "[plain]"},
+	}
+	for _, tt := range tests {
+		resources, _, err := env.repo.FindAllResources(&internal.ResourceParams{Query: tt.query, Sort: "id"})
+		if err != nil {
+			t.Fatalf("query %q: %v", tt.query, err)
+		}
+		var ids []string
+		for _, res := range resources {
+			ids = append(ids, res.ID)
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("query %q matched %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	tags, _, err := env.repo.FindAllTags(&internal.TagParams{Namespace: "env", Sort: "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Value != "dev" || tags[1].Value != "prod" || tags[1].Namespace != "env" {
+		t.Errorf("env namespace lists %+v, want dev and prod", tags)
+	}
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// historyBucket holds a nested bucket per resource id with its revisions keyed by revision number
+var historyBucket = []byte("history")
+
+// history ops recorded for writes which are not a plain graph op
+const (
+	historyRevert     = "revert"
+	historyUpdateTag  = "update_tag"
+	historyRenameTag  = "rename_tag"
+	historyMergeTag   = "merge_tag"
+	historyDeleteTag  = "delete_tag"
+	historyNormalize  = "normalize_tags"
+	historyRestore    = "restore"
+	historyRestoreTag = "restore_tag"
+	historyRetag      = "retag"
+	historyImport     = "import"
+)
+
+// Change describes a write, it is recorded in the history of every resource the write touches
+type Change struct {
+	Op string
+	At time.Time
+	By string
+}
+
+// appendRevision records a change to a resource, setting the revision of the resource to the new entry. The
+// oldest revisions are dropped so at most limit are kept, zero keeps every revision.
+func appendRevision(tx *bolt.Tx, id string, resource *internal.Resource, change Change, limit int) error {
+	bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	if resource != nil {
+		resource.Revision = seq
+	}
+	buf, err := json.Marshal(internal.Revision{
+		Revision: seq,
+		Op:       change.Op,
+		At:       change.At,
+		By:       change.By,
+		Resource: resource,
+	})
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(seqKey(seq), buf); err != nil {
+		return err
+	}
+	if limit <= 0 || seq <= uint64(limit) {
+		return nil
+	}
+	// revisions are numbered without gaps, so every key up to the oldest one kept is dropped
+	oldest := seqKey(seq - uint64(limit) + 1)
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteHistory drops every revision of a resource which no longer exists
+func deleteHistory(tx *bolt.Tx, id []byte) error {
+	if tx.Bucket(resourceBucket).Get(id) != nil {
+		return nil
+	}
+	err := tx.Bucket(historyBucket).DeleteBucket(id)
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+func (b *boltkv) GetRevisions(id string) ([]internal.Revision, error) {
+	var revisions []internal.Revision
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return internal.ErrNotFound
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var rev internal.Revision
+			if err := json.Unmarshal(v, &rev); err != nil {
+				return err
+			}
+			revisions = append(revisions, rev)
+			return nil
+		})
+	})
+	if err == internal.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch history")
+		return nil, errors.New("unable to fetch history")
+	}
+	return revisions, nil
+}
+
+func (b *boltkv) GetRevision(id string, revision uint64) (internal.Revision, error) {
+	var rev internal.Revision
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return internal.ErrNotFound
+		}
+		res := bucket.Get(seqKey(revision))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		return json.Unmarshal(res, &rev)
+	})
+	return rev, err
+}
+
+// GetRevisionAt returns the latest revision made at or before the given time
+func (b *boltkv) GetRevisionAt(id string, at time.Time) (internal.Revision, error) {
+	var rev internal.Revision
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(id))
+		if bucket == nil {
+			return internal.ErrNotFound
+		}
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var candidate internal.Revision
+			if err := json.Unmarshal(v, &candidate); err != nil {
+				return err
+			}
+			if !candidate.At.After(at) {
+				rev = candidate
+				return nil
+			}
+		}
+		return internal.ErrNotFound
+	})
+	return rev, err
+}
+
+func (r *repository) FindResourceHistory(id string) ([]internal.Revision, error) {
+	return r.kvstore.GetRevisions(id)
+}
+
+func (r *repository) FindResourceAsOf(id string, asOf string) (internal.Resource, error) {
+	var rev internal.Revision
+	var err error
+	if n, perr := strconv.ParseUint(asOf, 10, 64); perr == nil {
+		rev, err = r.kvstore.GetRevision(id, n)
+	} else if at, perr := time.Parse(time.RFC3339Nano, asOf); perr == nil {
+		rev, err = r.kvstore.GetRevisionAt(id, at)
+	} else {
+		return internal.Resource{}, fmt.Errorf("%w: asOf %q is neither a revision nor a timestamp", internal.ErrInvalid, asOf)
+	}
+	if err != nil {
+		return internal.Resource{}, err
+	}
+	if rev.Resource == nil {
+		return internal.Resource{}, internal.ErrNotFound
+	}
+	return *rev.Resource, nil
+}
+
+// RevertResource restores the resource to the state of an earlier revision, recording the revert as a new
+// revision. A deleted resource is recreated.
+func (r *repository) RevertResource(id string, revision uint64) (internal.Resource, error) {
+	rev, err := r.kvstore.GetRevision(id, revision)
+	if err != nil {
+		return internal.Resource{}, err
+	}
+	if rev.Resource == nil {
+		return internal.Resource{}, fmt.Errorf("%w: revision %d is a deletion", internal.ErrInvalid, revision)
+	}
+
+	re := *rev.Resource
+	if _, err := r.checkResource(re); err != nil {
+		return re, err
+	}
+	// the tags may have been deleted or changed since, the resource gets their current records
+	var tags []internal.Tag
+	for _, t := range re.Tags {
+		tag, err := r.CreateTag(internal.Tag{Name: t.Name, Color: t.Color, Description: t.Description})
+		if err != nil {
+			return re, err
+		}
+		tag.TaggedAt, tag.TaggedBy = t.TaggedAt, t.TaggedBy
+		tags = append(tags, tag)
+	}
+
+	change := r.change(historyRevert, r.clock.Now())
+	re.Tags = tags
+	re.UpdatedAt, re.UpdatedBy = change.At, change.By
+	re, err = r.kvstore.MutateResource(id, r.cond, change, func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return &re, []GraphOp{{Op: opCreateResource, Resource: &re}}, nil
+		}
+		re.CreatedAt, re.CreatedBy = current.CreatedAt, current.CreatedBy
+		return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+	})
+	if err != nil {
+		return re, r.writeError(err)
+	}
+	r.syncGraph()
+	return re, nil
+}
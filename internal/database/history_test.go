@@ -0,0 +1,55 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestHistoryLimit(t *testing.T) {
+	env := newTestRepository(t, func(config *internal.Configuration) {
+		config.HistoryLimit = 3
+	})
+	res := mustCreateResource(t, env.repo, "r1", "go")
+	for _, tag := range []string{"a", "b", "c", "d", "e"} {
+		var err error
+		if res, err = env.repo.AddTagToResource(res, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revisions, err := env.repo.FindResourceHistory("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Revision != 4 || revisions[2].Revision != 6 {
+		var kept []uint64
+		for _, rev := range revisions {
+			kept = append(kept, rev.Revision)
+		}
+		t.Fatalf("kept revisions %v, want [4 5 6]", kept)
+	}
+	if _, err := env.repo.RevertResource("r1", 2); err != internal.ErrNotFound {
+		t.Errorf("reverting to a dropped revision returned %v, want ErrNotFound", err)
+	}
+	if _, err := env.repo.RevertResource("r1", 4); err != nil {
+		t.Errorf("revert to a kept revision: %v", err)
+	}
+}
+
+func TestHistoryUnlimited(t *testing.T) {
+	env := newTestRepository(t)
+	res := mustCreateResource(t, env.repo, "r1")
+	for _, tag := range []string{"a", "b", "c"} {
+		var err error
+		if res, err = env.repo.AddTagToResource(res, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revisions, err := env.repo.FindResourceHistory("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 4 {
+		t.Errorf("kept %d revisions, want 4", len(revisions))
+	}
+}
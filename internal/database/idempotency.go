@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// idempotencyBucket holds the requests made with an idempotency key, keyed by the key
+var idempotencyBucket = []byte("idempotency")
+
+// ReserveIdempotencyKey stores the request unless its key holds a request created after expired, which is
+// returned instead
+func (b *boltkv) ReserveIdempotencyKey(req internal.IdempotentRequest, expired time.Time) (internal.IdempotentRequest, bool, error) {
+	var existing internal.IdempotentRequest
+	found := false
+	err := b.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		if res := bucket.Get([]byte(req.Key)); res != nil {
+			if err := json.Unmarshal(res, &existing); err != nil {
+				return err
+			}
+			if existing.CreatedAt.After(expired) {
+				found = true
+				return nil
+			}
+		}
+		buf, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(req.Key), buf)
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to reserve idempotency key")
+		return req, false, errors.New("unable to reserve idempotency key")
+	}
+	if found {
+		return existing, true, nil
+	}
+	return req, false, nil
+}
+
+func (b *boltkv) PutIdempotentRequest(req internal.IdempotentRequest) error {
+	return b.update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(req)
+		if err != nil {
+			logrus.WithError(err).Error("unable to marshall idempotent request")
+			return errors.New("unable to store idempotent request")
+		}
+		return tx.Bucket(idempotencyBucket).Put([]byte(req.Key), buf)
+	})
+}
+
+func (b *boltkv) DeleteIdempotencyKey(key string) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Delete([]byte(key))
+	})
+}
+
+// PurgeIdempotencyKeys removes the requests created before the given time
+func (b *boltkv) PurgeIdempotencyKeys(before time.Time) (int, error) {
+	purged := 0
+	err := b.update(func(tx *bolt.Tx) error {
+		expired, err := purgeBucket(tx.Bucket(idempotencyBucket), func(v []byte) (bool, error) {
+			var req internal.IdempotentRequest
+			err := json.Unmarshal(v, &req)
+			return err == nil && req.CreatedAt.Before(before), err
+		})
+		if err != nil {
+			return err
+		}
+		purged = len(expired)
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to purge idempotency keys")
+		return 0, errors.New("unable to purge idempotency keys")
+	}
+	return purged, nil
+}
+
+func (r *repository) BeginIdempotentRequest(key string, fingerprint string) (internal.IdempotentRequest, bool, error) {
+	now := r.clock.Now()
+	req := internal.IdempotentRequest{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+	}
+	return r.kvstore.ReserveIdempotencyKey(req, now.Add(-r.idempotencyTTL))
+}
+
+func (r *repository) CompleteIdempotentRequest(req internal.IdempotentRequest) error {
+	req.Completed = true
+	return r.kvstore.PutIdempotentRequest(req)
+}
+
+func (r *repository) AbandonIdempotentRequest(key string) error {
+	return r.kvstore.DeleteIdempotencyKey(key)
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultPageSize is used when a page has no limit, larger limits are clamped to maxPageSize
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Page describes which slice of a bucket should be returned. Sort names an index optionally prefixed
+// with "-" for descending order and Cursor is the opaque value returned with a previous page.
+type Page struct {
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type index struct {
+	bucket []byte
+	key    func(v []byte) ([]byte, error)
+}
+
+// indexes are keyed by the sort name, the primary key of a bucket is always available as "id" for
+// resources and "name" for tags.
+var (
+	resourceIndexes = map[string]index{
+		"name": {bucket: []byte("resources_by_name"), key: resourceIndexKey(func(r internal.Resource) []byte {
+			return []byte(r.Name)
+		})},
+		"type": {bucket: []byte("resources_by_type"), key: resourceIndexKey(func(r internal.Resource) []byte {
+			return []byte(r.Type)
+		})},
+		"tags": {bucket: []byte("resources_by_tags"), key: resourceIndexKey(func(r internal.Resource) []byte {
+			return uint64Key(uint64(len(r.Tags)))
+		})},
+		"created": {bucket: []byte("resources_by_created"), key: resourceIndexKey(func(r internal.Resource) []byte {
+			return timeKey(r.CreatedAt)
+		})},
+		"updated": {bucket: []byte("resources_by_updated"), key: resourceIndexKey(func(r internal.Resource) []byte {
+			return timeKey(r.UpdatedAt)
+		})},
+	}
+	tagIndexes = map[string]index{
+		"created": {bucket: []byte("tags_by_created"), key: tagIndexKey(func(t internal.Tag) []byte {
+			return timeKey(t.CreatedAt)
+		})},
+	}
+)
+
+func resourceIndexKey(fn func(r internal.Resource) []byte) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		var r internal.Resource
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, err
+		}
+		return fn(r), nil
+	}
+}
+
+func tagIndexKey(fn func(t internal.Tag) []byte) func(v []byte) ([]byte, error) {
+	return func(v []byte) ([]byte, error) {
+		var t internal.Tag
+		if err := json.Unmarshal(v, &t); err != nil {
+			return nil, err
+		}
+		return fn(t), nil
+	}
+}
+
+func uint64Key(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func timeKey(t time.Time) []byte {
+	if t.IsZero() {
+		return uint64Key(0)
+	}
+	return uint64Key(uint64(t.UnixNano()))
+}
+
+// entryKey joins the indexed value and the primary key so entries with equal values stay unique and
+// are ordered by primary key.
+func entryKey(idx index, id []byte, v []byte) ([]byte, error) {
+	k, err := idx.key(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(k, 0), id...), nil
+}
+
+func createIndexes(tx *bolt.Tx, primary []byte, indexes map[string]index) error {
+	for _, idx := range indexes {
+		if tx.Bucket(idx.bucket) != nil {
+			continue
+		}
+		bucket, err := tx.CreateBucket(idx.bucket)
+		if err != nil {
+			return err
+		}
+		logrus.WithField("index", string(idx.bucket)).Info("building index")
+		err = tx.Bucket(primary).ForEach(func(k, v []byte) error {
+			key, err := entryKey(idx, k, v)
+			if err != nil {
+				return err
+			}
+			return bucket.Put(key, k)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putIndexed writes a value to the primary bucket and keeps all of its indexes in sync
+func putIndexed(tx *bolt.Tx, primary []byte, indexes map[string]index, id []byte, v []byte) error {
+	if err := deleteIndexed(tx, primary, indexes, id); err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		key, err := entryKey(idx, id, v)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(idx.bucket).Put(key, id); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(primary).Put(id, v)
+}
+
+// deleteIndexed removes a value from the primary bucket along with its index entries
+func deleteIndexed(tx *bolt.Tx, primary []byte, indexes map[string]index, id []byte) error {
+	old := tx.Bucket(primary).Get(id)
+	if old == nil {
+		return nil
+	}
+	for _, idx := range indexes {
+		key, err := entryKey(idx, id, old)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(idx.bucket).Delete(key); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(primary).Delete(id)
+}
+
+// list walks the primary bucket, or the index named by the page sort, with a bolt cursor starting after
+// the page cursor. Only values whose id passes include are returned, a nil include accepts everything.
+func list(tx *bolt.Tx, primary []byte, primaryName string, indexes map[string]index, page Page, include func(id string) bool) ([][]byte, string, error) {
+	sort := page.Sort
+	if sort == "" {
+		sort = primaryName
+	}
+	desc := strings.HasPrefix(sort, "-")
+	name := strings.TrimPrefix(sort, "-")
+
+	var bucket *bolt.Bucket
+	if name == primaryName {
+		bucket = tx.Bucket(primary)
+	} else if idx, ok := indexes[name]; ok {
+		bucket = tx.Bucket(idx.bucket)
+	} else {
+		return nil, "", internal.ErrInvalid
+	}
+
+	if page.Limit < 0 {
+		return nil, "", internal.ErrInvalid
+	}
+	limit := page.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	after, err := decodeCursor(page.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := bucket.Cursor()
+	var k, v []byte
+	switch {
+	case after == nil && desc:
+		k, v = c.Last()
+	case after == nil:
+		k, v = c.First()
+	case desc:
+		k, v = c.Seek(after)
+		if k == nil {
+			k, v = c.Last()
+		}
+		for k != nil && bytes.Compare(k, after) >= 0 {
+			k, v = c.Prev()
+		}
+	default:
+		k, v = c.Seek(after)
+		if bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	}
+
+	var values [][]byte
+	var last []byte
+	for ; k != nil; k, v = step(c, desc) {
+		id := k
+		if name != primaryName {
+			id = v
+			v = tx.Bucket(primary).Get(id)
+		}
+		if include != nil && !include(string(id)) {
+			continue
+		}
+		if len(values) == limit {
+			return values, encodeCursor(sort, last), nil
+		}
+		values = append(values, v)
+		last = append([]byte(nil), k...)
+	}
+	return values, "", nil
+}
+
+// since walks a time index from the given time on and returns the values of the primary bucket it refers to
+func since(tx *bolt.Tx, primary []byte, idx index, t time.Time) [][]byte {
+	var values [][]byte
+	c := tx.Bucket(idx.bucket).Cursor()
+	for k, id := c.Seek(timeKey(t)); k != nil; k, id = c.Next() {
+		if v := tx.Bucket(primary).Get(id); v != nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func step(c *bolt.Cursor, desc bool) ([]byte, []byte) {
+	if desc {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+func encodeCursor(sort string, key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(append([]byte(sort+"|"), key...))
+}
+
+func decodeCursor(cursor string, sort string) ([]byte, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, internal.ErrInvalid
+	}
+	parts := bytes.SplitN(b, []byte("|"), 2)
+	if len(parts) != 2 || string(parts[0]) != sort || len(parts[1]) == 0 {
+		return nil, internal.ErrInvalid
+	}
+	return parts[1], nil
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// putResources stores n resources straight in the kv store, without touching the graph
+func putResources(t *testing.T, kv KVStore, n int) {
+	t.Helper()
+	err := kv.Batch(func(batch KVBatch) error {
+		for i := 0; i < n; i++ {
+			res := internal.Resource{ID: fmt.Sprintf("r%05d", i), Name: "n", Type: "note"}
+			_, err := batch.MutateResource(res.ID, internal.Precondition{}, Change{Op: opCreateResource}, func(*internal.Resource) (*internal.Resource, []GraphOp, error) {
+				return &res, nil, nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListResourcesPaging(t *testing.T) {
+	env := newTestRepository(t)
+	putResources(t, env.kv, defaultPageSize+20)
+
+	tests := []struct {
+		name       string
+		params     *internal.ResourceParams
+		want       int
+		wantCursor bool
+	}{
+		{"no params", nil, defaultPageSize, true},
+		{"no limit", &internal.ResourceParams{}, defaultPageSize, true},
+		{"limit", &internal.ResourceParams{Limit: 7}, 7, true},
+		{"limit beyond the end", &internal.ResourceParams{Limit: defaultPageSize + 50}, defaultPageSize + 20, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, cursor, err := env.repo.FindAllResources(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resources) != tt.want {
+				t.Errorf("got %d resources, want %d", len(resources), tt.want)
+			}
+			if (cursor != "") != tt.wantCursor {
+				t.Errorf("cursor %q, want one: %t", cursor, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestListResourcesWalksEveryPage(t *testing.T) {
+	env := newTestRepository(t)
+	putResources(t, env.kv, 25)
+
+	seen := map[string]bool{}
+	params := &internal.ResourceParams{Limit: 10}
+	pages := 0
+	for {
+		resources, cursor, err := env.repo.FindAllResources(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, res := range resources {
+			if seen[res.ID] {
+				t.Fatalf("%s returned twice", res.ID)
+			}
+			seen[res.ID] = true
+		}
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	if len(seen) != 25 || pages != 3 {
+		t.Errorf("walked %d resources in %d pages, want 25 in 3", len(seen), pages)
+	}
+}
+
+func TestListResourcesClampsLimit(t *testing.T) {
+	env := newTestRepository(t)
+	putResources(t, env.kv, maxPageSize+1)
+
+	resources, cursor, err := env.kv.ListResources(Page{Limit: maxPageSize * 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != maxPageSize || cursor == "" {
+		t.Errorf("got %d resources and cursor %q, want %d and a cursor", len(resources), cursor, maxPageSize)
+	}
+	if _, _, err := env.kv.ListResources(Page{Limit: -1}, nil); err != internal.ErrInvalid {
+		t.Errorf("negative limit returned %v, want ErrInvalid", err)
+	}
+}
+
+func TestListTagsPaging(t *testing.T) {
+	env := newTestRepository(t)
+	for i := 0; i < 12; i++ {
+		if _, err := env.repo.CreateTag(internal.Tag{Name: fmt.Sprintf("tag-%02d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tags, cursor, err := env.repo.FindAllTags(&internal.TagParams{Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 5 || cursor == "" {
+		t.Fatalf("got %d tags and cursor %q, want 5 and a cursor", len(tags), cursor)
+	}
+	tags, _, err = env.repo.FindAllTags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 12 {
+		t.Errorf("got %d tags without params, want 12", len(tags))
+	}
+}
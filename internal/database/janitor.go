@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+const janitorInterval = time.Hour
+
+// runJanitor purges the trash past the retention period, the expired idempotency keys and the old retag jobs
+// on start and then periodically. A retention of zero keeps the trash forever.
+func runJanitor(lc fx.Lifecycle, r *repository, retention time.Duration) {
+	purge := func() {
+		now := r.clock.Now()
+		if retention > 0 {
+			n, err := r.PurgeTrash(now.Add(-retention))
+			if err != nil {
+				logrus.WithError(err).Warn("unable to purge trash")
+			} else if n > 0 {
+				logrus.WithField("purged", n).Info("trash purged")
+			}
+		}
+		n, err := r.kvstore.PurgeIdempotencyKeys(now.Add(-r.idempotencyTTL))
+		if err != nil {
+			logrus.WithError(err).Warn("unable to purge idempotency keys")
+		} else if n > 0 {
+			logrus.WithField("purged", n).Info("idempotency keys purged")
+		}
+		n, err = r.kvstore.PurgeRetagJobs(now.Add(-retagJobRetention))
+		if err != nil {
+			logrus.WithError(err).Warn("unable to purge retag jobs")
+		} else if n > 0 {
+			logrus.WithField("purged", n).Info("retag jobs purged")
+		}
+	}
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				purge()
+				ticker := time.NewTicker(janitorInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ticker.C:
+						purge()
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// tagPolicyKey records the tag policy the stored tags were last normalized with
+const tagPolicyKey = "tag_policy"
+
+// NormalizeTags applies the tag policy to every stored tag and alias and records the policy as applied. Tags
+// whose normalized name is already taken are merged into that tag, tags which cannot be normalized are
+// reported and left untouched.
+func (r *repository) NormalizeTags(dryRun bool) (internal.NormalizeReport, error) {
+	report := internal.NormalizeReport{
+		Renamed: make(map[string]string),
+		Merged:  make(map[string]string),
+		Aliases: make(map[string]string),
+		Invalid: make(map[string]string),
+		DryRun:  dryRun,
+	}
+
+	tags, err := r.kvstore.GetAllTags()
+	if err != nil {
+		return report, err
+	}
+	existing := make(map[string]bool)
+	for _, t := range tags {
+		existing[t.Name] = true
+	}
+	// children are moved before their parents so a parent never has children left when it is merged
+	sort.SliceStable(tags, func(i, j int) bool {
+		return strings.Count(tags[i].Name, "/") > strings.Count(tags[j].Name, "/")
+	})
+
+	for _, old := range tags {
+		name, err := r.policy.Normalize(old.Name)
+		if err != nil {
+			report.Invalid[old.Name] = err.Error()
+			continue
+		}
+		if name == old.Name {
+			continue
+		}
+		if existing[name] {
+			report.Merged[old.Name] = name
+			if !dryRun {
+				if err := r.mergeNormalized(old, name); err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+
+		report.Renamed[old.Name] = name
+		existing[name] = true
+		if parent := internal.ParentTagName(name); parent != "" {
+			existing[parent] = true
+		}
+		if !dryRun {
+			if err := r.renameNormalized(old, name); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if err := r.normalizeAliases(&report, existing); err != nil {
+		return report, err
+	}
+	if !dryRun {
+		if err := r.kvstore.PutMeta(tagPolicyKey, r.policy.String()); err != nil {
+			logrus.WithError(err).Error("unable to record tag policy")
+		}
+	}
+	return report, nil
+}
+
+func (r *repository) mergeNormalized(old internal.Tag, name string) error {
+	target, err := r.kvstore.GetTag(name)
+	if err != nil {
+		return err
+	}
+	if err := r.kvstore.ReplaceTag(old.Name, &target, internal.Precondition{}, r.change(historyNormalize, r.clock.Now()), replaceTagOp(old, &target)); err != nil {
+		logrus.WithError(err).Error("unable to merge tag kv")
+		return errors.New("unable to merge tag")
+	}
+	r.syncGraph()
+	return nil
+}
+
+func (r *repository) renameNormalized(old internal.Tag, name string) error {
+	tag := old
+	tag.Name = name
+	tag.Namespace, tag.Value = internal.SplitTagName(name)
+	tag.Parent = internal.ParentTagName(name)
+	if tag.Parent != "" {
+		if _, err := r.CreateTag(internal.Tag{Name: tag.Parent}); err != nil {
+			return err
+		}
+	}
+	if err := r.kvstore.ReplaceTag(old.Name, &tag, internal.Precondition{}, r.change(historyNormalize, r.clock.Now()), replaceTagOp(old, &tag)); err != nil {
+		logrus.WithError(err).Error("unable to rename tag kv")
+		return errors.New("unable to rename tag")
+	}
+	r.syncGraph()
+	return nil
+}
+
+// normalizeAliases rewrites aliases to their normalized form, aliases which now collide with a tag are dropped
+func (r *repository) normalizeAliases(report *internal.NormalizeReport, tags map[string]bool) error {
+	aliases, err := r.kvstore.GetAllAliases()
+	if err != nil {
+		return err
+	}
+	for alias, tag := range aliases {
+		name, err := r.policy.Normalize(alias)
+		if err != nil {
+			report.Invalid[alias] = err.Error()
+			continue
+		}
+		if name == alias {
+			continue
+		}
+		report.Aliases[alias] = name
+		if report.DryRun {
+			continue
+		}
+		if err := r.kvstore.DeleteAlias(alias); err != nil {
+			return err
+		}
+		if tags[name] {
+			continue
+		}
+		if err := r.kvstore.PutAlias(name, tag); err != nil && err != internal.ErrConflict {
+			return err
+		}
+	}
+	return nil
+}
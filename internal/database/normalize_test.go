@@ -0,0 +1,64 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// TestStartupReportsTagPolicy covers a policy change between two starts, the stored tags are only rewritten
+// when normalization is requested
+func TestStartupReportsTagPolicy(t *testing.T) {
+	env := newTestRepository(t, func(config *internal.Configuration) {
+		config.TagPolicy = internal.TagPolicy{}
+	})
+	mustCreateResource(t, env.repo, "r1", "Golang", "golang")
+	mustCreateResource(t, env.repo, "r2", "Team:Web")
+
+	env.config.TagPolicy = internal.DefaultTagPolicy()
+	env = env.reopen(t)
+	for _, name := range []string{"Golang", "golang", "Team:Web"} {
+		if _, err := env.kv.GetTag(name); err != nil {
+			t.Errorf("tag %s after startup: %v", name, err)
+		}
+	}
+	if applied, _ := env.kv.GetMeta(tagPolicyKey); applied == env.config.TagPolicy.String() {
+		t.Error("policy recorded as applied at startup")
+	}
+
+	report, err := env.repo.NormalizeTags(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Merged["Golang"] != "golang" || report.Renamed["Team:Web"] != "team:web" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if applied, _ := env.kv.GetMeta(tagPolicyKey); applied != env.config.TagPolicy.String() {
+		t.Errorf("policy recorded as %q after normalizing", applied)
+	}
+	if _, err := env.kv.GetTag("Golang"); err != internal.ErrNotFound {
+		t.Errorf("merged tag lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestQueryNormalizesNamespace(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "Team:Web")
+	mustCreateResource(t, env.repo, "r2", "env:prod")
+
+	resources, _, err := env.repo.FindAllResources(&internal.ResourceParams{Query: "Team:*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].ID != "r1" {
+		t.Errorf("query Team:* found %v, want r1", resources)
+	}
+
+	tags, _, err := env.repo.FindAllTags(&internal.TagParams{Namespace: "TEAM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Name != "team:web" {
+		t.Errorf("namespace TEAM listed %v, want team:web", tags)
+	}
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+var outboxBucket = []byte("outbox")
+
+const outboxRetryInterval = 30 * time.Second
+
+// maxGraphBatch bounds the number of ops applied in a single graph transaction
+const maxGraphBatch = 1000
+
+const (
+	opCreateResource    = "create_resource"
+	opUpdateResource    = "update_resource"
+	opDeleteResource    = "delete_resource"
+	opAddResourceTag    = "add_resource_tag"
+	opDeleteResourceTag = "delete_resource_tag"
+	opCreateTag         = "create_tag"
+	opReplaceTag        = "replace_tag"
+)
+
+// GraphOp is a change to the graph which is stored in the outbox in the same transaction as the kv write
+// that caused it. Ops are replayed in order until the graph acknowledges them.
+type GraphOp struct {
+	Op       string             `json:"op"`
+	Resource *internal.Resource `json:"resource,omitempty"`
+	Old      *internal.Resource `json:"old,omitempty"`
+	Tag      *internal.Tag      `json:"tag,omitempty"`
+	OldTag   *internal.Tag      `json:"old_tag,omitempty"`
+	TagName  string             `json:"tag_name,omitempty"`
+}
+
+// PendingGraphOp is a graph op which has not been acknowledged yet
+type PendingGraphOp struct {
+	Seq uint64
+	Op  GraphOp
+}
+
+func (op GraphOp) apply(g GraphDB) error {
+	switch op.Op {
+	case opCreateResource:
+		return g.CreateResource(*op.Resource)
+	case opUpdateResource:
+		return g.UpdateResource(*op.Old, *op.Resource)
+	case opDeleteResource:
+		return g.DeleteResource(*op.Resource)
+	case opAddResourceTag:
+		return g.AddResourceTag(*op.Resource, op.TagName)
+	case opDeleteResourceTag:
+		return g.DeleteResourceTag(*op.Resource, op.TagName)
+	case opCreateTag:
+		return g.CreateTag(*op.Tag)
+	case opReplaceTag:
+		return g.ReplaceTag(*op.OldTag, op.Tag)
+	default:
+		return fmt.Errorf("unknown graph op %q", op.Op)
+	}
+}
+
+// batchable reports whether the op can share a graph transaction with other ops, ops which read the graph
+// are applied on their own
+func (op GraphOp) batchable() bool {
+	return op.Op != opReplaceTag
+}
+
+func appendGraphOps(tx *bolt.Tx, ops []GraphOp) error {
+	bucket := tx.Bucket(outboxBucket)
+	for _, op := range ops {
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		obytes, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(seqKey(seq), obytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seqKey(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+// outbox applies pending graph ops in order. A single mutex guards the graph writes so ops from concurrent
+// requests and the retry loop are never applied out of order.
+type outbox struct {
+	kvstore KVStore
+	gdb     GraphDB
+	mu      sync.Mutex
+}
+
+func newOutbox(lc fx.Lifecycle, kv KVStore, g GraphDB) *outbox {
+	o := &outbox{
+		kvstore: kv,
+		gdb:     g,
+	}
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			// ops left pending, such as those of an import made while the server was stopped, are applied
+			// before serving
+			if _, err := o.drain(); err != nil {
+				logrus.WithError(err).Warn("graph ops still pending")
+			}
+			go o.run(done)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+	return o
+}
+
+func (o *outbox) run(done chan struct{}) {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, err := o.drain(); err != nil {
+				logrus.WithError(err).Warn("graph ops still pending")
+			}
+		}
+	}
+}
+
+// drain applies every pending op to the graph, stopping at the first failure so ordering is preserved
+func (o *outbox) drain() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.drainLocked()
+}
+
+func (o *outbox) drainLocked() (int, error) {
+	pending, err := o.kvstore.PendingGraphOps()
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for len(pending) > 0 {
+		// consecutive ops which can be batched, such as the writes of a bulk request, share a transaction
+		n := 1
+		if pending[0].Op.batchable() {
+			for n < len(pending) && n < maxGraphBatch && pending[n].Op.batchable() {
+				n++
+			}
+		}
+		batch := pending[:n]
+		var err error
+		if n == 1 {
+			err = batch[0].Op.apply(o.gdb)
+		} else {
+			ops := make([]GraphOp, n)
+			for i, p := range batch {
+				ops[i] = p.Op
+			}
+			err = o.gdb.ApplyBatch(ops)
+		}
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"seq": batch[0].Seq,
+				"op":  batch[0].Op.Op,
+				"ops": n,
+			}).Error("unable to apply graph op")
+			return applied, errors.New("unable to apply graph op")
+		}
+		if err := o.kvstore.AckGraphOps(batch[n-1].Seq); err != nil {
+			return applied, err
+		}
+		applied += n
+		pending = pending[n:]
+	}
+	return applied, nil
+}
+
+// discard acknowledges every pending op without applying it, used when the graph is rebuilt from the kv store
+func (o *outbox) discard() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending, err := o.kvstore.PendingGraphOps()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	return o.kvstore.AckGraphOps(pending[len(pending)-1].Seq)
+}
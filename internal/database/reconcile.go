@@ -0,0 +1,82 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// reconcileGraphKey is set in the meta bucket when the kv store was changed without the graph, such as by
+// an offline restore, so the graph is reconciled at the next start
+const reconcileGraphKey = "graph_reconcile"
+
+// needsReconcile tells whether the graph may have drifted from the kv store while the app was down. The
+// graph ops left pending are applied first, a full diff only runs when they fail or the drift was flagged.
+// It is also available on demand through the admin endpoint.
+func (r *repository) needsReconcile() bool {
+	if flagged, _ := r.kvstore.GetMeta(reconcileGraphKey); flagged != "" {
+		return true
+	}
+	applied, err := r.outbox.drain()
+	if err != nil {
+		logrus.WithError(err).Warn("unable to apply pending graph ops")
+		return true
+	}
+	if applied > 0 {
+		logrus.WithField("ops", applied).Info("pending graph ops applied")
+	}
+	return false
+}
+
+// Reconcile diffs the kv records against the graph quads and, when fix is set, repairs the graph so it
+// matches the kv store. Pending outbox ops are applied first so in flight writes are not reported as drift.
+func (r *repository) Reconcile(fix bool) (internal.ReconcileReport, error) {
+	var report internal.ReconcileReport
+
+	r.outbox.mu.Lock()
+	defer r.outbox.mu.Unlock()
+	if _, err := r.outbox.drainLocked(); err != nil {
+		logrus.WithError(err).Warn("unable to apply pending graph ops before reconciling")
+	}
+	pending, err := r.kvstore.PendingGraphOps()
+	if err != nil {
+		return report, err
+	}
+	report.Pending = len(pending)
+
+	resources, err := r.kvstore.GetAllResources()
+	if err != nil {
+		return report, err
+	}
+	tags, err := r.kvstore.GetAllTags()
+	if err != nil {
+		return report, err
+	}
+	report.Resources = len(resources)
+	report.Tags = len(tags)
+
+	missing, extra, err := r.gdb.Diff(resources, tags)
+	if err != nil {
+		logrus.WithError(err).Error("unable to diff graph")
+		return report, errors.New("unable to reconcile graph")
+	}
+	for _, q := range missing {
+		report.Missing = append(report.Missing, q.String())
+	}
+	for _, q := range extra {
+		report.Extra = append(report.Extra, q.String())
+	}
+
+	if fix && (len(missing) > 0 || len(extra) > 0) {
+		logrus.WithFields(logrus.Fields{
+			"missing": len(missing),
+			"extra":   len(extra),
+		}).Warn("repairing graph drift")
+		if err := r.gdb.Repair(missing, extra); err != nil {
+			return report, err
+		}
+		report.Fixed = true
+	}
+	return report, nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestStartupReconcilesOnlyWhenFlagged(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "tagged", "a")
+	// written to the kv store alone, the graph does not know about them
+	putResources(t, env.kv, 3)
+
+	env = env.reopen(t)
+	report, err := env.repo.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) == 0 {
+		t.Fatal("startup reconciled the graph without a reason to")
+	}
+
+	if err := env.kv.PutMeta(reconcileGraphKey, "true"); err != nil {
+		t.Fatal(err)
+	}
+	env = env.reopen(t)
+	report, err = env.repo.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missing) != 0 || len(report.Extra) != 0 {
+		t.Errorf("flagged startup left drift: missing %v, extra %v", report.Missing, report.Extra)
+	}
+	if flag, _ := env.kv.GetMeta(reconcileGraphKey); flag != "" {
+		t.Errorf("reconcile flag %q left set", flag)
+	}
+}
+
+func TestStartupAppliesPendingGraphOps(t *testing.T) {
+	env := newTestRepository(t)
+	// the op is queued but not applied, as after a crash between the kv write and the graph write
+	tag := internal.Tag{Name: "pending", Color: "#000000"}
+	if err := env.kv.PutTag(tag.Name, &tag, GraphOp{Op: opCreateTag, Tag: &tag}); err != nil {
+		t.Fatal(err)
+	}
+
+	env = env.reopen(t)
+	report, err := env.repo.Reconcile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pending != 0 || len(report.Missing) != 0 {
+		t.Errorf("pending op not applied at startup: pending %d, missing %v", report.Pending, report.Missing)
+	}
+}
@@ -3,139 +3,652 @@ package database
 import (
 	"errors"
 	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/query"
 	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+	"strings"
+	"time"
 )
 
 type Repository interface {
 	internal.ResourceFactory
 	internal.ResourceRepository
+	internal.ResourceUpdater
 	internal.TagRepository
 	internal.TagFactory
+	internal.TagUpdater
+	internal.TagHierarchy
+	internal.TagAliaser
+	internal.TagNormalizer
+	internal.ResourceTypeRegistry
 	internal.ResourceTagger
+	internal.ResourceHistory
+	internal.Trash
+	internal.IdempotencyStore
+	internal.ResourceBulkWriter
+	internal.ResourceRetagger
+	internal.DataTransfer
+	internal.Reconciler
+	internal.BackupRestorer
+	// WithActor returns a repository recording the given actor as the creator or updater of the records
+	// it writes
+	WithActor(actor string) Repository
+	// WithPrecondition returns a repository which only writes a resource when it satisfies the precondition,
+	// failing with ErrPrecondition otherwise
+	WithPrecondition(cond internal.Precondition) Repository
 }
 
 type repository struct {
 	kvstore KVStore
 	gdb     GraphDB
+	outbox  *outbox
+	policy  internal.TagPolicy
+	newID   func() string
+	clock   internal.Clock
+	actor   string
+	cond    internal.Precondition
+	// idempotencyTTL is how long idempotency keys are kept
+	idempotencyTTL time.Duration
+	jobs           *jobRunner
 }
 
-func NewRepository(kv KVStore, g GraphDB) Repository {
+func NewRepository(lc fx.Lifecycle, config internal.Configuration, clock internal.Clock, kv KVStore, g GraphDB) Repository {
+	newID, err := internal.NewIDGenerator(config.IDFormat)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid id format")
+	}
 	r := &repository{
 		kvstore: kv,
 		gdb:     g,
+		outbox:  newOutbox(lc, kv, g),
+		policy:  config.TagPolicy,
+		newID:   newID,
+		clock:   clock,
+		jobs:    newJobRunner(lc),
+
+		idempotencyTTL: config.IdempotencyTTL,
+	}
+	if g.NeedsRebuild() {
+		if err := r.outbox.discard(); err != nil {
+			logrus.WithError(err).Fatal("unable to discard pending graph ops")
+		}
+		r.initializeGraphDB()
+		if err := g.MarkBuilt(); err != nil {
+			logrus.WithError(err).Fatal("unable to mark graph db as built")
+		}
+	} else if r.needsReconcile() {
+		if report, err := r.Reconcile(true); err != nil {
+			logrus.WithError(err).Error("unable to reconcile graph db")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"missing": len(report.Missing),
+				"extra":   len(report.Extra),
+				"pending": report.Pending,
+			}).Info("graph database reconciled")
+			if err := r.kvstore.PutMeta(reconcileGraphKey, ""); err != nil {
+				logrus.WithError(err).Error("unable to clear graph reconcile flag")
+			}
+		}
 	}
-	r.initializeGraphDB()
+	r.checkTagPolicy()
+	r.failInterruptedJobs()
+	runJanitor(lc, r, config.TrashRetention)
 	return r
 }
 
+// checkTagPolicy reports the stored tags which do not follow the tag policy, once for every change of the
+// policy. Nothing is rewritten at startup, the report names the changes POST /admin/normalize-tags makes.
+func (r *repository) checkTagPolicy() {
+	if applied, _ := r.kvstore.GetMeta(tagPolicyKey); applied == r.policy.String() {
+		return
+	}
+	report, err := r.NormalizeTags(true)
+	if err != nil {
+		logrus.WithError(err).Error("unable to check tags against the tag policy")
+		return
+	}
+	for name, reason := range report.Invalid {
+		logrus.WithField("tag", name).Warn(reason)
+	}
+	if len(report.Renamed) == 0 && len(report.Merged) == 0 && len(report.Aliases) == 0 {
+		if err := r.kvstore.PutMeta(tagPolicyKey, r.policy.String()); err != nil {
+			logrus.WithError(err).Error("unable to record tag policy")
+		}
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"renamed": len(report.Renamed),
+		"merged":  len(report.Merged),
+		"aliases": len(report.Aliases),
+		"invalid": len(report.Invalid),
+	}).Warn("stored tags do not follow the tag policy, normalize them with POST /admin/normalize-tags")
+}
+
+func (r *repository) WithActor(actor string) Repository {
+	c := *r
+	c.actor = actor
+	return &c
+}
+
+func (r *repository) WithPrecondition(cond internal.Precondition) Repository {
+	c := *r
+	c.cond = cond
+	return &c
+}
+
+func (r *repository) change(op string, at time.Time) Change {
+	return Change{Op: op, At: at, By: r.actor}
+}
+
+// assigned stamps a tag put on a resource, keeping the original assignment when the resource already had it
+func (r *repository) assigned(tag internal.Tag, previous []internal.Tag, now time.Time) internal.Tag {
+	for _, p := range previous {
+		if p.Name == tag.Name && p.TaggedAt != nil {
+			tag.TaggedAt, tag.TaggedBy = p.TaggedAt, p.TaggedBy
+			return tag
+		}
+	}
+	tag.TaggedAt, tag.TaggedBy = &now, r.actor
+	return tag
+}
+
+// syncGraph applies pending graph ops after a kv write. The kv store is the source of truth so a failure
+// here only delays the graph, the outbox keeps retrying in the background.
+func (r *repository) syncGraph() {
+	if r.outbox == nil {
+		// opened without the graph, see NewTransfer
+		return
+	}
+	if _, err := r.outbox.drain(); err != nil {
+		logrus.WithError(err).Warn("graph database is behind, will retry")
+	}
+}
+
+// CreateResource stores a new resource, an id is generated when the resource does not have one
 func (r *repository) CreateResource(resource internal.Resource) (internal.Resource, error) {
+	if resource.ID == "" {
+		resource.ID = r.newID()
+	}
 	re, err := r.FindResourceByID(resource.ID)
 	if err == internal.ErrNotFound {
-		err = nil
-		if resource.ID == "" || resource.Name == "" || resource.Type == "" {
+		if resource.Name == "" || resource.Type == "" {
 			return resource, internal.ErrInvalid
 		}
+		if err := resource.Attributes.Validate(); err != nil {
+			return resource, err
+		}
+		rt, err := r.checkResource(resource)
+		if err != nil {
+			return resource, err
+		}
 
+		now := r.clock.Now()
 		var tags []internal.Tag
+		seen := make(map[string]bool)
 		for _, t := range resource.Tags {
+			if t.Color == "" && rt != nil {
+				t.Color = rt.DefaultColor
+			}
 			tag, err := r.CreateTag(t)
+			if errors.Is(err, internal.ErrInvalid) {
+				return resource, err
+			}
 			if err != nil {
 				logrus.WithError(err).Error("unable to create tag")
 				return resource, errors.New("failed to save resource")
 			}
-			tags = append(tags, tag)
+			if seen[tag.Name] {
+				continue
+			}
+			seen[tag.Name] = true
+			tags = append(tags, r.assigned(tag, nil, now))
 		}
 
 		re = internal.Resource{
-			ID:   resource.ID,
-			Name: resource.Name,
-			Type: resource.Type,
-			Tags: tags,
+			ID:         resource.ID,
+			Name:       resource.Name,
+			Type:       resource.Type,
+			Tags:       tags,
+			Attributes: resource.Attributes,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			CreatedBy:  r.actor,
+			UpdatedBy:  r.actor,
 		}
 
-		if err := r.kvstore.PutResource(re.ID, re); err != nil {
-			logrus.WithError(err).Error("unable to store to kv")
-			return re, errors.New("unable to save resource")
-		}
-
-		if err := r.gdb.CreateResource(re); err != nil {
-			logrus.WithError(err).Error("unable to store to gbd")
-			return re, errors.New("unable to save resource")
+		re, err = r.kvstore.MutateResource(re.ID, r.cond, r.change(opCreateResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current != nil {
+				return nil, nil, internal.ErrConflict
+			}
+			return &re, []GraphOp{{Op: opCreateResource, Resource: &re}}, nil
+		})
+		if err != nil {
+			return re, r.writeError(err)
 		}
+		r.syncGraph()
 		return re, nil
 	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to create resource")
 		return resource, errors.New("failed to save resource")
 	}
+	if err := r.cond.Check(true, re.Revision); err != nil {
+		return re, err
+	}
 	return re, internal.ErrConflict
 }
 
+func (r *repository) UpdateResource(resource internal.Resource) (internal.Resource, error) {
+	if resource.ID == "" || resource.Name == "" || resource.Type == "" {
+		return resource, internal.ErrInvalid
+	}
+	if err := resource.Attributes.Validate(); err != nil {
+		return resource, err
+	}
+
+	_, err := r.FindResourceByID(resource.ID)
+	if err == internal.ErrNotFound {
+		return resource, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find resource")
+		return resource, errors.New("unable to find resource")
+	}
+	rt, err := r.checkResource(resource)
+	if err != nil {
+		return resource, err
+	}
+
+	now := r.clock.Now()
+	var tags []internal.Tag
+	seen := make(map[string]bool)
+	for _, t := range resource.Tags {
+		if t.Color == "" && rt != nil {
+			t.Color = rt.DefaultColor
+		}
+		tag, err := r.CreateTag(t)
+		if errors.Is(err, internal.ErrInvalid) {
+			return resource, err
+		}
+		if err != nil {
+			logrus.WithError(err).Error("unable to create tag")
+			return resource, errors.New("failed to save resource")
+		}
+		if seen[tag.Name] {
+			continue
+		}
+		seen[tag.Name] = true
+		tags = append(tags, tag)
+	}
+
+	re, err := r.kvstore.MutateResource(resource.ID, r.cond, r.change(opUpdateResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		re := internal.Resource{
+			ID:         resource.ID,
+			Name:       resource.Name,
+			Type:       resource.Type,
+			Attributes: resource.Attributes,
+			CreatedAt:  current.CreatedAt,
+			UpdatedAt:  now,
+			CreatedBy:  current.CreatedBy,
+			UpdatedBy:  r.actor,
+		}
+		for _, tag := range tags {
+			re.Tags = append(re.Tags, r.assigned(tag, current.Tags, now))
+		}
+		return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+	})
+	if err != nil {
+		return re, r.writeError(err)
+	}
+	r.syncGraph()
+	return re, nil
+}
+
+// errUnchanged is returned within a write transaction for a record which needs no change, the record is
+// skipped rather than written again
+var errUnchanged = errors.New("record unchanged")
+
+// callerError reports whether err was caused by the request rather than by the store
+func callerError(err error) bool {
+	var terr *internal.TypeError
+	switch {
+	case err == internal.ErrNotFound, err == internal.ErrConflict, err == internal.ErrPrecondition:
+		return true
+	case errors.Is(err, internal.ErrInvalid), errors.As(err, &terr):
+		return true
+	}
+	return false
+}
+
+// writeError passes on the errors a caller can act on and logs the rest
+func (r *repository) writeError(err error) error {
+	if callerError(err) {
+		return err
+	}
+	logrus.WithError(err).Error("unable to store to kv")
+	return errors.New("unable to save resource")
+}
+
+func (r *repository) DeleteResource(id string) error {
+	_, err := r.kvstore.MutateResource(id, r.cond, r.change(opDeleteResource, r.clock.Now()), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		return nil, []GraphOp{{Op: opDeleteResource, Resource: current}}, nil
+	})
+	if err != nil {
+		return r.writeError(err)
+	}
+	r.syncGraph()
+	return nil
+}
+
 func (r repository) FindResourceByID(id string) (internal.Resource, error) {
 	return r.kvstore.GetResource(id)
 }
 
-func (r *repository) FindAllResources(params *internal.ResourceParams) ([]internal.Resource, error) {
+// FindAllResources returns a page of the resources matching the params, the first page of every resource
+// when there are none. The cursor of the next page is empty on the last page.
+func (r *repository) FindAllResources(params *internal.ResourceParams) ([]internal.Resource, string, error) {
 	if params == nil {
-		return r.kvstore.GetAllResources()
+		params = &internal.ResourceParams{}
 	}
-	ids, err := r.gdb.FindAllResources(*params)
+
+	include, err := r.resourceFilter(*params)
 	if err != nil {
-		logrus.WithError(err).Error("unable to find ids")
-		return nil, errors.New("unable to find ids")
+		return nil, "", err
 	}
-	var resources []internal.Resource
-	for _, id := range ids {
-		resource, err := r.kvstore.GetResource(id)
+	page := Page{Sort: params.Sort, Cursor: params.Cursor, Limit: params.Limit}
+	return r.kvstore.ListResources(page, include)
+}
+
+// resourceFilter returns whether a resource id matches the params, it is nil when the params do not filter
+func (r *repository) resourceFilter(params internal.ResourceParams) (func(id string) bool, error) {
+	if !params.Filtered() {
+		return nil, nil
+	}
+	ids, err := r.filterResourceIDs(params)
+	if err != nil {
+		return nil, err
+	}
+	return contains(ids), nil
+}
+
+// filterResourceIDs lists the resources matching a filter from the graph and the updated index, the params
+// must be filtered
+func (r *repository) filterResourceIDs(params internal.ResourceParams) ([]string, error) {
+	var ids []string
+	var include func(id string) bool
+	if params.Type != "" || params.Name != "" || params.Tag != "" || params.Query != "" || len(params.Attr) > 0 {
+		var err error
+		if ids, err = r.findResourceIDs(params); err != nil {
+			return nil, err
+		}
+		include = contains(ids)
+	}
+	if params.TaggedSince != "" {
+		var err error
+		if ids, err = r.findTaggedSince(params, include); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// findTaggedSince lists the resources which were given the requested tag, or any tag when no tag is
+// requested, within the TaggedSince window
+func (r *repository) findTaggedSince(params internal.ResourceParams, include func(id string) bool) ([]string, error) {
+	since, err := internal.ParseSince(params.TaggedSince, r.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	tag := params.Tag
+	if tag != "" {
+		if tag, err = r.queryTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	// giving a resource a tag updates it, so only resources updated within the window can match
+	resources, err := r.kvstore.GetResourcesUpdatedSince(since)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, re := range resources {
+		if include != nil && !include(re.ID) {
+			continue
+		}
+		for _, t := range re.Tags {
+			matches := tag == "" || t.Name == tag || (params.Descendants && strings.HasPrefix(t.Name, tag+"/"))
+			if matches && t.TaggedAt != nil && !t.TaggedAt.Before(since) {
+				ids = append(ids, re.ID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (r *repository) findResourceIDs(params internal.ResourceParams) ([]string, error) {
+	for _, a := range params.Attr {
+		if _, err := internal.ParseAttributeFilter(a); err != nil {
+			return nil, err
+		}
+	}
+	if params.Tag != "" {
+		tag, err := r.queryTag(params.Tag)
+		if err != nil {
+			return nil, err
+		}
+		params.Tag = tag
+	}
+	if params.Query == "" {
+		ids, err := r.gdb.FindAllResources(params)
 		if err != nil {
-			logrus.WithError(err).Error("unable to retrieve id")
-			return resources, nil
+			logrus.WithError(err).Error("unable to find ids")
+			return nil, errors.New("unable to find ids")
 		}
-		resources = append(resources, resource)
+		return ids, nil
+	}
+
+	expr, err := query.Parse(params.Query)
+	if err != nil {
+		return nil, err
+	}
+	if expr, err = query.MapTags(expr, r.queryTag); err != nil {
+		return nil, err
+	}
+	if expr, err = query.MapNamespaces(expr, r.policy.Normalize); err != nil {
+		return nil, err
 	}
-	return resources, nil
+	ids, err := r.gdb.FindResourcesByQuery(expr, params.Descendants)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find ids")
+		return nil, errors.New("unable to find ids")
+	}
+	if params.Type == "" && params.Name == "" && params.Tag == "" && len(params.Attr) == 0 {
+		return ids, nil
+	}
+	filtered, err := r.gdb.FindAllResources(params)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find ids")
+		return nil, errors.New("unable to find ids")
+	}
+	return intersection(ids, filtered), nil
 }
 
+// FindTagByName looks up a tag by its name or any of its aliases
 func (r *repository) FindTagByName(name string) (internal.Tag, error) {
+	name, err := r.lookupTag(name)
+	if err != nil {
+		return internal.Tag{}, err
+	}
 	return r.kvstore.GetTag(name)
 }
 
-func (r *repository) FindAllTags(params *internal.TagParams) ([]internal.Tag, error) {
-	if params == nil {
-		return r.kvstore.GetAllTags()
+// lookupTag normalizes and resolves a name used to find an existing tag. Names which fail the policy are
+// looked up as given so tags stored before the policy changed can still be reached.
+func (r *repository) lookupTag(name string) (string, error) {
+	if n, err := r.policy.Normalize(name); err == nil {
+		name = n
 	}
-	ids, err := r.gdb.FindAllTags(*params)
+	return r.resolveTag(name)
+}
+
+// queryTag normalizes and resolves a name used in a query, names which fail the policy are rejected
+func (r *repository) queryTag(name string) (string, error) {
+	n, err := r.policy.Normalize(name)
 	if err != nil {
-		logrus.WithError(err).Error("unable to find ids")
-		return nil, errors.New("unable to find ids")
+		return name, err
 	}
-	var tags []internal.Tag
-	for _, id := range ids {
-		tag, err := r.kvstore.GetTag(id)
+	return r.resolveTag(n)
+}
+
+// resolveTag returns the canonical name for an alias, names which are not aliases are returned unchanged
+func (r *repository) resolveTag(name string) (string, error) {
+	tag, err := r.kvstore.GetAlias(name)
+	if err == internal.ErrNotFound {
+		return name, nil
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find alias")
+		return name, errors.New("unable to find alias")
+	}
+	return tag, nil
+}
+
+// ResolveTagNames maps each of the given names which is an alias to its canonical tag name
+func (r *repository) ResolveTagNames(names []string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	for _, name := range names {
+		n, err := r.policy.Normalize(name)
 		if err != nil {
-			logrus.WithError(err).Error("unable to retrieve id")
-			return tags, nil
+			continue
+		}
+		tag, err := r.resolveTag(n)
+		if err != nil {
+			return nil, err
+		}
+		if tag != n {
+			resolved[name] = tag
 		}
-		tags = append(tags, tag)
 	}
-	return tags, nil
+	return resolved, nil
+}
+
+func (r *repository) AddTagAlias(name string, alias string) ([]string, error) {
+	alias, err := r.policy.Normalize(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := r.findTag(name)
+	if err != nil {
+		return nil, err
+	}
+	if alias == tag.Name {
+		return nil, internal.ErrInvalid
+	}
+
+	if err := r.kvstore.PutAlias(alias, tag.Name); err != nil {
+		return nil, err
+	}
+	return r.kvstore.GetAliases(tag.Name)
+}
+
+func (r *repository) DeleteTagAlias(name string, alias string) error {
+	tag, err := r.findTag(name)
+	if err != nil {
+		return err
+	}
+	if n, err := r.policy.Normalize(alias); err == nil {
+		alias = n
+	}
+	if canonical, err := r.resolveTag(alias); err != nil || canonical != tag.Name || alias == tag.Name {
+		if err != nil {
+			return err
+		}
+		return internal.ErrNotFound
+	}
+	return r.kvstore.DeleteAlias(alias)
+}
+
+func (r *repository) FindTagAliases(name string) ([]string, error) {
+	tag, err := r.findTag(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.kvstore.GetAliases(tag.Name)
+}
+
+// FindAllTags returns a page of the tags matching the params, the first page of every tag when there are
+// none. The cursor of the next page is empty on the last page.
+func (r *repository) FindAllTags(params *internal.TagParams) ([]internal.Tag, string, error) {
+	if params == nil {
+		params = &internal.TagParams{}
+	}
+
+	var include func(name string) bool
+	if params.Namespace != "" {
+		namespace, err := r.policy.Normalize(params.Namespace)
+		if err != nil {
+			return nil, "", err
+		}
+		p := *params
+		p.Namespace = namespace
+		params = &p
+	}
+	if params.Type != "" || params.Namespace != "" {
+		ids, err := r.gdb.FindAllTags(*params)
+		if err != nil {
+			logrus.WithError(err).Error("unable to find ids")
+			return nil, "", errors.New("unable to find ids")
+		}
+		include = contains(ids)
+	}
+
+	page := Page{Sort: params.Sort, Cursor: params.Cursor, Limit: params.Limit}
+	return r.kvstore.ListTags(page, include)
+}
+
+func contains(ids []string) func(id string) bool {
+	set := make(map[string]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+	return func(id string) bool {
+		return set[id]
+	}
 }
 
 func (r *repository) CreateTag(tag internal.Tag) (internal.Tag, error) {
+	name, err := r.policy.Normalize(tag.Name)
+	if err != nil {
+		return tag, err
+	}
+	if tag.Color != "" && !tag.Color.Valid() {
+		return tag, &internal.ValidationError{Rule: "color", Value: string(tag.Color), Msg: "color must be of the form #RRGGBB"}
+	}
+	tag.Name = name
+
 	t, err := r.FindTagByName(tag.Name)
 	if err == internal.ErrNotFound {
-		t = internal.Tag{
-			Name:  tag.Name,
-			Color: internal.GetRandomColor(),
+		parent := internal.ParentTagName(tag.Name)
+		if parent != "" {
+			if _, err := r.CreateTag(internal.Tag{Name: parent}); err != nil {
+				return tag, err
+			}
 		}
-		if err := r.kvstore.PutTag(t.Name, t); err != nil {
+		t = r.newTag(tag)
+		if err := r.kvstore.PutTag(t.Name, &t, GraphOp{Op: opCreateTag, Tag: &t}); err != nil {
 			logrus.WithError(err).Error("unable to save tag kv")
 			return tag, errors.New("not able to save tag")
 		}
-		if err := r.gdb.CreateTag(t); err != nil {
-			logrus.WithError(err).Error("unable to save tag graph")
-			return tag, errors.New("not able to save tag")
-		}
+		r.syncGraph()
 		return t, nil
 	}
 	if err != nil {
@@ -145,67 +658,423 @@ func (r *repository) CreateTag(tag internal.Tag) (internal.Tag, error) {
 	return t, nil
 }
 
-func (r *repository) AddTagToResource(resource internal.Resource, tag string) (internal.Resource, error) {
-	t, err := r.CreateTag(internal.Tag{Name: tag})
+// newTag returns the record for a tag about to be created, a random color is picked when it has none
+func (r *repository) newTag(tag internal.Tag) internal.Tag {
+	t := internal.Tag{
+		Name:        tag.Name,
+		Color:       tag.Color,
+		Description: tag.Description,
+		CreatedAt:   r.clock.Now(),
+		CreatedBy:   r.actor,
+		UpdatedBy:   r.actor,
+	}
+	t.UpdatedAt = t.CreatedAt
+	t.Namespace, t.Value = internal.SplitTagName(t.Name)
+	t.Parent = internal.ParentTagName(t.Name)
+	if t.Color == "" {
+		t.Color = internal.GetRandomColor()
+	}
+	return t
+}
+
+func (r *repository) UpdateTag(tag internal.Tag) (internal.Tag, error) {
+	if !tag.Color.Valid() {
+		return tag, internal.ErrInvalid
+	}
+
+	old, err := r.findTag(tag.Name)
+	if err != nil {
+		return tag, err
+	}
+	tag.Name, tag.Parent = old.Name, old.Parent
+	tag.Namespace, tag.Value = internal.SplitTagName(tag.Name)
+	tag.CreatedAt, tag.CreatedBy = old.CreatedAt, old.CreatedBy
+	tag.UpdatedAt, tag.UpdatedBy = r.clock.Now(), r.actor
+	tag.TaggedAt, tag.TaggedBy = nil, ""
+
+	err = r.kvstore.ReplaceTag(old.Name, &tag, r.cond, r.change(historyUpdateTag, tag.UpdatedAt), replaceTagOp(old, &tag))
+	if err == internal.ErrPrecondition || err == internal.ErrNotFound {
+		return tag, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to update tag kv")
+		return tag, errors.New("unable to save tag")
+	}
+	r.syncGraph()
+	return tag, nil
+}
+
+func (r *repository) RenameTag(name string, newName string) (internal.Tag, error) {
+	newName, err := r.policy.Normalize(newName)
+	if err != nil {
+		return internal.Tag{}, err
+	}
+
+	old, err := r.findTag(name)
+	if err != nil {
+		return old, err
+	}
+	name = old.Name
+	if newName == name {
+		return old, nil
+	}
+	if err := r.checkNoChildren(name); err != nil {
+		return old, err
+	}
+
+	tag := old
+	tag.Name = newName
+	tag.Namespace, tag.Value = internal.SplitTagName(newName)
+	tag.Parent = internal.ParentTagName(newName)
+	tag.UpdatedAt, tag.UpdatedBy = r.clock.Now(), r.actor
+	if tag.Parent != "" {
+		if _, err := r.CreateTag(internal.Tag{Name: tag.Parent}); err != nil {
+			return old, err
+		}
+	}
+	err = r.kvstore.RenameTag(old.Name, &tag, r.cond, r.change(historyRenameTag, tag.UpdatedAt), replaceTagOp(old, &tag))
+	if err == internal.ErrConflict || err == internal.ErrNotFound || err == internal.ErrPrecondition {
+		return old, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to rename tag kv")
+		return old, errors.New("unable to rename tag")
+	}
+	r.syncGraph()
+	return tag, nil
+}
+
+func (r *repository) MergeTag(name string, into string) (internal.Tag, error) {
+	old, err := r.findTag(name)
+	if err != nil {
+		return old, err
+	}
+	target, err := r.findTag(into)
+	if err != nil {
+		return target, err
+	}
+	if old.Name == target.Name {
+		return target, internal.ErrInvalid
+	}
+	if err := r.checkNoChildren(old.Name); err != nil {
+		return target, err
+	}
+
+	target.UpdatedAt, target.UpdatedBy = r.clock.Now(), r.actor
+	err = r.kvstore.ReplaceTag(old.Name, &target, r.cond, r.change(historyMergeTag, target.UpdatedAt), replaceTagOp(old, &target))
+	if err == internal.ErrPrecondition || err == internal.ErrNotFound {
+		return target, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to merge tag kv")
+		return target, errors.New("unable to merge tag")
+	}
+	r.syncGraph()
+	return target, nil
+}
+
+func (r *repository) DeleteTag(name string, cascade bool) error {
+	old, err := r.findTag(name)
+	if err != nil {
+		return err
+	}
+	if err := r.checkNoChildren(old.Name); err != nil {
+		return err
+	}
+
+	if !cascade {
+		ids, err := r.gdb.FindAllResources(internal.ResourceParams{Tag: old.Name})
+		if err != nil {
+			logrus.WithError(err).Error("unable to find tagged resources")
+			return errors.New("unable to delete tag")
+		}
+		if len(ids) > 0 {
+			return internal.ErrConflict
+		}
+	}
+
+	err = r.kvstore.ReplaceTag(old.Name, nil, r.cond, r.change(historyDeleteTag, r.clock.Now()), replaceTagOp(old, nil))
+	if err == internal.ErrPrecondition || err == internal.ErrNotFound {
+		return err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to delete tag kv")
+		return errors.New("unable to delete tag")
+	}
+	r.syncGraph()
+	return nil
+}
+
+func replaceTagOp(old internal.Tag, tag *internal.Tag) GraphOp {
+	return GraphOp{Op: opReplaceTag, OldTag: &old, Tag: tag}
+}
+
+// checkNoChildren refuses changes which would leave the children of a tag without a parent
+func (r *repository) checkNoChildren(name string) error {
+	children, err := r.gdb.FindTagChildren(name, false)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find tag children")
+		return errors.New("unable to find tag children")
+	}
+	if len(children) > 0 {
+		return internal.ErrConflict
+	}
+	return nil
+}
+
+func (r *repository) FindTagChildren(name string, recursive bool) ([]internal.Tag, error) {
+	parent, err := r.findTag(name)
+	if err != nil {
+		return nil, err
+	}
+	names, err := r.gdb.FindTagChildren(parent.Name, recursive)
+	if err != nil {
+		logrus.WithError(err).Error("unable to find tag children")
+		return nil, errors.New("unable to find tag children")
+	}
+	var tags []internal.Tag
+	for _, n := range names {
+		tag, err := r.findTag(n)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// FindTagAncestors walks the parents of a tag, nearest first
+func (r *repository) FindTagAncestors(name string) ([]internal.Tag, error) {
+	tag, err := r.findTag(name)
+	if err != nil {
+		return nil, err
+	}
+	var tags []internal.Tag
+	for tag.Parent != "" {
+		tag, err = r.findTag(tag.Parent)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (r *repository) findTag(name string) (internal.Tag, error) {
+	tag, err := r.FindTagByName(name)
+	if err == internal.ErrNotFound {
+		return tag, err
+	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to find tag")
-		return resource, errors.New("unable to find tag")
+		return tag, errors.New("unable to find tag")
+	}
+	return tag, nil
+}
+
+func (r *repository) AddTagToResource(resource internal.Resource, tag string) (internal.Resource, error) {
+	tag, err := r.policy.Normalize(tag)
+	if err != nil {
+		return resource, err
 	}
 
 	resource, err = r.FindResourceByID(resource.ID)
+	if err == internal.ErrNotFound {
+		return resource, err
+	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to find resource")
 		return resource, errors.New("unable to find resource")
 	}
+	if tag, err = r.resolveTag(tag); err != nil {
+		return resource, err
+	}
 
-	tags := []internal.Tag{t}
 	for _, tg := range resource.Tags {
-		if tg.Name != t.Name {
-			tags = append(tags, tg)
+		if tg.Name == tag {
+			return resource, internal.ErrConflict
 		}
 	}
 
-	resource.Tags = tags
-	if err := r.kvstore.PutResource(resource.ID, resource); err != nil {
-		logrus.WithError(err).Error("unable to save resource")
-		return resource, errors.New("unable to save resource")
+	candidate := resource
+	candidate.Tags = append([]internal.Tag{{Name: tag}}, resource.Tags...)
+	rt, err := r.checkResource(candidate)
+	if err != nil {
+		return resource, err
+	}
+	newTag := internal.Tag{Name: tag}
+	if rt != nil {
+		newTag.Color = rt.DefaultColor
+	}
+
+	t, err := r.CreateTag(newTag)
+	if errors.Is(err, internal.ErrInvalid) {
+		return resource, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to create tag")
+		return resource, errors.New("unable to create tag")
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return resource, err
 	}
 
-	if err := r.gdb.AddResourceTag(resource, tag); err != nil {
-		logrus.WithError(err).Error("unable to save resource graph")
-		return resource, errors.New("unable to save resource")
+	now := r.clock.Now()
+	re, err := r.kvstore.MutateResource(resource.ID, r.cond, r.change(opAddResourceTag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		for _, tg := range current.Tags {
+			if tg.Name == tag {
+				return nil, nil, internal.ErrConflict
+			}
+		}
+		re := *current
+		re.Tags = append([]internal.Tag{r.assigned(t, nil, now)}, current.Tags...)
+		re.UpdatedAt, re.UpdatedBy = now, r.actor
+		if err := check(re); err != nil {
+			return nil, nil, err
+		}
+		return &re, []GraphOp{{Op: opAddResourceTag, Resource: &re, TagName: tag}}, nil
+	})
+	if err != nil {
+		return resource, r.writeError(err)
 	}
+	r.syncGraph()
 
-	return resource, nil
+	return re, nil
 }
 
 func (r *repository) DeleteTagFromResource(resource internal.Resource, tag string) error {
+	if tag == "" {
+		return internal.ErrInvalid
+	}
 
 	resource, err := r.FindResourceByID(resource.ID)
+	if err == internal.ErrNotFound {
+		return err
+	}
 	if err != nil {
 		logrus.WithError(err).Error("unable to find resource")
 		return errors.New("unable to find resource")
 	}
+	if tag, err = r.lookupTag(tag); err != nil {
+		return err
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return err
+	}
 
-	var tags []internal.Tag
-	for _, tg := range resource.Tags {
-		if tg.Name != tag {
-			tags = append(tags, tg)
+	now := r.clock.Now()
+	_, err = r.kvstore.MutateResource(resource.ID, r.cond, r.change(opDeleteResourceTag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		re := *current
+		re.Tags = nil
+		for _, tg := range current.Tags {
+			if tg.Name != tag {
+				re.Tags = append(re.Tags, tg)
+			}
+		}
+		if len(re.Tags) == len(current.Tags) {
+			return nil, nil, internal.ErrNotFound
+		}
+		re.UpdatedAt, re.UpdatedBy = now, r.actor
+		if err := check(re); err != nil {
+			return nil, nil, err
 		}
+		return &re, []GraphOp{{Op: opDeleteResourceTag, Resource: &re, TagName: tag}}, nil
+	})
+	if err != nil {
+		return r.writeError(err)
+	}
+	r.syncGraph()
+
+	return nil
+}
+
+// ReplaceResourceTags sets the tags of a resource to exactly the given list, creating any new tags
+// and removing the graph edges of tags which are no longer present.
+func (r *repository) ReplaceResourceTags(resource internal.Resource, names []string) (internal.Resource, error) {
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		n, err := r.policy.Normalize(name)
+		if err != nil {
+			return resource, err
+		}
+		tags = append(tags, n)
+	}
+
+	old, err := r.FindResourceByID(resource.ID)
+	if err == internal.ErrNotFound {
+		return resource, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find resource")
+		return resource, errors.New("unable to find resource")
 	}
 
-	resource.Tags = tags
-	if err := r.kvstore.PutResource(resource.ID, resource); err != nil {
-		logrus.WithError(err).Error("unable to save resource kv")
-		return errors.New("unable to save resource")
+	candidate := old
+	candidate.Tags = nil
+	for _, tag := range tags {
+		candidate.Tags = append(candidate.Tags, internal.Tag{Name: tag})
+	}
+	rt, err := r.checkResource(candidate)
+	if err != nil {
+		return resource, err
+	}
+
+	now := r.clock.Now()
+	var replaced []internal.Tag
+	wanted := make(map[string]bool)
+	for _, tag := range tags {
+		newTag := internal.Tag{Name: tag}
+		if rt != nil {
+			newTag.Color = rt.DefaultColor
+		}
+		t, err := r.CreateTag(newTag)
+		if errors.Is(err, internal.ErrInvalid) {
+			return resource, err
+		}
+		if err != nil {
+			logrus.WithError(err).Error("unable to create tag")
+			return resource, errors.New("unable to save resource")
+		}
+		if wanted[t.Name] {
+			continue
+		}
+		wanted[t.Name] = true
+		replaced = append(replaced, t)
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return resource, err
 	}
 
-	if err := r.gdb.DeleteResourceTag(resource, tag); err != nil {
-		logrus.WithError(err).Error("unable to save resource graph")
-		return errors.New("unable to save resource")
+	re, err := r.kvstore.MutateResource(resource.ID, r.cond, r.change(opUpdateResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		re := *current
+		re.Tags = nil
+		for _, t := range replaced {
+			re.Tags = append(re.Tags, r.assigned(t, current.Tags, now))
+		}
+		re.UpdatedAt, re.UpdatedBy = now, r.actor
+		if err := check(re); err != nil {
+			return nil, nil, err
+		}
+		return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+	})
+	if err != nil {
+		return resource, r.writeError(err)
 	}
+	r.syncGraph()
 
-	return nil
+	return re, nil
 }
 
 func (r *repository) initializeGraphDB() {
@@ -215,8 +1084,8 @@ func (r *repository) initializeGraphDB() {
 		logrus.WithError(err).Fatal("unable to load tags in graph db")
 	}
 	for _, tag := range tags {
-		if err != r.gdb.CreateTag(tag) {
-			logrus.WithError(err).Fatal("unable to load tags in graph db")
+		if err := r.gdb.CreateTag(tag); err != nil {
+			logrus.WithError(err).WithField("tag", tag.Name).Error("unable to load tag in graph db")
 		}
 	}
 	resources, err := r.kvstore.GetAllResources()
@@ -224,9 +1093,12 @@ func (r *repository) initializeGraphDB() {
 		logrus.WithError(err).Fatal("unable to load resources in graph db")
 	}
 	for _, resource := range resources {
-		if err != r.gdb.CreateResource(resource) {
-			logrus.WithError(err).Fatal("unable to load resources in graph db")
+		if err := r.gdb.CreateResource(resource); err != nil {
+			logrus.WithError(err).WithField("resource", resource.ID).Error("unable to load resource in graph db")
 		}
 	}
-	logrus.Info("initializing graph database complete")
+	logrus.WithFields(logrus.Fields{
+		"tags":      len(tags),
+		"resources": len(resources),
+	}).Info("initializing graph database complete")
 }
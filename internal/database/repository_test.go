@@ -0,0 +1,215 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx/fxtest"
+)
+
+func init() {
+	logrus.SetOutput(ioutil.Discard)
+}
+
+// testClock is a clock tests move by hand
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+type testEnv struct {
+	repo   *repository
+	kv     *boltkv
+	clock  *testClock
+	config internal.Configuration
+	// stop closes the repository before the end of the test, such as to open it again
+	stop func()
+}
+
+// newTestRepository opens a repository on a fresh database in a temporary directory, closed with the test
+func newTestRepository(t *testing.T, configure ...func(config *internal.Configuration)) testEnv {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	config := internal.Configuration{
+		DatabaseFile:   filepath.Join(dir, "db.bolt"),
+		GraphPath:      filepath.Join(dir, "db.bolt.graph"),
+		TagPolicy:      internal.DefaultTagPolicy(),
+		TrashRetention: 30 * 24 * time.Hour,
+		IdempotencyTTL: time.Hour,
+	}
+	for _, fn := range configure {
+		fn(&config)
+	}
+	return openTestRepository(t, config, &testClock{now: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)})
+}
+
+// openTestRepository opens a repository on the database of config
+func openTestRepository(t *testing.T, config internal.Configuration, clock *testClock) testEnv {
+	t.Helper()
+	lc := fxtest.NewLifecycle(t)
+	kv := newBoltConnection(lc, config)
+	g := NewGraphDatabase(lc, config)
+	repo := NewRepository(lc, config, clock, kv, g).(*repository)
+	lc.RequireStart()
+	stopped := false
+	stop := func() {
+		if !stopped {
+			stopped = true
+			lc.RequireStop()
+		}
+	}
+	t.Cleanup(stop)
+	return testEnv{repo: repo, kv: kv, clock: clock, config: config, stop: stop}
+}
+
+// reopen closes the repository and opens it again on the same files
+func (env testEnv) reopen(t *testing.T) testEnv {
+	t.Helper()
+	env.stop()
+	return openTestRepository(t, env.config, env.clock)
+}
+
+func mustCreateResource(t *testing.T, repo internal.ResourceFactory, id string, tags ...string) internal.Resource {
+	t.Helper()
+	resource := internal.Resource{ID: id, Name: id, Type: "note"}
+	for _, tag := range tags {
+		resource.Tags = append(resource.Tags, internal.Tag{Name: tag})
+	}
+	created, err := repo.CreateResource(resource)
+	if err != nil {
+		t.Fatalf("create resource %s: %v", id, err)
+	}
+	return created
+}
+
+func TestReplaceResourceTags(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "old")
+
+	names := []string{" Go ", "CLI"}
+	res, err := env.repo.ReplaceResourceTags(internal.Resource{ID: "r1"}, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tags) != 2 || res.Tags[0].Name != "go" || res.Tags[1].Name != "cli" {
+		t.Errorf("resource tags %v, want [go cli]", res.Tags)
+	}
+	if names[0] != " Go " || names[1] != "CLI" {
+		t.Errorf("names given were rewritten to %q", names)
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "golang")
+
+	tag, err := env.repo.RenameTag("golang", "go")
+	if err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if tag.Name != "go" {
+		t.Errorf("renamed to %q, want go", tag.Name)
+	}
+	res, err := env.repo.FindResourceByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tags) != 1 || res.Tags[0].Name != "go" {
+		t.Errorf("resource tags %v, want [go]", res.Tags)
+	}
+	if _, err := env.repo.FindTagByName("golang"); err != internal.ErrNotFound {
+		t.Errorf("old tag lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestRenameTagConflict(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "golang")
+	mustCreateResource(t, env.repo, "r2", "go")
+
+	if _, err := env.repo.RenameTag("golang", "go"); err != internal.ErrConflict {
+		t.Fatalf("rename onto an existing tag returned %v, want ErrConflict", err)
+	}
+}
+
+func TestMergeTag(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "golang")
+	mustCreateResource(t, env.repo, "r2", "go")
+
+	env.clock.Advance(time.Hour)
+	merged, err := env.repo.MergeTag("golang", "go")
+	if err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	stored, err := env.repo.FindTagByName("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []internal.Tag{merged, stored} {
+		if !tag.UpdatedAt.Equal(env.clock.Now()) {
+			t.Errorf("merged tag updated at %v, want %v", tag.UpdatedAt, env.clock.Now())
+		}
+	}
+	res, err := env.repo.FindResourceByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tags) != 1 || res.Tags[0].Name != "go" {
+		t.Errorf("resource tags %v, want [go]", res.Tags)
+	}
+	if _, err := env.repo.FindTagByName("golang"); err != internal.ErrNotFound {
+		t.Errorf("merged tag lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+// TestRenameTagConflictInTransaction covers a tag created between the lookups of the repository and the
+// write, the kv store has to refuse the rename itself
+func TestRenameTagConflictInTransaction(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "golang")
+	if _, err := env.repo.CreateTag(internal.Tag{Name: "go", Color: "#00ADD8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := env.repo.FindTagByName("golang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := old
+	renamed.Name = "go"
+	err = env.kv.RenameTag(old.Name, &renamed, internal.Precondition{}, Change{Op: historyRenameTag}, replaceTagOp(old, &renamed))
+	if err != internal.ErrConflict {
+		t.Fatalf("kv rename onto an existing tag returned %v, want ErrConflict", err)
+	}
+	existing, err := env.repo.FindTagByName("go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.Color != "#00ADD8" {
+		t.Errorf("existing tag was overwritten, color %s", existing.Color)
+	}
+	res, err := env.repo.FindResourceByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tags[0].Name != "golang" {
+		t.Errorf("resource was retagged to %q by the refused rename", res.Tags[0].Name)
+	}
+}
@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// latestBackup names the most recent backup when restoring
+	latestBackup = "latest"
+	// preRestoreSuffix is appended to the database file to keep the database replaced by a restore
+	preRestoreSuffix = ".pre-restore"
+	// maxCheckErrors bounds the problems reported for a backup failing verification
+	maxCheckErrors = 10
+)
+
+// openDatabase opens the database file swapped in by a restore
+var openDatabase = func(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, nil)
+}
+
+func (r *repository) FindBackups(ctx context.Context) ([]internal.Backup, error) {
+	return r.kvstore.ListBackups(ctx)
+}
+
+// RestoreBackup swaps a verified backup in for the database, then brings the graph and the state derived
+// from the records in line with it
+func (r *repository) RestoreBackup(ctx context.Context, name string) (internal.RestoreReport, error) {
+	report, err := r.kvstore.RestoreBackup(ctx, name)
+	if err != nil {
+		return report, err
+	}
+	// graph ops pending in the backup may already be in the graph, reconciling diffs it in full
+	if err := r.outbox.discard(); err != nil {
+		logrus.WithError(err).Error("unable to discard pending graph ops")
+	}
+	if reconciled, err := r.Reconcile(true); err != nil {
+		logrus.WithError(err).Error("unable to reconcile graph db")
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"missing": len(reconciled.Missing),
+			"extra":   len(reconciled.Extra),
+		}).Info("graph database reconciled")
+	}
+	r.failInterruptedJobs()
+	return report, nil
+}
+
+func (b *boltkv) ListBackups(ctx context.Context) ([]internal.Backup, error) {
+	if b.target == nil {
+		return nil, internal.ErrBackupDisabled
+	}
+	return b.target.List(ctx, b.backupPrefix+"-")
+}
+
+// RestoreBackup verifies a backup and swaps it in for the open database. Transactions in progress finish
+// first and the ones started meanwhile wait for the restored database.
+func (b *boltkv) RestoreBackup(ctx context.Context, name string) (internal.RestoreReport, error) {
+	if b.target == nil {
+		return internal.RestoreReport{}, internal.ErrBackupDisabled
+	}
+	name, err := resolveBackup(ctx, b.target, b.backupPrefix, name)
+	if err != nil {
+		return internal.RestoreReport{}, err
+	}
+	tmp, report, err := fetchBackup(ctx, b.target, name, b.path)
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(tmp)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.conn.Close(); err != nil {
+		logrus.WithError(err).Error("unable to close database")
+	}
+	swapErr := swapDatabase(tmp, b.path)
+	// the previous database is reopened when the swap failed
+	conn, err := openDatabase(b.path)
+	if err != nil && swapErr == nil {
+		logrus.WithError(err).Error("unable to open restored database, putting the previous one back")
+		swapErr = fmt.Errorf("unable to open restored database: %v", err)
+		if err := os.Rename(b.path+preRestoreSuffix, b.path); err != nil {
+			return report, fmt.Errorf("%v, unable to put the previous database back: %v", swapErr, err)
+		}
+		conn, err = openDatabase(b.path)
+	}
+	if err != nil {
+		// transactions fail on the closed database until the app is restarted
+		logrus.WithError(err).Error("unable to reopen database")
+		return report, fmt.Errorf("unable to reopen database: %v", err)
+	}
+	b.conn = conn
+	if swapErr != nil {
+		return report, swapErr
+	}
+	if err := conn.Update(createBuckets); err != nil {
+		logrus.WithError(err).Error("unable to create buckets")
+		return report, err
+	}
+	report.RestoredAt = b.clock.Now()
+	logrus.WithField("name", name).Info("backup restored")
+	return report, nil
+}
+
+// RestoreDatabase verifies a backup and swaps it in for the database file at path, which must not be open.
+// The graph database is reconciled with the restored records when the server next starts.
+func RestoreDatabase(ctx context.Context, clock internal.Clock, target internal.BackupTarget, prefix string, name string, path string) (internal.RestoreReport, error) {
+	name, err := resolveBackup(ctx, target, prefix, name)
+	if err != nil {
+		return internal.RestoreReport{}, err
+	}
+	tmp, report, err := fetchBackup(ctx, target, name, path)
+	if err != nil {
+		return report, err
+	}
+	defer os.Remove(tmp)
+
+	// holding the lock of the current database makes sure no server has it open while it is replaced
+	if _, err := os.Stat(path); err == nil {
+		current, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return report, fmt.Errorf("unable to lock database %s, is the server running: %v", path, err)
+		}
+		defer current.Close()
+	}
+	if err := flagReconcile(tmp); err != nil {
+		return report, err
+	}
+	if err := swapDatabase(tmp, path); err != nil {
+		return report, err
+	}
+	report.RestoredAt = clock.Now()
+	return report, nil
+}
+
+// flagReconcile marks a database so the graph is reconciled with it when the server next starts
+func flagReconcile(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(reconcileGraphKey), []byte("true"))
+	})
+}
+
+// resolveBackup checks a backup exists, an empty name or latest resolves to the most recent one
+func resolveBackup(ctx context.Context, target internal.BackupTarget, prefix string, name string) (string, error) {
+	if name != "" && name != latestBackup {
+		return name, nil
+	}
+	backups, err := target.List(ctx, prefix+"-")
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", internal.ErrNotFound
+	}
+	return backups[len(backups)-1].Name, nil
+}
+
+// fetchBackup copies a backup to a temporary file next to the database and verifies it, the file is
+// removed when it fails verification
+func fetchBackup(ctx context.Context, target internal.BackupTarget, name string, path string) (string, internal.RestoreReport, error) {
+	report := internal.RestoreReport{Name: name}
+	r, err := target.Open(ctx, name)
+	if err != nil {
+		return "", report, err
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return "", report, err
+	}
+	tmp := f.Name()
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", report, fmt.Errorf("unable to download backup %s: %v", name, err)
+	}
+
+	if report.Tags, report.Resources, err = verifyDatabase(tmp); err != nil {
+		os.Remove(tmp)
+		return "", report, fmt.Errorf("%w: %s: %v", internal.ErrBackupCorrupt, name, err)
+	}
+	return tmp, report, nil
+}
+
+// verifyDatabase opens a database file with bolt, checks its pages and checks that every tag and resource
+// reads back under its key and that the tags they refer to exist. It returns the number of each.
+func verifyDatabase(path string) (tags int, resources int, err error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to open: %v", err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		var problems []string
+		report := func(format string, args ...interface{}) {
+			if len(problems) < maxCheckErrors {
+				problems = append(problems, fmt.Sprintf(format, args...))
+			}
+		}
+		for err := range tx.Check() {
+			report("%v", err)
+		}
+		if len(problems) > 0 {
+			return checkFailed(problems)
+		}
+
+		tagBkt, resourceBkt := tx.Bucket(tagBucket), tx.Bucket(resourceBucket)
+		if tagBkt == nil || resourceBkt == nil {
+			return fmt.Errorf("missing the %s or %s bucket", tagBucket, resourceBucket)
+		}
+		parents := map[string]string{}
+		err := tagBkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				// nested buckets hold no records
+				return nil
+			}
+			tags++
+			var tag internal.Tag
+			if err := json.Unmarshal(v, &tag); err != nil {
+				report("tag %s: %v", k, err)
+			} else if tag.Name != string(k) {
+				report("tag %s: stored as %q", k, tag.Name)
+			} else if tag.Parent != "" {
+				parents[tag.Name] = tag.Parent
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for name, parent := range parents {
+			if tagBkt.Get([]byte(parent)) == nil {
+				report("tag %s: parent %s missing", name, parent)
+			}
+		}
+		err = resourceBkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			resources++
+			var resource internal.Resource
+			if err := json.Unmarshal(v, &resource); err != nil {
+				report("resource %s: %v", k, err)
+				return nil
+			}
+			if resource.ID != string(k) {
+				report("resource %s: stored as %q", k, resource.ID)
+			}
+			for _, tag := range resource.Tags {
+				if tagBkt.Get([]byte(tag.Name)) == nil {
+					report("resource %s: tag %s missing", k, tag.Name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return checkFailed(problems)
+		}
+		return nil
+	})
+	return tags, resources, err
+}
+
+func checkFailed(problems []string) error {
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// swapDatabase renames a verified database file over path, keeping a link to the replaced database. The
+// swap is refused when the replaced database cannot be kept.
+func swapDatabase(tmp string, path string) error {
+	previous := path + preRestoreSuffix
+	if err := os.Remove(previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove previous pre-restore database: %v", err)
+	}
+	if err := os.Link(path, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to keep the replaced database: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to replace database: %v", err)
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
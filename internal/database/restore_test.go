@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/backup"
+)
+
+// newBackedUpRepository backs up a repository holding r1 tagged go, then adds r2 tagged rust
+func newBackedUpRepository(t *testing.T) (testEnv, internal.BackupTarget) {
+	t.Helper()
+	env := newTestRepository(t)
+	target, err := backup.NewLocalTarget(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.kv.clock, env.kv.target = env.clock, target
+	env.kv.backupPrefix = "tags"
+	mustCreateResource(t, env.repo, "r1", "go")
+	env.kv.runBackup(context.Background())
+	env.clock.Advance(time.Hour)
+	mustCreateResource(t, env.repo, "r2", "rust")
+	return env, target
+}
+
+// checkRestored checks the records and the graph hold r1 and not r2
+func checkRestored(t *testing.T, repo *repository) {
+	t.Helper()
+	if _, err := repo.FindResourceByID("r1"); err != nil {
+		t.Errorf("backed up resource lookup: %v", err)
+	}
+	if _, err := repo.FindResourceByID("r2"); err != internal.ErrNotFound {
+		t.Errorf("resource created after the backup lookup returned %v, want ErrNotFound", err)
+	}
+	if found, _, err := repo.FindAllResources(&internal.ResourceParams{Tag: "rust"}); err != nil || len(found) != 0 {
+		t.Errorf("graph lookup of a tag created after the backup: %v %v", found, err)
+	}
+	if found, _, err := repo.FindAllResources(&internal.ResourceParams{Tag: "go"}); err != nil || len(found) != 1 {
+		t.Errorf("graph lookup of a backed up tag: %v %v", found, err)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	env, _ := newBackedUpRepository(t)
+
+	report, err := env.repo.RestoreBackup(context.Background(), latestBackup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Resources != 1 || report.Tags != 1 {
+		t.Errorf("restored %d resources and %d tags, want 1 and 1", report.Resources, report.Tags)
+	}
+	if !report.RestoredAt.Equal(env.clock.Now()) {
+		t.Errorf("restored at %v, want the time of the clock %v", report.RestoredAt, env.clock.Now())
+	}
+	checkRestored(t, env.repo)
+	if _, err := os.Stat(env.config.DatabaseFile + preRestoreSuffix); err != nil {
+		t.Errorf("replaced database not kept: %v", err)
+	}
+	mustCreateResource(t, env.repo, "r3", "go")
+}
+
+func TestRestoreBackupMissing(t *testing.T) {
+	env, _ := newBackedUpRepository(t)
+	if _, err := env.repo.RestoreBackup(context.Background(), "tags-missing.bolt"); err != internal.ErrNotFound {
+		t.Errorf("restore of a missing backup returned %v, want ErrNotFound", err)
+	}
+	if _, err := env.repo.FindResourceByID("r2"); err != nil {
+		t.Errorf("failed restore lost r2: %v", err)
+	}
+}
+
+// TestRestoreBackupReopenFailure covers the restored database failing to open, the previous one is put back
+func TestRestoreBackupReopenFailure(t *testing.T) {
+	env, _ := newBackedUpRepository(t)
+	failures := 1
+	openDatabase = func(path string) (*bolt.DB, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("open failure")
+		}
+		return bolt.Open(path, 0600, nil)
+	}
+	defer func() {
+		openDatabase = func(path string) (*bolt.DB, error) {
+			return bolt.Open(path, 0600, nil)
+		}
+	}()
+
+	if _, err := env.repo.RestoreBackup(context.Background(), latestBackup); err == nil {
+		t.Fatal("restore succeeded without opening the restored database")
+	}
+	if _, err := env.repo.FindResourceByID("r2"); err != nil {
+		t.Errorf("previous database not put back: %v", err)
+	}
+	mustCreateResource(t, env.repo, "r3", "go")
+}
+
+// TestRestoreDatabase restores as the restore command does, the graph is reconciled when the repository is
+// next opened
+func TestRestoreDatabase(t *testing.T) {
+	env, target := newBackedUpRepository(t)
+	ctx := context.Background()
+
+	if _, err := RestoreDatabase(ctx, env.clock, target, "tags", latestBackup, env.config.DatabaseFile); err == nil {
+		t.Fatal("restore replaced the database while it was open")
+	}
+
+	env.stop()
+	report, err := RestoreDatabase(ctx, env.clock, target, "tags", "", env.config.DatabaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.RestoredAt.Equal(env.clock.Now()) {
+		t.Errorf("restored at %v, want the time of the clock %v", report.RestoredAt, env.clock.Now())
+	}
+	checkRestored(t, openTestRepository(t, env.config, env.clock).repo)
+}
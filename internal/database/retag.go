@@ -0,0 +1,421 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx"
+)
+
+// retagJobBucket holds the retag jobs keyed by id
+var retagJobBucket = []byte("retag_jobs")
+
+// retagJobRetention is how long finished retag jobs are kept
+const retagJobRetention = 7 * 24 * time.Hour
+
+// maxRetagErrors bounds the resource errors recorded on a job, the failed count keeps counting past it
+const maxRetagErrors = 100
+
+func (b *boltkv) GetRetagJob(id string) (internal.RetagJob, error) {
+	var job internal.RetagJob
+	err := b.view(func(tx *bolt.Tx) error {
+		res := tx.Bucket(retagJobBucket).Get([]byte(id))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		return json.Unmarshal(res, &job)
+	})
+	if err == internal.ErrNotFound {
+		return job, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to read retag job")
+		return job, errors.New("unable to read retag job")
+	}
+	return job, nil
+}
+
+func (b *boltkv) GetRetagJobs() ([]internal.RetagJob, error) {
+	jobs := []internal.RetagJob{}
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(retagJobBucket).ForEach(func(k, v []byte) error {
+			var job internal.RetagJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to read retag jobs")
+		return nil, errors.New("unable to read retag jobs")
+	}
+	return jobs, nil
+}
+
+func (b *boltkv) PutRetagJob(job internal.RetagJob) error {
+	return b.update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(job)
+		if err != nil {
+			logrus.WithError(err).Error("unable to marshall retag job")
+			return errors.New("unable to store retag job")
+		}
+		return tx.Bucket(retagJobBucket).Put([]byte(job.ID), buf)
+	})
+}
+
+// PurgeRetagJobs removes the jobs which finished before the given time
+func (b *boltkv) PurgeRetagJobs(before time.Time) (int, error) {
+	purged := 0
+	err := b.update(func(tx *bolt.Tx) error {
+		expired, err := purgeBucket(tx.Bucket(retagJobBucket), func(v []byte) (bool, error) {
+			var job internal.RetagJob
+			err := json.Unmarshal(v, &job)
+			return err == nil && job.FinishedAt != nil && job.FinishedAt.Before(before), err
+		})
+		if err != nil {
+			return err
+		}
+		purged = len(expired)
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to purge retag jobs")
+		return 0, errors.New("unable to purge retag jobs")
+	}
+	return purged, nil
+}
+
+// jobRunner runs background jobs, on stop the jobs are asked to stop and waited for
+type jobRunner struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newJobRunner(lc fx.Lifecycle) *jobRunner {
+	j := &jobRunner{stop: make(chan struct{})}
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			close(j.stop)
+			done := make(chan struct{})
+			go func() {
+				j.wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	})
+	return j
+}
+
+func (j *jobRunner) run(fn func(stop <-chan struct{})) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		fn(j.stop)
+	}()
+}
+
+// retagPlan is a retag request with its tag names resolved and the matching resources listed
+type retagPlan struct {
+	add    []string
+	remove []string
+	ids    []string
+}
+
+func (r *repository) planRetag(req internal.RetagRequest) (retagPlan, error) {
+	var plan retagPlan
+	if !req.Filter.Filtered() {
+		return plan, fmt.Errorf("%w: a filter is required", internal.ErrInvalid)
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		return plan, fmt.Errorf("%w: add or remove requires at least one tag", internal.ErrInvalid)
+	}
+	for _, name := range req.Add {
+		tag, err := r.queryTag(name)
+		if err != nil {
+			return plan, err
+		}
+		if !containsName(plan.add, tag) {
+			plan.add = append(plan.add, tag)
+		}
+	}
+	for _, name := range req.Remove {
+		tag, err := r.lookupTag(name)
+		if err != nil {
+			return plan, err
+		}
+		if containsName(plan.add, tag) {
+			return plan, fmt.Errorf("%w: tag %q is both added and removed", internal.ErrInvalid, tag)
+		}
+		if !containsName(plan.remove, tag) {
+			plan.remove = append(plan.remove, tag)
+		}
+	}
+
+	ids, err := r.filterResourceIDs(req.Filter)
+	if err != nil {
+		return plan, err
+	}
+	// the graph may list a resource more than once, the ids are sorted so jobs apply in key order
+	sort.Strings(ids)
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			plan.ids = append(plan.ids, id)
+		}
+	}
+	return plan, nil
+}
+
+// retagged returns the tag names of a resource after the plan is applied and whether they differ
+func (p retagPlan) retagged(tags []internal.Tag) ([]string, bool) {
+	var names []string
+	for _, t := range tags {
+		if !containsName(p.remove, t.Name) {
+			names = append(names, t.Name)
+		}
+	}
+	changed := len(names) != len(tags)
+	for _, name := range p.add {
+		if !containsName(names, name) {
+			names = append(names, name)
+			changed = true
+		}
+	}
+	return names, changed
+}
+
+func (r *repository) PreviewRetag(req internal.RetagRequest) (internal.RetagPreview, error) {
+	plan, err := r.planRetag(req)
+	if err != nil {
+		return internal.RetagPreview{}, err
+	}
+	preview := internal.RetagPreview{Matched: len(plan.ids), Add: plan.add, Remove: plan.remove, IDs: []string{}}
+	for _, id := range plan.ids {
+		re, err := r.kvstore.GetResource(id)
+		if err == internal.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return preview, err
+		}
+		if _, changed := plan.retagged(re.Tags); changed {
+			preview.IDs = append(preview.IDs, id)
+		}
+	}
+	return preview, nil
+}
+
+// StartRetag runs jobs matching up to bulkChunkSize resources before returning, larger ones are handed to
+// the job runner
+func (r *repository) StartRetag(req internal.RetagRequest) (internal.RetagJob, error) {
+	plan, err := r.planRetag(req)
+	if err != nil {
+		return internal.RetagJob{}, err
+	}
+	job := internal.RetagJob{
+		ID:        r.newID(),
+		Status:    internal.JobRunning,
+		Request:   req,
+		Matched:   len(plan.ids),
+		CreatedAt: r.clock.Now(),
+		CreatedBy: r.actor,
+	}
+	if err := r.kvstore.PutRetagJob(job); err != nil {
+		return job, err
+	}
+	if len(plan.ids) <= bulkChunkSize {
+		return r.runRetag(job, plan, nil), nil
+	}
+	r.jobs.run(func(stop <-chan struct{}) {
+		r.runRetag(job, plan, stop)
+	})
+	return job, nil
+}
+
+// runRetag applies the plan in transactions of bulkChunkSize resources, recording the progress of the job
+// after each
+func (r *repository) runRetag(job internal.RetagJob, plan retagPlan, stop <-chan struct{}) internal.RetagJob {
+	logger := logrus.WithField("job", job.ID)
+	logger.WithField("matched", job.Matched).Info("retag started")
+	finish := func(status string, msg string) internal.RetagJob {
+		now := r.clock.Now()
+		job.Status, job.Error, job.FinishedAt = status, msg, &now
+		if err := r.kvstore.PutRetagJob(job); err != nil {
+			logger.WithError(err).Error("unable to record retag job")
+		}
+		logger.WithFields(logrus.Fields{
+			"status":  job.Status,
+			"changed": job.Changed,
+			"failed":  job.Failed,
+		}).Info("retag finished")
+		return job
+	}
+
+	types, err := r.kvstore.GetAllTypes()
+	if err != nil {
+		return finish(internal.JobFailed, err.Error())
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return finish(internal.JobFailed, err.Error())
+	}
+
+	for start := 0; start < len(plan.ids); start += bulkChunkSize {
+		select {
+		case <-stop:
+			return finish(internal.JobFailed, "interrupted by shutdown")
+		default:
+		}
+		end := start + bulkChunkSize
+		if end > len(plan.ids) {
+			end = len(plan.ids)
+		}
+
+		changed := 0
+		var failed []internal.RetagError
+		now := r.clock.Now()
+		err := r.kvstore.Batch(func(batch KVBatch) error {
+			changed, failed = 0, nil
+			for _, id := range plan.ids[start:end] {
+				_, err := r.applyRetag(batch, id, plan, types, check, now)
+				switch {
+				case err == nil:
+					changed++
+				case err == errUnchanged, err == internal.ErrNotFound:
+				case callerError(err):
+					failed = append(failed, internal.RetagError{ID: id, Error: err.Error()})
+				default:
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return finish(internal.JobFailed, r.writeError(err).Error())
+		}
+		r.syncGraph()
+
+		job.Processed += end - start
+		job.Changed += changed
+		job.Failed += len(failed)
+		for _, f := range failed {
+			if len(job.Errors) < maxRetagErrors {
+				job.Errors = append(job.Errors, f)
+			}
+		}
+		if err := r.kvstore.PutRetagJob(job); err != nil {
+			logger.WithError(err).Error("unable to record retag job")
+		}
+	}
+	return finish(internal.JobCompleted, "")
+}
+
+// applyRetag changes the tags of a resource within the batch, tags are only created once the resource is
+// known to change and to satisfy its type
+func (r *repository) applyRetag(batch KVBatch, id string, plan retagPlan, types []internal.ResourceType, check func(internal.Resource) error, now time.Time) (internal.Resource, error) {
+	return batch.MutateResource(id, internal.Precondition{}, r.change(historyRetag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current == nil {
+			return nil, nil, internal.ErrNotFound
+		}
+		names, changed := plan.retagged(current.Tags)
+		if !changed {
+			return nil, nil, errUnchanged
+		}
+		re := *current
+		re.Tags = make([]internal.Tag, 0, len(names))
+		for _, name := range names {
+			re.Tags = append(re.Tags, internal.Tag{Name: name})
+		}
+		if err := check(re); err != nil {
+			return nil, nil, err
+		}
+
+		var color internal.Color
+		for _, t := range types {
+			if t.Name == re.Type {
+				color = t.DefaultColor
+			}
+		}
+		re.Tags = re.Tags[:0]
+		for _, name := range names {
+			if i := tagIndex(current.Tags, name); i >= 0 {
+				re.Tags = append(re.Tags, current.Tags[i])
+				continue
+			}
+			tag, err := r.ensureTag(batch, internal.Tag{Name: name, Color: color})
+			if err != nil {
+				return nil, nil, err
+			}
+			re.Tags = append(re.Tags, r.assigned(tag, nil, now))
+		}
+		re.UpdatedAt, re.UpdatedBy = now, r.actor
+		return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+	})
+}
+
+func (r *repository) FindRetagJob(id string) (internal.RetagJob, error) {
+	return r.kvstore.GetRetagJob(id)
+}
+
+// FindRetagJobs lists the jobs, the most recent first
+func (r *repository) FindRetagJobs() ([]internal.RetagJob, error) {
+	jobs, err := r.kvstore.GetRetagJobs()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// failInterruptedJobs marks the jobs left running by a previous process as failed
+func (r *repository) failInterruptedJobs() {
+	jobs, err := r.kvstore.GetRetagJobs()
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		if job.Status != internal.JobRunning {
+			continue
+		}
+		now := r.clock.Now()
+		job.Status, job.Error, job.FinishedAt = internal.JobFailed, "interrupted", &now
+		if err := r.kvstore.PutRetagJob(job); err != nil {
+			logrus.WithError(err).WithField("job", job.ID).Error("unable to record retag job")
+		}
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tagIndex(tags []internal.Tag, name string) int {
+	for i, t := range tags {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
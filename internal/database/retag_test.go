@@ -0,0 +1,116 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestPreviewRetagMatchesFilter(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	mustCreateResource(t, env.repo, "r2", "go", "cli")
+	mustCreateResource(t, env.repo, "r3", "rust")
+
+	preview, err := env.repo.PreviewRetag(internal.RetagRequest{
+		Filter: internal.ResourceParams{Tag: "go"},
+		Add:    []string{"cli"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Matched != 2 {
+		t.Errorf("matched %d resources, want 2", preview.Matched)
+	}
+	if !reflect.DeepEqual(preview.IDs, []string{"r1"}) {
+		t.Errorf("changed ids %v, want [r1]", preview.IDs)
+	}
+}
+
+func TestPreviewRetagTaggedSince(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "old", "go")
+	env.clock.Advance(48 * time.Hour)
+	mustCreateResource(t, env.repo, "new", "go")
+
+	preview, err := env.repo.PreviewRetag(internal.RetagRequest{
+		Filter: internal.ResourceParams{Tag: "go", TaggedSince: "24h"},
+		Remove: []string{"go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(preview.IDs, []string{"new"}) {
+		t.Errorf("changed ids %v, want [new]", preview.IDs)
+	}
+}
+
+func TestPreviewRetagRequiresFilter(t *testing.T) {
+	env := newTestRepository(t)
+	_, err := env.repo.PreviewRetag(internal.RetagRequest{Add: []string{"go"}})
+	if err == nil || !callerError(err) {
+		t.Errorf("unfiltered retag returned %v, want an invalid request", err)
+	}
+}
+
+func TestStartRetag(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	mustCreateResource(t, env.repo, "r2", "go")
+
+	job, err := env.repo.StartRetag(internal.RetagRequest{
+		Filter: internal.ResourceParams{Tag: "go"},
+		Add:    []string{"cli"},
+		Remove: []string{"go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != internal.JobCompleted || job.Changed != 2 {
+		t.Errorf("job %s changed %d resources, want completed with 2", job.Status, job.Changed)
+	}
+	res, err := env.repo.FindResourceByID("r2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tags) != 1 || res.Tags[0].Name != "cli" {
+		t.Errorf("resource tags %v, want [cli]", res.Tags)
+	}
+}
+
+func TestPurgeRetagJobs(t *testing.T) {
+	env := newTestRepository(t)
+	finished := env.clock.Now()
+	jobs := []internal.RetagJob{
+		{ID: "old", Status: internal.JobCompleted, FinishedAt: &finished},
+		{ID: "running", Status: internal.JobRunning},
+	}
+	for _, job := range jobs {
+		if err := env.kv.PutRetagJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.clock.Advance(retagJobRetention + time.Hour)
+	recent := env.clock.Now()
+	if err := env.kv.PutRetagJob(internal.RetagJob{ID: "recent", Status: internal.JobFailed, FinishedAt: &recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := env.kv.PurgeRetagJobs(env.clock.Now().Add(-retagJobRetention))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d jobs, want 1", n)
+	}
+	if _, err := env.kv.GetRetagJob("old"); err != internal.ErrNotFound {
+		t.Errorf("purged job lookup returned %v, want ErrNotFound", err)
+	}
+	for _, id := range []string{"running", "recent"} {
+		if _, err := env.kv.GetRetagJob(id); err != nil {
+			t.Errorf("job %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,905 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cayleygraph/quad"
+	"github.com/cayleygraph/quad/nquads"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// kinds of the JSON Lines records
+const (
+	recordTag      = "tag"
+	recordAlias    = "alias"
+	recordResource = "resource"
+)
+
+// maxImportLine bounds the length of a JSON Lines record
+const maxImportLine = 16 * 1024 * 1024
+
+// csvHeader names the CSV columns, rows without a resource id define a tag
+var csvHeader = []string{"resource_id", "resource_name", "resource_type", "attributes", "tag", "tag_color", "tag_description", "tagged_at", "created_at", "updated_at"}
+
+// exportRecord is a line of the JSON Lines format, only the field of its kind is set
+type exportRecord struct {
+	Kind     string             `json:"kind"`
+	Tag      *internal.Tag      `json:"tag,omitempty"`
+	Alias    *exportAlias       `json:"alias,omitempty"`
+	Resource *internal.Resource `json:"resource,omitempty"`
+}
+
+type exportAlias struct {
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+}
+
+func validFormat(format string) error {
+	switch format {
+	case internal.FormatJSONL, internal.FormatCSV, internal.FormatNQuads:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown format %q", internal.ErrInvalid, format)
+}
+
+// NewTransfer returns the import and export of a repository opened on the kv store alone, for commands
+// run while the server is stopped. The graph ops of an import stay in the outbox until the server next
+// starts and applies them.
+func NewTransfer(config internal.Configuration, clock internal.Clock, kv KVStore, actor string) (internal.DataTransfer, error) {
+	newID, err := internal.NewIDGenerator(config.IDFormat)
+	if err != nil {
+		return nil, err
+	}
+	return &repository{
+		kvstore: kv,
+		policy:  config.TagPolicy,
+		newID:   newID,
+		clock:   clock,
+		actor:   actor,
+	}, nil
+}
+
+// Export writes every tag, followed by the aliases when the format has them, and every resource from a
+// snapshot of the store. The snapshot is spooled to a temporary file which is copied to w once the read
+// transaction is released, so a slow reader does not hold it.
+func (r *repository) Export(w io.Writer, format string) error {
+	if err := validFormat(format); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "tags-export-*")
+	if err != nil {
+		logrus.WithError(err).Error("unable to create export file")
+		return errors.New("unable to write export")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	spool := bufio.NewWriter(f)
+	err = r.kvstore.Snapshot(func(snap KVSnapshot) error {
+		var err error
+		switch format {
+		case internal.FormatCSV:
+			err = exportCSV(spool, snap)
+		case internal.FormatNQuads:
+			err = exportNQuads(spool, snap)
+		default:
+			err = exportJSONL(spool, snap)
+		}
+		if err != nil {
+			return err
+		}
+		return spool.Flush()
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to write export")
+		return errors.New("unable to write export")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		logrus.WithError(err).Error("unable to read export file")
+		return errors.New("unable to write export")
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		logrus.WithError(err).Error("unable to send export")
+		return errors.New("unable to write export")
+	}
+	return nil
+}
+
+func exportJSONL(w io.Writer, snap KVSnapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	err := snap.ForEachTag(func(tag internal.Tag) error {
+		return enc.Encode(exportRecord{Kind: recordTag, Tag: &tag})
+	})
+	if err != nil {
+		return err
+	}
+	err = snap.ForEachAlias(func(alias string, tag string) error {
+		return enc.Encode(exportRecord{Kind: recordAlias, Alias: &exportAlias{Name: alias, Tag: tag}})
+	})
+	if err != nil {
+		return err
+	}
+	return snap.ForEachResource(func(re internal.Resource) error {
+		return enc.Encode(exportRecord{Kind: recordResource, Resource: &re})
+	})
+}
+
+func exportCSV(w io.Writer, snap KVSnapshot) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err := snap.ForEachTag(func(t internal.Tag) error {
+		return cw.Write([]string{"", "", "", "", t.Name, string(t.Color), t.Description, "", formatTime(t.CreatedAt), formatTime(t.UpdatedAt)})
+	})
+	if err != nil {
+		return err
+	}
+	err = snap.ForEachResource(func(re internal.Resource) error {
+		attributes := ""
+		if len(re.Attributes) > 0 {
+			buf, err := json.Marshal(re.Attributes)
+			if err != nil {
+				return err
+			}
+			attributes = string(buf)
+		}
+		tags := re.Tags
+		if len(tags) == 0 {
+			// a resource without tags still needs a row
+			tags = []internal.Tag{{}}
+		}
+		for _, t := range tags {
+			var taggedAt time.Time
+			if t.TaggedAt != nil {
+				taggedAt = *t.TaggedAt
+			}
+			row := []string{re.ID, re.Name, re.Type, attributes, t.Name, string(t.Color), t.Description, formatTime(taggedAt), formatTime(re.CreatedAt), formatTime(re.UpdatedAt)}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportNQuads writes the quads the graph database holds for the tags and resources
+func exportNQuads(w io.Writer, snap KVSnapshot) error {
+	qw := nquads.NewWriter(w)
+	err := snap.ForEachTag(func(t internal.Tag) error {
+		_, err := qw.WriteQuads(tagQuads(t))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = snap.ForEachResource(func(re internal.Resource) error {
+		_, err := qw.WriteQuads(resourceQuads(re))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// importSet is the content of an import as read, before it is checked against the store
+type importSet struct {
+	tags []importTag
+	// aliases maps each alias to its tag, it is nil when the format does not carry aliases
+	aliases   map[string]string
+	resources []importResource
+	errors    []internal.ImportError
+}
+
+type importTag struct {
+	line int
+	tag  internal.Tag
+	// implicit tags are only referenced by resources or as a parent, an existing tag is left as is
+	implicit bool
+	// colorOnly tags come from a format without descriptions, an existing description is kept
+	colorOnly bool
+}
+
+type importResource struct {
+	line     int
+	resource internal.Resource
+}
+
+func (s *importSet) fail(line int, id string, err error) {
+	s.errors = append(s.errors, internal.ImportError{Line: line, ID: id, Error: err.Error()})
+}
+
+func decodeJSONL(in io.Reader) *importSet {
+	set := &importSet{aliases: make(map[string]string)}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			set.fail(line, "", err)
+			continue
+		}
+		switch {
+		case record.Kind == recordTag && record.Tag != nil:
+			set.tags = append(set.tags, importTag{line: line, tag: *record.Tag})
+		case record.Kind == recordAlias && record.Alias != nil:
+			set.aliases[record.Alias.Name] = record.Alias.Tag
+		case record.Kind == recordResource && record.Resource != nil:
+			set.resources = append(set.resources, importResource{line: line, resource: *record.Resource})
+		default:
+			set.fail(line, "", fmt.Errorf("unknown record kind %q", record.Kind))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		set.fail(line+1, "", err)
+	}
+	return set
+}
+
+func decodeCSV(in io.Reader) *importSet {
+	set := &importSet{}
+	cr := csv.NewReader(in)
+	header, err := cr.Read()
+	if err != nil {
+		set.fail(1, "", fmt.Errorf("unable to read header: %v", err))
+		return set
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"resource_id", "tag"} {
+		if _, ok := columns[name]; !ok {
+			set.fail(1, "", fmt.Errorf("missing column %q", name))
+			return set
+		}
+	}
+
+	resources := make(map[string]int)
+	defined := make(map[string]bool)
+	var rowTags []importTag
+	line := 1
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			set.fail(line, "", err)
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			break
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		created, err := parseTime(field("created_at"))
+		if err != nil {
+			set.fail(line, field("resource_id"), err)
+			continue
+		}
+		updated, err := parseTime(field("updated_at"))
+		if err != nil {
+			set.fail(line, field("resource_id"), err)
+			continue
+		}
+		tag := internal.Tag{Name: field("tag"), Color: internal.Color(field("tag_color")), Description: field("tag_description")}
+
+		id := field("resource_id")
+		if id == "" {
+			if tag.Name == "" {
+				set.fail(line, "", errors.New("row has neither a resource nor a tag"))
+				continue
+			}
+			tag.CreatedAt, tag.UpdatedAt = created, updated
+			set.tags = append(set.tags, importTag{line: line, tag: tag})
+			defined[tag.Name] = true
+			continue
+		}
+
+		i, ok := resources[id]
+		if !ok {
+			re := internal.Resource{ID: id, Name: field("resource_name"), Type: field("resource_type"), CreatedAt: created, UpdatedAt: updated}
+			if attributes := field("attributes"); attributes != "" {
+				if err := json.Unmarshal([]byte(attributes), &re.Attributes); err != nil {
+					set.fail(line, id, fmt.Errorf("invalid attributes: %v", err))
+					continue
+				}
+			}
+			i = len(set.resources)
+			resources[id] = i
+			set.resources = append(set.resources, importResource{line: line, resource: re})
+		}
+		if tag.Name == "" {
+			continue
+		}
+		taggedAt, err := parseTime(field("tagged_at"))
+		if err != nil {
+			set.fail(line, id, err)
+			continue
+		}
+		if !taggedAt.IsZero() {
+			tag.TaggedAt = &taggedAt
+		}
+		set.resources[i].resource.Tags = append(set.resources[i].resource.Tags, internal.Tag{Name: tag.Name, TaggedAt: tag.TaggedAt})
+		if tag.Color != "" || tag.Description != "" {
+			rowTags = append(rowTags, importTag{line: line, tag: internal.Tag{Name: tag.Name, Color: tag.Color, Description: tag.Description}})
+		}
+	}
+	// the tag columns of resource rows define the tags which have no row of their own
+	for _, t := range rowTags {
+		if !defined[t.tag.Name] {
+			set.tags = append(set.tags, t)
+			defined[t.tag.Name] = true
+		}
+	}
+	return set
+}
+
+// decodeNQuads rebuilds the tags and resources from the quads written by tagQuads and resourceQuads, the
+// quads derived from others such as the reverse edges are ignored
+func decodeNQuads(in io.Reader) *importSet {
+	set := &importSet{}
+	tags := make(map[string]int)
+	resources := make(map[string]int)
+	qr := nquads.NewReader(in, false)
+	for {
+		q, err := qr.ReadQuad()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			set.fail(0, "", err)
+			break
+		}
+		kind, id, ok := splitNode(q.Subject)
+		predicate, _ := quad.NativeOf(q.Predicate).(string)
+		if !ok || predicate == "" {
+			continue
+		}
+		switch kind {
+		case "tag":
+			if predicate != "color" {
+				continue
+			}
+			_, color, _ := splitNode(q.Object)
+			if i, ok := tags[id]; ok {
+				set.tags[i].tag.Color = internal.Color(color)
+				continue
+			}
+			tags[id] = len(set.tags)
+			set.tags = append(set.tags, importTag{tag: internal.Tag{Name: id, Color: internal.Color(color)}, colorOnly: true})
+		case "resource":
+			i, ok := resources[id]
+			if !ok {
+				i = len(set.resources)
+				resources[id] = i
+				set.resources = append(set.resources, importResource{resource: internal.Resource{ID: id}})
+			}
+			re := &set.resources[i].resource
+			_, value, _ := splitNode(q.Object)
+			switch {
+			case predicate == "name":
+				re.Name = value
+			case predicate == "type":
+				re.Type = value
+			case predicate == "tag":
+				re.Tags = append(re.Tags, internal.Tag{Name: value})
+			case strings.HasPrefix(predicate, "attr:"):
+				if re.Attributes == nil {
+					re.Attributes = internal.Attributes{}
+				}
+				re.Attributes[keyUnescaper.Replace(strings.TrimPrefix(predicate, "attr:"))] = attrNative(q.Object)
+			}
+		}
+	}
+	return set
+}
+
+// splitNode separates a node key such as tag:env into its kind and unescaped id
+func splitNode(v quad.Value) (string, string, bool) {
+	s, ok := quad.NativeOf(v).(string)
+	if !ok {
+		return "", "", false
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], keyUnescaper.Replace(parts[1]), true
+}
+
+// attrNative converts a graph attribute value back to the value stored on the resource
+func attrNative(v quad.Value) interface{} {
+	switch n := quad.NativeOf(v).(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case bool:
+		return n
+	case time.Time:
+		return n.UTC().Format(time.RFC3339Nano)
+	case string:
+		return n
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// importPlan is an import checked against the store, listing the writes it makes
+type importPlan struct {
+	report internal.ImportReport
+	// create and update hold the tags to write, parents before children
+	create []internal.Tag
+	update []internal.Tag
+	// aliases maps the aliases to add or move to their tag
+	aliases   map[string]string
+	resources []internal.Resource
+	// the records to delete in replace mode
+	deleteAliases   map[string]string
+	deleteTags      []string
+	deleteResources []string
+}
+
+// Import checks every record and then writes them all in a single transaction, nothing is written when
+// any of them is invalid or a write fails. A replace which is not a dry run has to be confirmed and is
+// refused when the import holds no tag or resource, as it would delete everything.
+func (r *repository) Import(in io.Reader, format string, opts internal.ImportOptions) (internal.ImportReport, error) {
+	report := internal.ImportReport{Format: format, Mode: opts.Mode, DryRun: opts.DryRun}
+	if err := validFormat(format); err != nil {
+		return report, err
+	}
+	if opts.Mode != internal.ImportMerge && opts.Mode != internal.ImportReplace {
+		return report, fmt.Errorf("%w: unknown import mode %q", internal.ErrInvalid, opts.Mode)
+	}
+	if opts.Mode == internal.ImportReplace && !opts.DryRun && !opts.Confirm {
+		return report, fmt.Errorf("%w: replace deletes the records missing from the import, it has to be confirmed", internal.ErrInvalid)
+	}
+
+	var set *importSet
+	switch format {
+	case internal.FormatCSV:
+		set = decodeCSV(in)
+	case internal.FormatNQuads:
+		set = decodeNQuads(in)
+	default:
+		set = decodeJSONL(in)
+	}
+	if opts.Mode == internal.ImportReplace && len(set.errors) == 0 && len(set.tags) == 0 && len(set.resources) == 0 {
+		return report, fmt.Errorf("%w: replace requires an import with at least one tag or resource", internal.ErrInvalid)
+	}
+	plan, err := r.planImport(set, opts.Mode)
+	if err != nil {
+		return report, err
+	}
+	plan.report.Format, plan.report.Mode, plan.report.DryRun = format, opts.Mode, opts.DryRun
+	if opts.DryRun || len(plan.report.Errors) > 0 {
+		return plan.report, nil
+	}
+	return plan.report, r.applyImport(plan)
+}
+
+func (r *repository) planImport(set *importSet, mode string) (*importPlan, error) {
+	plan := &importPlan{aliases: make(map[string]string), deleteAliases: make(map[string]string)}
+	plan.report.Errors = set.errors
+	fail := func(line int, id string, err error) {
+		plan.report.Errors = append(plan.report.Errors, internal.ImportError{Line: line, ID: id, Error: err.Error()})
+	}
+
+	types, err := r.kvstore.GetAllTypes()
+	if err != nil {
+		return nil, err
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	// tags defined by the import, a later definition replaces an earlier one
+	tags := make(map[string]importTag)
+	for _, t := range set.tags {
+		name, err := r.queryTag(t.tag.Name)
+		if err != nil {
+			fail(t.line, t.tag.Name, err)
+			continue
+		}
+		if t.tag.Color != "" && !t.tag.Color.Valid() {
+			fail(t.line, t.tag.Name, &internal.ValidationError{Rule: "color", Value: string(t.tag.Color), Msg: "color must be of the form #RRGGBB"})
+			continue
+		}
+		t.tag.Name = name
+		tags[name] = t
+	}
+	// tags only referenced by a resource or as a parent are created with the defaults
+	implicit := func(name string, color internal.Color) {
+		for ; name != ""; name, color = internal.ParentTagName(name), "" {
+			if _, ok := tags[name]; !ok {
+				tags[name] = importTag{tag: internal.Tag{Name: name, Color: color}, implicit: true}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, ir := range set.resources {
+		re := ir.resource
+		if re.ID == "" || re.Name == "" || re.Type == "" {
+			fail(ir.line, re.ID, fmt.Errorf("%w: resource requires an id, name and type", internal.ErrInvalid))
+			continue
+		}
+		if seen[re.ID] {
+			fail(ir.line, re.ID, fmt.Errorf("%w: duplicate resource", internal.ErrInvalid))
+			continue
+		}
+		seen[re.ID] = true
+		if err := re.Attributes.Validate(); err != nil {
+			fail(ir.line, re.ID, err)
+			continue
+		}
+		var color internal.Color
+		for _, t := range types {
+			if t.Name == re.Type {
+				color = t.DefaultColor
+			}
+		}
+		var assigned []internal.Tag
+		valid := true
+		for _, t := range re.Tags {
+			name, err := r.queryTag(t.Name)
+			if err != nil {
+				fail(ir.line, re.ID, err)
+				valid = false
+				break
+			}
+			if !hasTag(assigned, name) {
+				assigned = append(assigned, internal.Tag{Name: name, TaggedAt: t.TaggedAt, TaggedBy: t.TaggedBy})
+			}
+		}
+		if !valid {
+			continue
+		}
+		re.Tags = assigned
+		if err := check(re); err != nil {
+			fail(ir.line, re.ID, err)
+			continue
+		}
+		for _, t := range re.Tags {
+			implicit(t.Name, color)
+		}
+		plan.resources = append(plan.resources, re)
+	}
+	for name := range tags {
+		implicit(internal.ParentTagName(name), "")
+	}
+
+	keepAliases := make(map[string]bool)
+	for alias, name := range set.aliases {
+		a, err := r.policy.Normalize(alias)
+		if err != nil {
+			fail(0, alias, err)
+			continue
+		}
+		name, err = r.queryTag(name)
+		if err != nil {
+			fail(0, alias, err)
+			continue
+		}
+		if _, ok := tags[a]; ok || a == name {
+			fail(0, alias, fmt.Errorf("%w: alias %q is also a tag", internal.ErrInvalid, a))
+			continue
+		}
+		if _, ok := tags[name]; !ok {
+			if _, err := r.kvstore.GetTag(name); err != nil {
+				fail(0, alias, fmt.Errorf("alias of unknown tag %q", name))
+				continue
+			}
+		}
+		plan.aliases[a] = name
+		keepAliases[a] = true
+	}
+	if len(plan.report.Errors) > 0 {
+		sort.SliceStable(plan.report.Errors, func(i, j int) bool {
+			return plan.report.Errors[i].Line < plan.report.Errors[j].Line
+		})
+		return plan, nil
+	}
+
+	// compare with the store
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := tags[name]
+		existing, err := r.kvstore.GetTag(name)
+		switch {
+		case err == internal.ErrNotFound:
+			plan.create = append(plan.create, t.tag)
+			plan.report.Tags.Created++
+		case err != nil:
+			return nil, err
+		case t.implicit || (t.tag.Color == "" || t.tag.Color == existing.Color) && (t.colorOnly || t.tag.Description == existing.Description):
+			plan.report.Tags.Unchanged++
+		default:
+			if t.tag.Color != "" {
+				existing.Color = t.tag.Color
+			}
+			if !t.colorOnly {
+				existing.Description = t.tag.Description
+			}
+			plan.update = append(plan.update, existing)
+			plan.report.Tags.Updated++
+		}
+	}
+
+	existingAliases, err := r.kvstore.GetAllAliases()
+	if err != nil {
+		return nil, err
+	}
+	for alias, name := range plan.aliases {
+		switch current, ok := existingAliases[alias]; {
+		case !ok:
+			plan.report.Aliases.Created++
+		case current != name:
+			plan.report.Aliases.Updated++
+		default:
+			delete(plan.aliases, alias)
+			plan.report.Aliases.Unchanged++
+		}
+	}
+
+	for _, re := range plan.resources {
+		current, err := r.kvstore.GetResource(re.ID)
+		switch {
+		case err == internal.ErrNotFound:
+			plan.report.Resources.Created++
+		case err != nil:
+			return nil, err
+		case importUnchanged(current, re):
+			plan.report.Resources.Unchanged++
+		default:
+			plan.report.Resources.Updated++
+		}
+	}
+
+	if mode != internal.ImportReplace {
+		return plan, nil
+	}
+	err = r.kvstore.Snapshot(func(snap KVSnapshot) error {
+		err := snap.ForEachResource(func(re internal.Resource) error {
+			if !seen[re.ID] {
+				plan.deleteResources = append(plan.deleteResources, re.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return snap.ForEachTag(func(t internal.Tag) error {
+			if _, ok := tags[t.Name]; !ok {
+				plan.deleteTags = append(plan.deleteTags, t.Name)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to read records to replace")
+		return nil, errors.New("unable to read records to replace")
+	}
+	// children are deleted before their parents
+	sort.Sort(sort.Reverse(sort.StringSlice(plan.deleteTags)))
+	if set.aliases != nil {
+		for alias, name := range existingAliases {
+			if !keepAliases[alias] && !containsName(plan.deleteTags, name) {
+				plan.deleteAliases[alias] = name
+			}
+		}
+	}
+	plan.report.Resources.Deleted = len(plan.deleteResources)
+	plan.report.Tags.Deleted = len(plan.deleteTags)
+	plan.report.Aliases.Deleted = len(plan.deleteAliases)
+	return plan, nil
+}
+
+// importUnchanged reports whether importing a resource would leave the stored one as it is
+func importUnchanged(current internal.Resource, re internal.Resource) bool {
+	if current.Name != re.Name || current.Type != re.Type || len(current.Tags) != len(re.Tags) {
+		return false
+	}
+	if len(current.Attributes) != len(re.Attributes) || len(re.Attributes) > 0 && !reflect.DeepEqual(current.Attributes, re.Attributes) {
+		return false
+	}
+	for _, t := range re.Tags {
+		if !hasTag(current.Tags, t.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyImport writes the plan in a single transaction so a failed import leaves the store as it was
+func (r *repository) applyImport(plan *importPlan) error {
+	types, err := r.kvstore.GetAllTypes()
+	if err != nil {
+		return err
+	}
+	check, err := r.typeCheck()
+	if err != nil {
+		return err
+	}
+	now := r.clock.Now()
+	err = r.kvstore.Batch(func(batch KVBatch) error {
+		// the tags are sorted by name so parents are created before their children
+		for _, def := range plan.create {
+			tag := r.newTag(def)
+			if !def.CreatedAt.IsZero() {
+				tag.CreatedAt, tag.CreatedBy = def.CreatedAt, def.CreatedBy
+				tag.UpdatedAt, tag.UpdatedBy = def.UpdatedAt, def.UpdatedBy
+			}
+			if err := r.putNewTag(batch, tag); err != nil {
+				return err
+			}
+		}
+		for _, tag := range plan.update {
+			old, err := batch.GetTag(tag.Name)
+			if err != nil {
+				return err
+			}
+			tag.UpdatedAt, tag.UpdatedBy = now, r.actor
+			tag.TaggedAt, tag.TaggedBy = nil, ""
+			if err := batch.ReplaceTag(old.Name, &tag, internal.Precondition{}, r.change(historyUpdateTag, now), replaceTagOp(old, &tag)); err != nil {
+				return err
+			}
+		}
+		for alias, name := range plan.aliases {
+			// an alias is moved to another tag by deleting it first
+			if err := batch.DeleteAlias(alias); err != nil && err != internal.ErrNotFound {
+				return err
+			}
+			if err := batch.PutAlias(alias, name); err != nil {
+				return err
+			}
+		}
+
+		for _, re := range plan.resources {
+			if _, err := r.importResource(batch, re, types, check, now); err != nil && err != errUnchanged {
+				return err
+			}
+		}
+		for _, id := range plan.deleteResources {
+			_, err := batch.MutateResource(id, internal.Precondition{}, r.change(opDeleteResource, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+				if current == nil {
+					return nil, nil, internal.ErrNotFound
+				}
+				return nil, []GraphOp{{Op: opDeleteResource, Resource: current}}, nil
+			})
+			if err != nil && err != internal.ErrNotFound {
+				return err
+			}
+		}
+		for alias := range plan.deleteAliases {
+			if err := batch.DeleteAlias(alias); err != nil && err != internal.ErrNotFound {
+				return err
+			}
+		}
+		for _, name := range plan.deleteTags {
+			old, err := batch.GetTag(name)
+			if err == internal.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := batch.ReplaceTag(name, nil, internal.Precondition{}, r.change(historyDeleteTag, now), replaceTagOp(old, nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return r.writeError(err)
+	}
+	r.syncGraph()
+	return nil
+}
+
+// importResource creates or updates a resource within the batch. New resources keep the timestamps of the
+// import, updated ones keep their creation and record the import as their latest update.
+func (r *repository) importResource(batch KVBatch, re internal.Resource, types []internal.ResourceType, check func(internal.Resource) error, now time.Time) (internal.Resource, error) {
+	return batch.MutateResource(re.ID, internal.Precondition{}, r.change(historyImport, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current != nil && importUnchanged(*current, re) {
+			return nil, nil, errUnchanged
+		}
+		var color internal.Color
+		for _, t := range types {
+			if t.Name == re.Type {
+				color = t.DefaultColor
+			}
+		}
+		var previous []internal.Tag
+		if current != nil {
+			previous = current.Tags
+		}
+		imported := re.Tags
+		re.Tags = make([]internal.Tag, 0, len(imported))
+		for _, t := range imported {
+			tag, err := r.ensureTag(batch, internal.Tag{Name: t.Name, Color: color})
+			if err != nil {
+				return nil, nil, err
+			}
+			tag = r.assigned(tag, previous, now)
+			if t.TaggedAt != nil && !hasTag(previous, t.Name) {
+				tag.TaggedAt, tag.TaggedBy = t.TaggedAt, t.TaggedBy
+			}
+			re.Tags = append(re.Tags, tag)
+		}
+		if err := check(re); err != nil {
+			return nil, nil, err
+		}
+
+		if current == nil {
+			if re.CreatedAt.IsZero() {
+				re.CreatedAt, re.CreatedBy = now, r.actor
+			}
+			if re.UpdatedAt.IsZero() {
+				re.UpdatedAt, re.UpdatedBy = re.CreatedAt, re.CreatedBy
+			}
+			// tagging a resource updates it, resources tagged since a time are looked up by their update
+			for _, t := range re.Tags {
+				if t.TaggedAt != nil && t.TaggedAt.After(re.UpdatedAt) {
+					re.UpdatedAt = *t.TaggedAt
+				}
+			}
+			return &re, []GraphOp{{Op: opCreateResource, Resource: &re}}, nil
+		}
+		re.CreatedAt, re.CreatedBy = current.CreatedAt, current.CreatedBy
+		re.UpdatedAt, re.UpdatedBy = now, r.actor
+		return &re, []GraphOp{{Op: opUpdateResource, Old: current, Resource: &re}}, nil
+	})
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "lang/go", "cli")
+	mustCreateResource(t, env.repo, "r2", "lang/rust")
+	if _, err := env.repo.AddTagAlias("lang/go", "golang"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{internal.FormatJSONL, internal.FormatCSV} {
+		var buf bytes.Buffer
+		if err := env.repo.Export(&buf, format); err != nil {
+			t.Fatalf("%s export: %v", format, err)
+		}
+		target := newTestRepository(t)
+		report, err := target.repo.Import(&buf, format, internal.ImportOptions{Mode: internal.ImportMerge})
+		if err != nil || len(report.Errors) > 0 {
+			t.Fatalf("%s import: %v %v", format, err, report.Errors)
+		}
+		if report.Resources.Created != 2 {
+			t.Errorf("%s import created %d resources, want 2", format, report.Resources.Created)
+		}
+		res, err := target.repo.FindResourceByID("r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Tags) != 2 || res.Tags[0].Name != "lang/go" || res.Tags[1].Name != "cli" {
+			t.Errorf("%s imported tags %v, want [lang/go cli]", format, res.Tags)
+		}
+		found, _, err := target.repo.FindAllResources(&internal.ResourceParams{Tag: "lang/rust"})
+		if err != nil || len(found) != 1 || found[0].ID != "r2" {
+			t.Errorf("%s graph lookup of the imported tag: %v %v", format, found, err)
+		}
+	}
+}
+
+func TestExportJSONLOrder(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r2", "b")
+	mustCreateResource(t, env.repo, "r1", "a")
+	if _, err := env.repo.AddTagAlias("a", "alpha"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := env.repo.Export(&buf, internal.FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record exportRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		switch record.Kind {
+		case recordTag:
+			records = append(records, record.Tag.Name)
+		case recordAlias:
+			records = append(records, record.Alias.Name)
+		case recordResource:
+			records = append(records, record.Resource.ID)
+		}
+	}
+	if got := strings.Join(records, ","); got != "a,b,alpha,r1,r2" {
+		t.Errorf("exported %s, want the tags, aliases and resources each in key order", got)
+	}
+}
+
+// restoreProbe is an export reader which checks, on its first write, that a restore could take the store
+type restoreProbe struct {
+	kv       *boltkv
+	probed   bool
+	released bool
+	out      bytes.Buffer
+}
+
+func (p *restoreProbe) Write(b []byte) (int, error) {
+	if !p.probed {
+		p.probed = true
+		locked := make(chan struct{})
+		go func() {
+			p.kv.mu.Lock()
+			p.kv.mu.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			p.released = true
+		case <-time.After(time.Second):
+		}
+	}
+	return p.out.Write(b)
+}
+
+func TestExportReleasesSnapshotBeforeWriting(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+
+	probe := &restoreProbe{kv: env.kv}
+	if err := env.repo.Export(probe, internal.FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	if !probe.released {
+		t.Error("export held the read transaction while writing to the reader")
+	}
+	if !strings.Contains(probe.out.String(), `"id":"r1"`) {
+		t.Errorf("export missing r1: %s", probe.out.String())
+	}
+}
+
+func TestImportReplaceRequiresConfirmation(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r1", "go")
+	body := `{"kind":"resource","resource":{"id":"r2","name":"r2","type":"note"}}`
+
+	_, err := env.repo.Import(strings.NewReader(body), internal.FormatJSONL, internal.ImportOptions{Mode: internal.ImportReplace})
+	if !errors.Is(err, internal.ErrInvalid) {
+		t.Fatalf("unconfirmed replace returned %v, want ErrInvalid", err)
+	}
+	report, err := env.repo.Import(strings.NewReader(body), internal.FormatJSONL, internal.ImportOptions{Mode: internal.ImportReplace, DryRun: true})
+	if err != nil {
+		t.Fatalf("replace dry run: %v", err)
+	}
+	if report.Resources.Deleted != 1 || report.Tags.Deleted != 1 {
+		t.Errorf("dry run deletes %d resources and %d tags, want 1 and 1", report.Resources.Deleted, report.Tags.Deleted)
+	}
+	if _, err := env.repo.FindResourceByID("r1"); err != nil {
+		t.Errorf("the refused replace deleted r1: %v", err)
+	}
+
+	if _, err := env.repo.Import(strings.NewReader(body), internal.FormatJSONL, internal.ImportOptions{Mode: internal.ImportReplace, Confirm: true}); err != nil {
+		t.Fatalf("confirmed replace: %v", err)
+	}
+	if _, err := env.repo.FindResourceByID("r1"); err != internal.ErrNotFound {
+		t.Errorf("replaced resource lookup returned %v, want ErrNotFound", err)
+	}
+	if _, err := env.repo.FindTagByName("go"); err != internal.ErrNotFound {
+		t.Errorf("replaced tag lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestImportReplaceRefusesEmptyImport(t *testing.T) {
+	for name, body := range map[string]string{
+		"empty":       "",
+		"blank lines": "\n\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			env := newTestRepository(t)
+			mustCreateResource(t, env.repo, "r1", "go")
+			for _, opts := range []internal.ImportOptions{
+				{Mode: internal.ImportReplace, Confirm: true},
+				{Mode: internal.ImportReplace, DryRun: true},
+			} {
+				_, err := env.repo.Import(strings.NewReader(body), internal.FormatJSONL, opts)
+				if !errors.Is(err, internal.ErrInvalid) {
+					t.Errorf("empty replace %+v returned %v, want ErrInvalid", opts, err)
+				}
+			}
+			if _, err := env.repo.FindResourceByID("r1"); err != nil {
+				t.Errorf("the empty replace deleted r1: %v", err)
+			}
+		})
+	}
+}
+
+// TestImportIsAtomic covers a write failing after the import was checked, an alias clashing with a stored
+// tag is only refused by the kv store. Nothing the import made before it may be kept.
+func TestImportIsAtomic(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "r0", "golang")
+	body := strings.Join([]string{
+		`{"kind":"tag","tag":{"name":"go","color":"#00ADD8"}}`,
+		`{"kind":"alias","alias":{"name":"golang","tag":"go"}}`,
+		`{"kind":"resource","resource":{"id":"r1","name":"r1","type":"note","tags":[{"name":"go"}]}}`,
+	}, "\n")
+
+	_, err := env.repo.Import(strings.NewReader(body), internal.FormatJSONL, internal.ImportOptions{Mode: internal.ImportMerge})
+	if err != internal.ErrConflict {
+		t.Fatalf("import returned %v, want ErrConflict", err)
+	}
+	if _, err := env.repo.FindTagByName("go"); err != internal.ErrNotFound {
+		t.Errorf("tag of the failed import lookup returned %v, want ErrNotFound", err)
+	}
+	if _, err := env.repo.FindResourceByID("r1"); err != internal.ErrNotFound {
+		t.Errorf("resource of the failed import lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+// TestTransferWithoutGraph imports on the kv store alone as the commands do, the graph catches up when the
+// repository is next opened
+func TestTransferWithoutGraph(t *testing.T) {
+	env := newTestRepository(t)
+	env.stop()
+
+	lc := fxtest.NewLifecycle(t)
+	kv := newBoltConnection(lc, env.config)
+	lc.RequireStart()
+	transfer, err := NewTransfer(env.config, env.clock, kv, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"kind":"resource","resource":{"id":"r1","name":"r1","type":"note","tags":[{"name":"go"}]}}`
+	if _, err := transfer.Import(strings.NewReader(body), internal.FormatJSONL, internal.ImportOptions{Mode: internal.ImportMerge}); err != nil {
+		t.Fatal(err)
+	}
+	lc.RequireStop()
+
+	env = openTestRepository(t, env.config, env.clock)
+	res, err := env.repo.FindResourceByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CreatedBy != "cli" {
+		t.Errorf("created by %q, want cli", res.CreatedBy)
+	}
+	found, _, err := env.repo.FindAllResources(&internal.ResourceParams{Tag: "go"})
+	if err != nil || len(found) != 1 {
+		t.Errorf("graph lookup after reopening: %v %v", found, err)
+	}
+}
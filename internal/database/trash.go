@@ -0,0 +1,253 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+// trashBucket holds a nested bucket per kind with the deleted records keyed by id
+var trashBucket = []byte("trash")
+
+var trashKinds = []string{internal.TrashResource, internal.TrashTag}
+
+func createTrashBuckets(tx *bolt.Tx) error {
+	bucket, err := tx.CreateBucketIfNotExists(trashBucket)
+	if err != nil {
+		return err
+	}
+	for _, kind := range trashKinds {
+		if _, err := bucket.CreateBucketIfNotExists([]byte(kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validTrashKind(kind string) error {
+	for _, k := range trashKinds {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown trash kind %q", internal.ErrInvalid, kind)
+}
+
+func putTrash(tx *bolt.Tx, item internal.TrashItem) error {
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(trashBucket).Bucket([]byte(item.Kind)).Put([]byte(item.ID), buf)
+}
+
+// removeTrash drops a trashed record once a record with the same id is stored again
+func removeTrash(tx *bolt.Tx, kind string, id string) error {
+	return tx.Bucket(trashBucket).Bucket([]byte(kind)).Delete([]byte(id))
+}
+
+func (b *boltkv) GetTrash(kind string) ([]internal.TrashItem, error) {
+	kinds := trashKinds
+	if kind != "" {
+		kinds = []string{kind}
+	}
+	var items []internal.TrashItem
+	err := b.view(func(tx *bolt.Tx) error {
+		for _, k := range kinds {
+			err := tx.Bucket(trashBucket).Bucket([]byte(k)).ForEach(func(k, v []byte) error {
+				var item internal.TrashItem
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				items = append(items, item)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to fetch trash")
+		return nil, errors.New("unable to fetch trash")
+	}
+	return items, nil
+}
+
+func (b *boltkv) GetTrashItem(kind string, id string) (internal.TrashItem, error) {
+	var item internal.TrashItem
+	err := b.view(func(tx *bolt.Tx) error {
+		res := tx.Bucket(trashBucket).Bucket([]byte(kind)).Get([]byte(id))
+		if res == nil {
+			return internal.ErrNotFound
+		}
+		return json.Unmarshal(res, &item)
+	})
+	return item, err
+}
+
+// PurgeTrash permanently removes the records deleted before the given time, along with the history of the
+// resources
+func (b *boltkv) PurgeTrash(before time.Time) (int, error) {
+	purged := 0
+	err := b.update(func(tx *bolt.Tx) error {
+		for _, kind := range trashKinds {
+			expired, err := purgeBucket(tx.Bucket(trashBucket).Bucket([]byte(kind)), func(v []byte) (bool, error) {
+				var item internal.TrashItem
+				err := json.Unmarshal(v, &item)
+				return err == nil && item.DeletedAt.Before(before), err
+			})
+			if err != nil {
+				return err
+			}
+			if kind == internal.TrashResource {
+				for _, k := range expired {
+					if err := deleteHistory(tx, k); err != nil {
+						return err
+					}
+				}
+			}
+			purged += len(expired)
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to purge trash")
+		return 0, errors.New("unable to purge trash")
+	}
+	if purged > 0 {
+		b.changed()
+	}
+	return purged, nil
+}
+
+func (r *repository) FindTrash(kind string) ([]internal.TrashItem, error) {
+	if kind != "" {
+		if err := validTrashKind(kind); err != nil {
+			return nil, err
+		}
+	}
+	return r.kvstore.GetTrash(kind)
+}
+
+func (r *repository) RestoreFromTrash(kind string, id string) (internal.TrashItem, error) {
+	if err := validTrashKind(kind); err != nil {
+		return internal.TrashItem{}, err
+	}
+	item, err := r.kvstore.GetTrashItem(kind, id)
+	if err != nil {
+		return item, err
+	}
+	if kind == internal.TrashTag {
+		tag, err := r.restoreTag(item)
+		item.Tag = &tag
+		return item, err
+	}
+	resource, err := r.restoreResource(item)
+	item.Resource = &resource
+	return item, err
+}
+
+// restoreResource recreates a deleted resource, its tags are recreated when they have been deleted since
+func (r *repository) restoreResource(item internal.TrashItem) (internal.Resource, error) {
+	re := *item.Resource
+	if _, err := r.FindResourceByID(re.ID); err != internal.ErrNotFound {
+		if err != nil {
+			logrus.WithError(err).Error("unable to find resource")
+			return re, errors.New("unable to find resource")
+		}
+		return re, internal.ErrConflict
+	}
+	if _, err := r.checkResource(re); err != nil {
+		return re, err
+	}
+
+	var tags []internal.Tag
+	for _, t := range re.Tags {
+		tag, err := r.CreateTag(internal.Tag{Name: t.Name, Color: t.Color, Description: t.Description})
+		if err != nil {
+			return re, err
+		}
+		tag.TaggedAt, tag.TaggedBy = t.TaggedAt, t.TaggedBy
+		tags = append(tags, tag)
+	}
+
+	change := r.change(historyRestore, r.clock.Now())
+	re.Tags = tags
+	re.UpdatedAt, re.UpdatedBy = change.At, change.By
+	re, err := r.kvstore.MutateResource(re.ID, internal.Precondition{}, change, func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+		if current != nil {
+			return nil, nil, internal.ErrConflict
+		}
+		return &re, []GraphOp{{Op: opCreateResource, Resource: &re}}, nil
+	})
+	if err != nil {
+		return re, r.writeError(err)
+	}
+	r.syncGraph()
+	return re, nil
+}
+
+// restoreTag recreates a deleted tag and gives it back to the resources and aliases it had which still exist
+func (r *repository) restoreTag(item internal.TrashItem) (internal.Tag, error) {
+	tag := *item.Tag
+	if _, err := r.kvstore.GetTag(tag.Name); err != internal.ErrNotFound {
+		if err != nil {
+			logrus.WithError(err).Error("unable to find tag")
+			return tag, errors.New("unable to find tag")
+		}
+		return tag, internal.ErrConflict
+	}
+	if tag.Parent != "" {
+		if _, err := r.CreateTag(internal.Tag{Name: tag.Parent}); err != nil {
+			return tag, err
+		}
+	}
+	now := r.clock.Now()
+	tag.UpdatedAt, tag.UpdatedBy = now, r.actor
+	if err := r.kvstore.PutTag(tag.Name, &tag, GraphOp{Op: opCreateTag, Tag: &tag}); err != nil {
+		logrus.WithError(err).Error("unable to save tag kv")
+		return tag, errors.New("unable to restore tag")
+	}
+	r.syncGraph()
+
+	for _, id := range item.Resources {
+		_, err := r.kvstore.MutateResource(id, internal.Precondition{}, r.change(historyRestoreTag, now), func(current *internal.Resource) (*internal.Resource, []GraphOp, error) {
+			if current == nil {
+				return nil, nil, internal.ErrNotFound
+			}
+			for _, t := range current.Tags {
+				if t.Name == tag.Name {
+					return nil, nil, internal.ErrConflict
+				}
+			}
+			re := *current
+			re.Tags = append([]internal.Tag{r.assigned(tag, nil, now)}, current.Tags...)
+			re.UpdatedAt, re.UpdatedBy = now, r.actor
+			return &re, []GraphOp{{Op: opAddResourceTag, Resource: &re, TagName: tag.Name}}, nil
+		})
+		// resources deleted or tagged again since are left as they are
+		if err != nil && err != internal.ErrNotFound && err != internal.ErrConflict {
+			logrus.WithError(err).Error("unable to store to kv")
+			return tag, errors.New("unable to restore tag")
+		}
+	}
+	r.syncGraph()
+
+	for _, alias := range item.Aliases {
+		if err := r.kvstore.PutAlias(alias, tag.Name); err != nil && err != internal.ErrConflict {
+			return tag, err
+		}
+	}
+	return tag, nil
+}
+
+func (r *repository) PurgeTrash(before time.Time) (int, error) {
+	return r.kvstore.PurgeTrash(before)
+}
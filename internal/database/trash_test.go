@@ -0,0 +1,38 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestPurgeTrashDropsHistory(t *testing.T) {
+	env := newTestRepository(t)
+	mustCreateResource(t, env.repo, "old", "go")
+	mustCreateResource(t, env.repo, "recent", "go")
+	if err := env.repo.DeleteResource("old"); err != nil {
+		t.Fatal(err)
+	}
+	env.clock.Advance(31 * 24 * time.Hour)
+	if err := env.repo.DeleteResource("recent"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := env.repo.PurgeTrash(env.clock.Now().Add(-env.config.TrashRetention))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged %d items, want 1", n)
+	}
+	if _, err := env.repo.FindResourceHistory("old"); err != internal.ErrNotFound {
+		t.Errorf("history of the purged resource returned %v, want ErrNotFound", err)
+	}
+	if revisions, err := env.repo.FindResourceHistory("recent"); err != nil || len(revisions) != 2 {
+		t.Errorf("history of the trashed resource: %d revisions, %v", len(revisions), err)
+	}
+	if _, err := env.repo.RestoreFromTrash(internal.TrashResource, "recent"); err != nil {
+		t.Errorf("restore the trashed resource: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+)
+
+func (r *repository) CreateType(t internal.ResourceType) (internal.ResourceType, error) {
+	t, err := r.normalizeType(t)
+	if err != nil {
+		return t, err
+	}
+	if _, err := r.kvstore.GetType(t.Name); err != internal.ErrNotFound {
+		if err == nil {
+			return t, internal.ErrConflict
+		}
+		logrus.WithError(err).Error("unable to find type")
+		return t, errors.New("unable to find type")
+	}
+	if err := r.kvstore.PutType(t.Name, t); err != nil {
+		logrus.WithError(err).Error("unable to save type kv")
+		return t, errors.New("unable to save type")
+	}
+	return t, nil
+}
+
+// UpdateType replaces a type definition, existing resources are only checked against it when next written
+func (r *repository) UpdateType(t internal.ResourceType) (internal.ResourceType, error) {
+	t, err := r.normalizeType(t)
+	if err != nil {
+		return t, err
+	}
+	if _, err := r.findType(t.Name); err != nil {
+		return t, err
+	}
+	if err := r.kvstore.PutType(t.Name, t); err != nil {
+		logrus.WithError(err).Error("unable to save type kv")
+		return t, errors.New("unable to save type")
+	}
+	return t, nil
+}
+
+// DeleteType removes a type definition, types still used by resources cannot be removed
+func (r *repository) DeleteType(name string) error {
+	if _, err := r.findType(name); err != nil {
+		return err
+	}
+	ids, err := r.gdb.FindAllResources(internal.ResourceParams{Type: name})
+	if err != nil {
+		logrus.WithError(err).Error("unable to find typed resources")
+		return errors.New("unable to delete type")
+	}
+	if len(ids) > 0 {
+		return internal.ErrConflict
+	}
+	return r.kvstore.DeleteType(name)
+}
+
+func (r *repository) FindTypeByName(name string) (internal.ResourceType, error) {
+	return r.findType(name)
+}
+
+func (r *repository) FindAllTypes() ([]internal.ResourceType, error) {
+	return r.kvstore.GetAllTypes()
+}
+
+func (r *repository) findType(name string) (internal.ResourceType, error) {
+	t, err := r.kvstore.GetType(name)
+	if err == internal.ErrNotFound {
+		return t, err
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to find type")
+		return t, errors.New("unable to find type")
+	}
+	return t, nil
+}
+
+// normalizeType validates a type and applies the tag policy to the tags it names so they compare equal to
+// the tags of resources. Allowed tag patterns only have their prefix case folded.
+func (r *repository) normalizeType(t internal.ResourceType) (internal.ResourceType, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	// the tag lists are rewritten in place, copies keep the slices of the caller untouched
+	t.RequiredTags = append([]string(nil), t.RequiredTags...)
+	t.AllowedTags = append([]string(nil), t.AllowedTags...)
+	if err := t.Validate(); err != nil {
+		return t, err
+	}
+	for i, tag := range t.RequiredTags {
+		n, err := r.policy.Normalize(tag)
+		if err != nil {
+			return t, err
+		}
+		t.RequiredTags[i] = n
+	}
+	for i, pattern := range t.AllowedTags {
+		if strings.HasSuffix(pattern, "*") {
+			if r.policy.CaseFold {
+				t.AllowedTags[i] = strings.ToLower(pattern)
+			}
+			continue
+		}
+		n, err := r.policy.Normalize(pattern)
+		if err != nil {
+			return t, err
+		}
+		t.AllowedTags[i] = n
+	}
+	return t, nil
+}
+
+// typeCheck loads the registered types so resources can be checked inside a write transaction, where the
+// store cannot be read. The tag names of the resources checked must already be resolved.
+func (r *repository) typeCheck() (func(resource internal.Resource) error, error) {
+	types, err := r.kvstore.GetAllTypes()
+	if err != nil {
+		return nil, err
+	}
+	return func(resource internal.Resource) error {
+		for _, t := range types {
+			if t.Name != resource.Type {
+				continue
+			}
+			var names []string
+			for _, tag := range resource.Tags {
+				names = append(names, tag.Name)
+			}
+			return t.Check(resource.Attributes, names)
+		}
+		return nil
+	}, nil
+}
+
+// checkResource validates a resource against its registered type and returns the type, or nil when the
+// type is not registered. Tag names are compared after normalization and alias resolution.
+func (r *repository) checkResource(resource internal.Resource) (*internal.ResourceType, error) {
+	t, err := r.findType(resource.Type)
+	if err == internal.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, tag := range resource.Tags {
+		n, err := r.lookupTag(tag.Name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	if err := t.Check(resource.Attributes, names); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestCreateTypeNormalizesCopies(t *testing.T) {
+	env := newTestRepository(t)
+	required := []string{"Team:Web"}
+	allowed := []string{"Team:*", "Lang/Go"}
+
+	created, err := env.repo.CreateType(internal.ResourceType{Name: "note", RequiredTags: required, AllowedTags: allowed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.RequiredTags[0] != "team:web" || created.AllowedTags[0] != "team:*" || created.AllowedTags[1] != "lang/go" {
+		t.Errorf("type stored with tags %v and %v", created.RequiredTags, created.AllowedTags)
+	}
+	if required[0] != "Team:Web" || allowed[0] != "Team:*" || allowed[1] != "Lang/Go" {
+		t.Errorf("caller slices changed to %v and %v", required, allowed)
+	}
+}
+
+func TestCreateResourceChecksType(t *testing.T) {
+	env := newTestRepository(t)
+	if _, err := env.repo.CreateType(internal.ResourceType{Name: "note", RequiredTags: []string{"team:web"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := env.repo.CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note"})
+	var terr *internal.TypeError
+	if !errors.As(err, &terr) {
+		t.Fatalf("create without the required tag returned %v, want a TypeError", err)
+	}
+	if len(terr.Violations) != 1 || terr.Violations[0].Field != "tags" {
+		t.Errorf("violations %+v", terr.Violations)
+	}
+	mustCreateResource(t, env.repo, "r1", "Team:Web")
+}
+
+func TestAddTagToResourceInvalidTag(t *testing.T) {
+	env := newTestRepository(t)
+	res := mustCreateResource(t, env.repo, "r1", "go")
+
+	_, err := env.repo.AddTagToResource(res, "not")
+	var verr *internal.ValidationError
+	if !errors.As(err, &verr) || !errors.Is(err, internal.ErrInvalid) {
+		t.Fatalf("adding a reserved tag returned %v, want a ValidationError", err)
+	}
+	if verr.Rule != "reserved" {
+		t.Errorf("rule %q, want reserved", verr.Rule)
+	}
+}
@@ -6,4 +6,6 @@ var (
 	ErrNotFound = errors.New("not found")
 	ErrConflict = errors.New("already exists")
 	ErrInvalid  = errors.New("invalid entity")
+	// ErrPrecondition is returned when a conditional write finds the record at another revision
+	ErrPrecondition = errors.New("precondition failed")
 )
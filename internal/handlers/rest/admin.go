@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"net/http"
+	"strings"
+)
+
+type adminHandler struct {
+	repo database.Repository
+}
+
+func NewAdminHandler(mr *mux.Router, repo database.Repository, config internal.Configuration) http.Handler {
+	r := mr.PathPrefix("/admin").Subrouter()
+
+	h := &adminHandler{
+		repo: repo,
+	}
+	guard := requireAdmin(config.AdminToken)
+
+	r.HandleFunc("/reconcile", h.Reconcile).Methods("POST")
+	r.HandleFunc("/normalize-tags", h.NormalizeTags).Methods("POST")
+	r.Handle("/backups", guard(http.HandlerFunc(h.FindBackups))).Methods("GET")
+	r.Handle("/backups/{name}/restore", guard(http.HandlerFunc(h.RestoreBackup))).Methods("POST")
+
+	return r
+}
+
+func (h *adminHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	fix := r.URL.Query().Get("fix") == "true"
+	resp, err := h.repo.Reconcile(fix)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "admin", "unable to reconcile", "reconcile")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+func (h *adminHandler) NormalizeTags(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	resp, err := h.repo.WithActor(Actor(r)).NormalizeTags(dryRun)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "admin", "unable to normalize tags", "normalize tags")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+// requireAdmin guards the handlers making destructive changes, requests not bearing the admin token are
+// refused and every request is refused without one configured
+func requireAdmin(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				EncodeError(w, http.StatusForbidden, "admin", "admin token not configured", "guard")
+				return
+			}
+			bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				EncodeError(w, http.StatusUnauthorized, "admin", "invalid admin token", "guard")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h *adminHandler) FindBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := h.repo.FindBackups(r.Context())
+	if err != nil {
+		h.writeBackupError(w, err, "find backups")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, backups)
+}
+
+// RestoreBackup replaces the database with the named backup, or the most recent one for latest, once it
+// passes verification
+func (h *adminHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	name := PathVars(r)["name"]
+	report, err := h.repo.RestoreBackup(r.Context(), name)
+	if err != nil {
+		h.writeBackupError(w, err, "restore backup")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, report)
+}
+
+func (h *adminHandler) writeBackupError(w http.ResponseWriter, err error, method string) {
+	switch {
+	case errors.Is(err, internal.ErrBackupDisabled):
+		EncodeError(w, http.StatusNotFound, "admin", "backups not enabled", method)
+	case errors.Is(err, internal.ErrNotFound):
+		EncodeError(w, http.StatusNotFound, "admin", "backup not found", method)
+	case EncodeValidationError(w, err, "admin", method):
+	case errors.Is(err, internal.ErrBackupCorrupt):
+		EncodeError(w, http.StatusUnprocessableEntity, "admin", err.Error(), method)
+	default:
+		EncodeError(w, http.StatusInternalServerError, "admin", "unable to "+method, method)
+	}
+}
@@ -0,0 +1,195 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"unicode"
+)
+
+const (
+	// maxBulkOperations bounds the number of operations of a bulk request
+	maxBulkOperations = 10000
+	// maxBulkBodySize bounds the size of a bulk request
+	maxBulkBodySize = 32 << 20
+)
+
+// errTooManyOperations is returned decoding a bulk request of more than maxBulkOperations operations
+var errTooManyOperations = fmt.Errorf("at most %d operations are allowed", maxBulkOperations)
+
+type bulkResponse struct {
+	Applied int          `json:"applied"`
+	Failed  int          `json:"failed"`
+	Results []bulkResult `json:"results"`
+}
+
+type bulkResult struct {
+	Index      int                  `json:"index"`
+	Op         string               `json:"op"`
+	ID         string               `json:"id,omitempty"`
+	Status     int                  `json:"status"`
+	Error      string               `json:"error,omitempty"`
+	Violations []internal.Violation `json:"violations,omitempty"`
+	Resource   *internal.Resource   `json:"resource,omitempty"`
+}
+
+// Bulk applies a JSON array or newline delimited JSON stream of operations, responding with the result of
+// each. With atomic=true either every operation is applied or none are.
+func (h *resourceHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	ops, err := decodeBulkOperations(http.MaxBytesReader(w, r.Body, maxBulkBodySize), maxBulkOperations)
+	var malformed *malformedOperationError
+	switch {
+	case errors.As(err, &malformed):
+		EncodeError(w, http.StatusBadRequest, "resources", err.Error(), "bulk")
+		return
+	case err == errTooManyOperations:
+		EncodeError(w, http.StatusRequestEntityTooLarge, "resources", err.Error(), "bulk")
+		return
+	case err != nil:
+		logrus.WithError(err).Warn("unable to read bulk request")
+		EncodeError(w, http.StatusRequestEntityTooLarge, "resources", fmt.Sprintf("request body is larger than %d bytes or unreadable", maxBulkBodySize), "bulk")
+		return
+	}
+
+	atomic := r.URL.Query().Get("atomic") == "true"
+	results, err := h.writer(r).BulkResources(ops, atomic)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to apply bulk request", "bulk")
+		return
+	}
+
+	resp := bulkResponse{Results: make([]bulkResult, 0, len(results))}
+	for _, res := range results {
+		result := bulkResult{Index: res.Index, Op: res.Op, ID: res.ID, Resource: res.Resource}
+		result.Status, result.Error = bulkStatus(res.Op, res.Err)
+		var terr *internal.TypeError
+		if errors.As(res.Err, &terr) {
+			result.Violations = terr.Violations
+		}
+		if res.Err == nil {
+			resp.Applied++
+		} else {
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+// malformedOperationError is returned for a bulk request which is not valid JSON, as opposed to one which
+// could not be read
+type malformedOperationError struct {
+	index int
+}
+
+func (e *malformedOperationError) Error() string {
+	return fmt.Sprintf("Bad Request from unmarshalling operation %d", e.index)
+}
+
+// decodeBulkOperations streams a JSON array of operations or one operation per line, stopping with
+// errTooManyOperations once more than max have been read
+func decodeBulkOperations(body io.Reader, max int) ([]internal.BulkOperation, error) {
+	br := bufio.NewReader(body)
+	array := false
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(c)) {
+			array = c == '['
+			br.UnreadByte()
+			break
+		}
+	}
+
+	var ops []internal.BulkOperation
+	dec := json.NewDecoder(br)
+	// next decodes one operation, io.EOF is passed on at the end of a stream of operations
+	next := func() error {
+		var op internal.BulkOperation
+		if err := dec.Decode(&op); err != nil {
+			return err
+		}
+		if len(ops) == max {
+			return errTooManyOperations
+		}
+		ops = append(ops, op)
+		return nil
+	}
+	if !array {
+		for {
+			err := next()
+			if err == io.EOF {
+				return ops, nil
+			}
+			if err != nil {
+				return nil, decodeError(err, len(ops))
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, decodeError(err, 0)
+	}
+	for dec.More() {
+		if err := next(); err != nil {
+			return nil, decodeError(err, len(ops))
+		}
+	}
+	// the closing bracket, after which nothing may follow
+	if _, err := dec.Token(); err != nil {
+		return nil, decodeError(err, len(ops))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, decodeError(err, len(ops))
+	}
+	return ops, nil
+}
+
+// decodeError tells a malformed operation apart from a body which could not be read
+func decodeError(err error, index int) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF || errors.As(err, &syntax) || errors.As(err, &typ) {
+		return &malformedOperationError{index: index}
+	}
+	return err
+}
+
+// bulkStatus maps the outcome of an operation to the status the single resource endpoints respond with
+func bulkStatus(op string, err error) (int, string) {
+	var terr *internal.TypeError
+	switch {
+	case err == nil && op == internal.BulkCreate:
+		return http.StatusCreated, ""
+	case err == nil:
+		return http.StatusOK, ""
+	case err == internal.ErrAborted:
+		return http.StatusFailedDependency, err.Error()
+	case errors.As(err, &terr):
+		return http.StatusUnprocessableEntity, "resource violates type"
+	case err == internal.ErrNotFound && op == internal.BulkUntag:
+		return http.StatusNotFound, "resource or tag not found"
+	case err == internal.ErrNotFound:
+		return http.StatusNotFound, "resource not found"
+	case err == internal.ErrConflict && op == internal.BulkTag:
+		return http.StatusConflict, "resource already tagged"
+	case err == internal.ErrConflict:
+		return http.StatusConflict, "entity exists"
+	case errors.Is(err, internal.ErrInvalid):
+		return http.StatusBadRequest, err.Error()
+	default:
+		return http.StatusInternalServerError, "failed to apply operation"
+	}
+}
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBulkOperations(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		ops  int
+		err  string
+	}{
+		{"empty", "", 0, ""},
+		{"empty array", " [] ", 0, ""},
+		{"array", `[{"op":"tag","id":"a","tags":["go"]},{"op":"untag","id":"b","tags":["go"]}]`, 2, ""},
+		{"lines", "{\"op\":\"tag\",\"id\":\"a\",\"tags\":[\"go\"]}\n{\"op\":\"untag\",\"id\":\"b\",\"tags\":[\"go\"]}\n", 2, ""},
+		{"array at limit", `[{"op":"tag"},{"op":"tag"},{"op":"tag"}]`, 3, ""},
+		{"array over limit", `[{"op":"tag"},{"op":"tag"},{"op":"tag"},{"op":"tag"}]`, 0, "too many"},
+		{"lines over limit", "{}\n{}\n{}\n{}\n", 0, "too many"},
+		{"malformed array", `[{"op":"tag"},{"op":]`, 0, "malformed"},
+		{"malformed line", "{\"op\":\"tag\"}\n{\"op\"", 0, "malformed"},
+		{"wrong type", `[{"op":1}]`, 0, "malformed"},
+		{"unterminated array", `[{"op":"tag"}`, 0, "malformed"},
+		{"trailing data", `[{"op":"tag"}] {}`, 0, "malformed"},
+	}
+	for _, tt := range tests {
+		ops, err := decodeBulkOperations(strings.NewReader(tt.body), 3)
+		var malformed *malformedOperationError
+		switch tt.err {
+		case "":
+			if err != nil || len(ops) != tt.ops {
+				t.Errorf("%s: %d operations, %v, want %d", tt.name, len(ops), err, tt.ops)
+			}
+		case "too many":
+			if err != errTooManyOperations {
+				t.Errorf("%s: %v, want errTooManyOperations", tt.name, err)
+			}
+		case "malformed":
+			if !errors.As(err, &malformed) {
+				t.Errorf("%s: %v, want a malformed operation", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestDecodeBulkOperationsReadError(t *testing.T) {
+	failed := errors.New("connection reset")
+	_, err := decodeBulkOperations(&failingReader{data: `[{"op":"tag"},`, err: failed}, 3)
+	if err != failed {
+		t.Errorf("read error returned as %v", err)
+	}
+}
+
+// failingReader returns data and then fails
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestBulk(t *testing.T) {
+	router, repo := newTestRouter(t)
+	w := serve(router, http.MethodPost, "/resource/_bulk", `[
+		{"op":"create","resource":{"id":"a","name":"a","type":"note","tags":[{"name":"go"}]}},
+		{"op":"tag","id":"missing","tags":["cli"]}
+	]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bulk: %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"applied":1,"failed":1`) {
+		t.Errorf("unexpected response %s", w.Body)
+	}
+	if _, err := repo.FindTagByName("cli"); err == nil {
+		t.Error("tag of the failed operation was created")
+	}
+
+	if w := serve(router, http.MethodPost, "/resource/_bulk", `[{"op":`); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed bulk: %d, want 400", w.Code)
+	}
+}
@@ -3,9 +3,16 @@ package rest
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"unicode"
 )
 
 // EncodeJSONResponse will take a given interface and encode the value as JSON
@@ -16,6 +23,16 @@ func EncodeJSONResponse(ctx context.Context, w http.ResponseWriter, response int
 	return enc.Encode(response)
 }
 
+// EncodeJSONStatus encodes a value as JSON with a status other than 200 OK, the headers are set before the
+// status is written
+func EncodeJSONStatus(ctx context.Context, w http.ResponseWriter, code int, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(response)
+}
+
 // EncodeError responds with a given error code with some additional logging information
 func EncodeError(w http.ResponseWriter, code int, domain string, message string, method string) {
 	logrus.WithFields(
@@ -26,3 +43,117 @@ func EncodeError(w http.ResponseWriter, code int, domain string, message string,
 		}).Error(strings.ToLower(message))
 	http.Error(w, message, code)
 }
+
+// EncodeValidationError responds with a bad request explaining what was invalid when err wraps ErrInvalid
+// with details, such as a tag failing the tag policy. A bare ErrInvalid is left to the caller.
+func EncodeValidationError(w http.ResponseWriter, err error, domain string, method string) bool {
+	if err == internal.ErrInvalid || !errors.Is(err, internal.ErrInvalid) {
+		return false
+	}
+	EncodeError(w, http.StatusBadRequest, domain, err.Error(), method)
+	return true
+}
+
+// EncodeTypeError responds with an unprocessable entity listing the violations when err is a type error
+func EncodeTypeError(ctx context.Context, w http.ResponseWriter, err error) bool {
+	var terr *internal.TypeError
+	if !errors.As(err, &terr) {
+		return false
+	}
+	logrus.WithField("type", terr.Type).Error("resource violates type")
+	EncodeJSONStatus(ctx, w, http.StatusUnprocessableEntity, terr)
+	return true
+}
+
+// EncodeNextLink adds a Link header pointing at the next page of results when a cursor is available
+func EncodeNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	if cursor == "" {
+		return
+	}
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+const (
+	// ActorHeader names the header identifying who is making a change, it is recorded on the records written
+	ActorHeader = "X-Actor"
+	// claimedActor prefixes the actors named by ActorHeader, the header is not authenticated so the name is
+	// only what the client claims to be
+	claimedActor = "claimed:"
+	// maxActorLength bounds the name recorded for an actor
+	maxActorLength = 128
+)
+
+// Actor returns who the request claims to be made by, such as "claimed:alice", or an empty string when
+// the request names no one
+func Actor(r *http.Request) string {
+	name := strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, strings.TrimSpace(r.Header.Get(ActorHeader)))
+	if name == "" {
+		return ""
+	}
+	if runes := []rune(name); len(runes) > maxActorLength {
+		name = string(runes[:maxActorLength])
+	}
+	return claimedActor + name
+}
+
+// Precondition returns the If-Match and If-None-Match conditions of a request
+func Precondition(r *http.Request) internal.Precondition {
+	return internal.Precondition{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+}
+
+// EncodeETag sets the ETag header to the given revision
+func EncodeETag(w http.ResponseWriter, revision uint64) {
+	w.Header().Set("ETag", internal.ETag(revision))
+}
+
+// PathVars returns the unescaped route variables, the router matches on the encoded path so values such as
+// hierarchical tag names may contain an escaped slash.
+func PathVars(r *http.Request) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range mux.Vars(r) {
+		if unescaped, err := url.PathUnescape(v); err == nil {
+			v = unescaped
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+// EncodeResolvedAliases adds an X-Resolved-Aliases header listing which of the given tag names were aliases
+// and the tags they resolved to, e.g. "k8s=kubernetes, js=javascript".
+func EncodeResolvedAliases(w http.ResponseWriter, resolver internal.TagAliaser, names []string) {
+	resolved, err := resolver.ResolveTagNames(names)
+	if err != nil {
+		logrus.WithError(err).Warn("unable to resolve aliases")
+		return
+	}
+	if len(resolved) == 0 {
+		return
+	}
+	var pairs []string
+	for alias, tag := range resolved {
+		pairs = append(pairs, alias+"="+tag)
+	}
+	sort.Strings(pairs)
+	w.Header().Set("X-Resolved-Aliases", strings.Join(pairs, ", "))
+}
+
+func tagNames(tags []internal.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return names
+}
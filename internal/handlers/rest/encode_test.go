@@ -0,0 +1,85 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestEncodeTypeError(t *testing.T) {
+	w := httptest.NewRecorder()
+	terr := &internal.TypeError{Type: "note", Violations: []internal.Violation{{Field: "tags", Message: `tag "team" is required`}}}
+
+	if !EncodeTypeError(context.Background(), w, terr) {
+		t.Fatal("type error not encoded")
+	}
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	var body internal.TypeError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "note" || len(body.Violations) != 1 {
+		t.Errorf("body %+v", body)
+	}
+
+	if EncodeTypeError(context.Background(), httptest.NewRecorder(), internal.ErrInvalid) {
+		t.Error("other errors encoded as type errors")
+	}
+}
+
+func TestEncodeValidationError(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := &internal.ValidationError{Rule: "reserved", Value: "not", Msg: `"not" is a reserved word`}
+	if !EncodeValidationError(w, err, "tags", "create") {
+		t.Fatal("validation error not encoded")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", w.Code)
+	}
+	if EncodeValidationError(httptest.NewRecorder(), internal.ErrInvalid, "tags", "create") {
+		t.Error("bare ErrInvalid encoded")
+	}
+}
+
+func TestActor(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"  ", ""},
+		{"alice", "claimed:alice"},
+		{" bob\r\n", "claimed:bob"},
+		{"eve\x00admin", "claimed:eveadmin"},
+		{strings.Repeat("x", 200), "claimed:" + strings.Repeat("x", maxActorLength)},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/tag/", nil)
+		if tt.header != "" {
+			r.Header[ActorHeader] = []string{tt.header}
+		}
+		if got := Actor(r); got != tt.want {
+			t.Errorf("Actor(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestPrecondition(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/tag/go", nil)
+	r.Header.Set("If-Match", `"3"`)
+	r.Header.Set("If-None-Match", "*")
+	p := Precondition(r)
+	if p.IfMatch != `"3"` || p.IfNoneMatch != "*" {
+		t.Errorf("precondition %+v", p)
+	}
+}
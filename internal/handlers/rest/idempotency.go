@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+)
+
+// IdempotencyKeyHeader names the header clients set to make a write safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize bounds the body read to fingerprint a request made with an idempotency key
+	maxIdempotentBodySize = 32 << 20
+)
+
+// Idempotent replays the stored response when a write is retried with the same Idempotency-Key. Keys are
+// scoped to the method, path and client of the request. Reusing a key for a different request is rejected
+// with an unprocessable entity and retrying while the first request is still in flight with a conflict.
+// Server errors are not stored so the request can be retried.
+func Idempotent(store internal.IdempotencyStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				EncodeError(w, http.StatusBadRequest, "idempotency", "idempotency key is too long", "idempotent")
+				return
+			}
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+			r.Body.Close()
+			if err != nil {
+				EncodeError(w, http.StatusRequestEntityTooLarge, "idempotency", fmt.Sprintf("request body is larger than %d bytes or unreadable", maxIdempotentBodySize), "idempotent")
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			key = scopedIdempotencyKey(r, key)
+			fingerprint := requestFingerprint(r, body)
+			req, found, err := store.BeginIdempotentRequest(key, fingerprint)
+			if err != nil {
+				EncodeError(w, http.StatusInternalServerError, "idempotency", "unable to check idempotency key", "idempotent")
+				return
+			}
+			if found {
+				switch {
+				case req.Fingerprint != fingerprint:
+					EncodeError(w, http.StatusUnprocessableEntity, "idempotency", "idempotency key was used for a different request", "idempotent")
+				case !req.Completed:
+					EncodeError(w, http.StatusConflict, "idempotency", "a request with this idempotency key is in progress", "idempotent")
+				default:
+					for k, v := range req.Header {
+						w.Header()[k] = v
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(req.Status)
+					w.Write(req.Body)
+				}
+				return
+			}
+
+			// a handler which panics has not responded, its key is released so the request can be retried
+			finished := false
+			defer func() {
+				if !finished {
+					if err := store.AbandonIdempotentRequest(key); err != nil {
+						logrus.WithError(err).Error("unable to release idempotency key")
+					}
+				}
+			}()
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			finished = true
+			if rec.status >= http.StatusInternalServerError {
+				if err := store.AbandonIdempotentRequest(key); err != nil {
+					logrus.WithError(err).Error("unable to release idempotency key")
+				}
+				return
+			}
+			req.Status, req.Header, req.Body = rec.status, rec.Header().Clone(), rec.body.Bytes()
+			if err := store.CompleteIdempotentRequest(req); err != nil {
+				logrus.WithError(err).Error("unable to store idempotent response")
+			}
+		})
+	}
+}
+
+// scopedIdempotencyKey qualifies a key with the method and path of the request and the client making it, the
+// credentials and claimed actor, so clients choosing the same key do not see each other's responses
+func scopedIdempotencyKey(r *http.Request, key string) string {
+	h := sha256.New()
+	h.Write([]byte(r.Header.Get("Authorization") + "\n" + Actor(r)))
+	return r.Method + " " + r.URL.EscapedPath() + " " + hex.EncodeToString(h.Sum(nil)[:16]) + " " + key
+}
+
+// requestFingerprint identifies a request by its method, path, query and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the status and body written to the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
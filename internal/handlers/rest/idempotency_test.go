@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// memoryIdempotencyStore keeps idempotent requests in a map
+type memoryIdempotencyStore map[string]internal.IdempotentRequest
+
+func (m memoryIdempotencyStore) BeginIdempotentRequest(key string, fingerprint string) (internal.IdempotentRequest, bool, error) {
+	if req, ok := m[key]; ok {
+		return req, true, nil
+	}
+	req := internal.IdempotentRequest{Key: key, Fingerprint: fingerprint}
+	m[key] = req
+	return req, false, nil
+}
+
+func (m memoryIdempotencyStore) CompleteIdempotentRequest(req internal.IdempotentRequest) error {
+	req.Completed = true
+	m[req.Key] = req
+	return nil
+}
+
+func (m memoryIdempotencyStore) AbandonIdempotentRequest(key string) error {
+	delete(m, key)
+	return nil
+}
+
+// countingHandler responds 201 with the number of requests it served
+func countingHandler(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strings.Repeat("x", *calls)))
+	})
+}
+
+func idempotentRequest(method string, target string, body string, key string, headers ...string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, key)
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	return r
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	calls := 0
+	h := Idempotent(memoryIdempotencyStore{})(countingHandler(&calls))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, idempotentRequest(http.MethodPost, "/resource/", `{"name":"a"}`, "k1"))
+		if w.Code != http.StatusCreated || w.Body.String() != "x" {
+			t.Errorf("attempt %d: %d %q", i, w.Code, w.Body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotentRequest(http.MethodPost, "/resource/", `{"name":"b"}`, "k1"))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body: %d, want 422", w.Code)
+	}
+}
+
+func TestIdempotentKeyScope(t *testing.T) {
+	calls := 0
+	h := Idempotent(memoryIdempotencyStore{})(countingHandler(&calls))
+
+	requests := []*http.Request{
+		idempotentRequest(http.MethodPost, "/resource/", `{}`, "k1"),
+		idempotentRequest(http.MethodPut, "/resource/", `{}`, "k1"),
+		idempotentRequest(http.MethodPost, "/tag/", `{}`, "k1"),
+		idempotentRequest(http.MethodPost, "/resource/", `{}`, "k1", "Authorization", "Bearer other"),
+		idempotentRequest(http.MethodPost, "/resource/", `{}`, "k1", ActorHeader, "bob"),
+	}
+	for i, r := range requests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("request %d shared the key of another: %d", i, w.Code)
+		}
+	}
+	if calls != len(requests) {
+		t.Errorf("handler called %d times, want %d", calls, len(requests))
+	}
+}
+
+func TestIdempotentReleasesKeyOnPanic(t *testing.T) {
+	store := memoryIdempotencyStore{}
+	h := Idempotent(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic was swallowed")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), idempotentRequest(http.MethodPost, "/resource/", `{}`, "k1"))
+	}()
+	if len(store) != 0 {
+		t.Errorf("key still reserved after a panic: %v", store)
+	}
+}
+
+func TestIdempotentBodyLimit(t *testing.T) {
+	calls := 0
+	h := Idempotent(memoryIdempotencyStore{})(countingHandler(&calls))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, idempotentRequest(http.MethodPost, "/resource/_bulk", strings.Repeat(" ", maxIdempotentBodySize+1), "k1"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: %d, want 413", w.Code)
+	}
+	if calls != 0 {
+		t.Error("handler called with an oversized body")
+	}
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+// TestMergePatch runs the examples of RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		original string
+		patch    string
+		want     string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.original), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s): %v", tt.original, tt.patch, err)
+			continue
+		}
+		var gotValue, wantValue interface{}
+		if err := json.Unmarshal(got, &gotValue); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("MergePatch(%s, %s) = %s, want %s", tt.original, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchInvalid(t *testing.T) {
+	if _, err := MergePatch([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Error("invalid original was patched")
+	}
+	if _, err := MergePatch([]byte(`{}`), []byte(`{"a"}`)); err == nil {
+		t.Error("invalid patch was applied")
+	}
+}
+
+func TestPatchResource(t *testing.T) {
+	router, repo := newTestRouter(t)
+	if _, err := repo.CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note", Tags: []internal.Tag{{Name: "go"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(router, "PATCH", "/resource/r1", `{"name":"renamed","tags":null}`); w.Code != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", w.Code, w.Body)
+	}
+	res, err := repo.FindResourceByID("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Name != "renamed" || res.Type != "note" || len(res.Tags) != 0 {
+		t.Errorf("patched to %+v, want renamed keeping its type and without tags", res)
+	}
+
+	tests := []struct {
+		target string
+		body   string
+		code   int
+	}{
+		{"/resource/r1", `{"id":"r2"}`, http.StatusBadRequest},
+		{"/resource/r1", `{"name":`, http.StatusBadRequest},
+		{"/resource/r1", `{"tags":"go"}`, http.StatusBadRequest},
+		{"/resource/missing", `{"name":"x"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(router, "PATCH", tt.target, tt.body); w.Code != tt.code {
+			t.Errorf("patch %s with %s returned %d, want %d", tt.target, tt.body, w.Code, tt.code)
+		}
+	}
+	if res, err := repo.FindResourceByID("r1"); err != nil || res.Name != "renamed" {
+		t.Errorf("refused patches changed the resource: %+v %v", res, err)
+	}
+}
@@ -2,13 +2,18 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/holmes89/tags/internal"
 	"github.com/holmes89/tags/internal/database"
+	"github.com/holmes89/tags/internal/query"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 )
 
 var decoder = schema.NewDecoder()
@@ -19,6 +24,7 @@ type resourceHandler struct {
 
 func NewResourceHandler(mr *mux.Router, repo database.Repository) http.Handler {
 	r := mr.PathPrefix("/resource").Subrouter()
+	r.Use(Idempotent(repo))
 
 	h := &resourceHandler{
 		repo: repo,
@@ -27,10 +33,27 @@ func NewResourceHandler(mr *mux.Router, repo database.Repository) http.Handler {
 	r.HandleFunc("/", h.FindAll).Methods("GET")
 	r.HandleFunc("/{id}", h.FindByID).Methods("GET")
 	r.HandleFunc("/", h.Create).Methods("POST")
+	r.HandleFunc("/_bulk", h.Bulk).Methods("POST")
+	r.HandleFunc("/_retag", h.Retag).Methods("POST")
+	r.HandleFunc("/_retag/", h.RetagJobs).Methods("GET")
+	r.HandleFunc("/_retag/{job}", h.RetagJob).Methods("GET")
+	r.HandleFunc("/{id}", h.Update).Methods("PUT")
+	r.HandleFunc("/{id}", h.Patch).Methods("PATCH")
+	r.HandleFunc("/{id}", h.Delete).Methods("DELETE")
+	r.HandleFunc("/{id}/history", h.History).Methods("GET")
+	r.HandleFunc("/{id}/history/{revision}/revert", h.Revert).Methods("POST")
+	r.HandleFunc("/{id}/tags", h.ReplaceTags).Methods("PUT")
+	r.HandleFunc("/{id}/tags/{tag}", h.AddTag).Methods("PUT")
+	r.HandleFunc("/{id}/tags/{tag}", h.DeleteTag).Methods("DELETE")
 
 	return r
 }
 
+// writer returns the repository to make the changes of a request with
+func (h *resourceHandler) writer(r *http.Request) database.Repository {
+	return h.repo.WithActor(Actor(r)).WithPrecondition(Precondition(r))
+}
+
 func (h *resourceHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	var params *internal.ResourceParams
 	if len(r.URL.Query()) > 0 {
@@ -42,24 +65,67 @@ func (h *resourceHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 		}
 		params = &p
 	}
-	resp, err := h.repo.FindAllResources(params)
+	resp, next, err := h.repo.FindAllResources(params)
+	if EncodeValidationError(w, err, "resources", "find all") {
+		return
+	}
+	if err == nil && params != nil {
+		names := []string{params.Tag}
+		if params.Query != "" {
+			expr, _ := query.Parse(params.Query)
+			names = append(names, query.Tags(expr)...)
+		}
+		EncodeResolvedAliases(w, h.repo, names)
+	}
+	var perr *query.ParseError
+	if errors.As(err, &perr) {
+		EncodeError(w, http.StatusBadRequest, "resources", perr.Error(), "find all")
+		return
+	}
+	if err == internal.ErrInvalid {
+		EncodeError(w, http.StatusBadRequest, "resources", "invalid sort, limit or cursor", "find all")
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find resources", "find all")
 		return
 	}
+	EncodeNextLink(w, r, next)
 	EncodeJSONResponse(r.Context(), w, resp)
 }
 
 func (h *resourceHandler) FindByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	vars := PathVars(r)
 
 	id := vars["id"]
 
-	resp, err := h.repo.FindResourceByID(id)
+	var resp internal.Resource
+	var err error
+	asOf := r.URL.Query().Get("asOf")
+	if asOf != "" {
+		resp, err = h.repo.FindResourceAsOf(id, asOf)
+	} else {
+		resp, err = h.repo.FindResourceByID(id)
+	}
+	if EncodeValidationError(w, err, "resources", "find by id") {
+		return
+	}
+	if err == internal.ErrNotFound {
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "find by id")
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find resource", "find by id")
 		return
 	}
+	if asOf == "" {
+		EncodeETag(w, resp.Revision)
+		cond := internal.Precondition{IfNoneMatch: r.Header.Get("If-None-Match")}
+		if cond.Check(true, resp.Revision) != nil {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
 	EncodeJSONResponse(r.Context(), w, resp)
 }
@@ -73,15 +139,260 @@ func (h *resourceHandler) Create(w http.ResponseWriter, r *http.Request) {
 		EncodeError(w, http.StatusBadRequest, "resources", "Bad Request from unmarshalling", "create")
 		return
 	}
-	resp, err := h.repo.CreateResource(resource)
+	EncodeResolvedAliases(w, h.repo, tagNames(resource.Tags))
+	resp, err := h.writer(r).CreateResource(resource)
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "resources", "create") {
+		return
+	}
 	switch err {
 	case nil:
+		w.Header().Set("Location", path.Join(r.URL.Path, url.PathEscape(resp.ID)))
+		EncodeETag(w, resp.Revision)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
 		EncodeJSONResponse(r.Context(), w, resp)
 	case internal.ErrInvalid:
 		EncodeError(w, http.StatusBadRequest, "resources", "missing fields", "create")
 	case internal.ErrConflict:
 		EncodeError(w, http.StatusConflict, "resources", "entity exists", "create")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "create")
 	default:
 		EncodeError(w, http.StatusInternalServerError, "resources", "failed to create resource", "create")
 	}
 }
+
+func (h *resourceHandler) Update(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+	id := vars["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var resource internal.Resource
+	if err := json.Unmarshal(b, &resource); err != nil {
+		EncodeError(w, http.StatusBadRequest, "resources", "Bad Request from unmarshalling", "update")
+		return
+	}
+	if resource.ID != "" && resource.ID != id {
+		EncodeError(w, http.StatusBadRequest, "resources", "id does not match path", "update")
+		return
+	}
+	resource.ID = id
+
+	h.update(w, r, resource, "update")
+}
+
+func (h *resourceHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+	id := vars["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	existing, err := h.repo.FindResourceByID(id)
+	if err == internal.ErrNotFound {
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "patch")
+		return
+	}
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find resource", "patch")
+		return
+	}
+
+	original, err := json.Marshal(existing)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to encode resource", "patch")
+		return
+	}
+	patched, err := MergePatch(original, b)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "resources", "Bad Request from unmarshalling", "patch")
+		return
+	}
+
+	var resource internal.Resource
+	if err := json.Unmarshal(patched, &resource); err != nil {
+		EncodeError(w, http.StatusBadRequest, "resources", "patch produced an invalid resource", "patch")
+		return
+	}
+	if resource.ID != id {
+		EncodeError(w, http.StatusBadRequest, "resources", "id cannot be changed", "patch")
+		return
+	}
+
+	h.update(w, r, resource, "patch")
+}
+
+func (h *resourceHandler) update(w http.ResponseWriter, r *http.Request, resource internal.Resource, method string) {
+	EncodeResolvedAliases(w, h.repo, tagNames(resource.Tags))
+	resp, err := h.writer(r).UpdateResource(resource)
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "resources", method) {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "resources", "missing fields", method)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", method)
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", method)
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to update resource", method)
+	}
+}
+
+func (h *resourceHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	err := h.writer(r).DeleteResource(vars["id"])
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "delete")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "delete")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to delete resource", "delete")
+	}
+}
+
+func (h *resourceHandler) AddTag(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resource := internal.Resource{ID: vars["id"]}
+	EncodeResolvedAliases(w, h.repo, []string{vars["tag"]})
+	resp, err := h.writer(r).AddTagToResource(resource, vars["tag"])
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "resources", "add tag") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "resources", "invalid tag", "add tag")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "add tag")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "add tag")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "resources", "resource already tagged", "add tag")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to tag resource", "add tag")
+	}
+}
+
+func (h *resourceHandler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resource := internal.Resource{ID: vars["id"]}
+	EncodeResolvedAliases(w, h.repo, []string{vars["tag"]})
+	err := h.writer(r).DeleteTagFromResource(resource, vars["tag"])
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "resources", "invalid tag", "delete tag")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource or tag not found", "delete tag")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "delete tag")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to untag resource", "delete tag")
+	}
+}
+
+func (h *resourceHandler) ReplaceTags(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var tags []string
+	if err := json.Unmarshal(b, &tags); err != nil {
+		EncodeError(w, http.StatusBadRequest, "resources", "Bad Request from unmarshalling", "replace tags")
+		return
+	}
+
+	resource := internal.Resource{ID: vars["id"]}
+	EncodeResolvedAliases(w, h.repo, tags)
+	resp, err := h.writer(r).ReplaceResourceTags(resource, tags)
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "resources", "replace tags") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "resources", "invalid tag", "replace tags")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "replace tags")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "replace tags")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to replace tags", "replace tags")
+	}
+}
+
+func (h *resourceHandler) History(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.repo.FindResourceHistory(vars["id"])
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource not found", "history")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find history", "history")
+	}
+}
+
+func (h *resourceHandler) Revert(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	revision, err := strconv.ParseUint(vars["revision"], 10, 64)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "resources", "invalid revision", "revert")
+		return
+	}
+	resp, err := h.writer(r).RevertResource(vars["id"], revision)
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "resources", "revert") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "resource or revision not found", "revert")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "resources", "precondition failed", "revert")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "failed to revert resource", "revert")
+	}
+}
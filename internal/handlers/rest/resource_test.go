@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestResourceTagRoutes(t *testing.T) {
+	router, repo := newTestRouter(t)
+	if _, err := repo.CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{http.MethodPut, "/resource/r1/tags/go", "", http.StatusOK},
+		{http.MethodPut, "/resource/r1/tags/go", "", http.StatusConflict},
+		{http.MethodPut, "/resource/r1/tags/and", "", http.StatusBadRequest},
+		{http.MethodPut, "/resource/missing/tags/go", "", http.StatusNotFound},
+		{http.MethodDelete, "/resource/r1/tags/go", "", http.StatusNoContent},
+		{http.MethodDelete, "/resource/r1/tags/go", "", http.StatusNotFound},
+		{http.MethodDelete, "/resource/missing/tags/go", "", http.StatusNotFound},
+		{http.MethodPut, "/resource/r1/tags", `["Go", "cli"]`, http.StatusOK},
+		{http.MethodPut, "/resource/r1/tags", `["go", "and"]`, http.StatusBadRequest},
+		{http.MethodPut, "/resource/r1/tags", `{"tags": ["go"]}`, http.StatusBadRequest},
+		{http.MethodPut, "/resource/missing/tags", `["go"]`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(router, tt.method, tt.target, tt.body); w.Code != tt.code {
+			t.Errorf("%s %s %s returned %d, want %d: %s", tt.method, tt.target, tt.body, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serve(router, http.MethodGet, "/resource/r1", "")
+	var res internal.Resource
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Tags) != 2 || res.Tags[0].Name != "go" || res.Tags[1].Name != "cli" {
+		t.Errorf("tags after replace %+v, want go and cli", res.Tags)
+	}
+}
+
+// resourceIDs decodes a list of resources from a response and returns their sorted ids
+func resourceIDs(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resources []internal.Resource
+	if err := json.Unmarshal(w.Body.Bytes(), &resources); err != nil {
+		t.Fatalf("decode %d %s: %v", w.Code, w.Body, err)
+	}
+	var ids []string
+	for _, res := range resources {
+		ids = append(ids, res.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestResourceQuery(t *testing.T) {
+	router, repo := newTestRouter(t)
+	for id, tags := range map[string][]string{
+		"r1": {"go", "cli"},
+		"r2": {"go", "library"},
+		"r3": {"go", "cli", "deprecated"},
+		"r4": {"python", "cli"},
+	} {
+		res := internal.Resource{ID: id, Name: id, Type: "note"}
+		for _, tag := range tags {
+			res.Tags = append(res.Tags, internal.Tag{Name: tag})
+		}
+		if _, err := repo.CreateResource(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"go", "r1,r2,r3"},
+		{"go AND cli", "r1,r3"},
+		{"go AND (cli OR library) AND NOT deprecated", "r1,r2"},
+		{"cli AND NOT go", "r4"},
+		{"python OR library", "r2,r4"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, "/resource/?q="+url.QueryEscape(tt.query), "")
+		if w.Code != http.StatusOK {
+			t.Errorf("query %q returned %d: %s", tt.query, w.Code, w.Body)
+			continue
+		}
+		if got := resourceIDs(t, w); got != tt.want {
+			t.Errorf("query %q matched %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	w := serve(router, http.MethodGet, "/resource/?q="+url.QueryEscape("go AND AND cli"), "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "position 7") {
+		t.Errorf("invalid query returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestResourceAttributes(t *testing.T) {
+	router, _ := newTestRouter(t)
+	for _, body := range []string{
+		`{"id":"r1","name":"r1","type":"note","attributes":{"size":10,"owner":"alice","released":"2020-01-15","public":true}}`,
+		`{"id":"r2","name":"r2","type":"note","attributes":{"size":250,"owner":"bob","released":"2021-03-01T10:00:00Z"}}`,
+		`{"id":"r3","name":"r3","type":"note","attributes":{"owner":"alice"}}`,
+	} {
+		if w := serve(router, http.MethodPost, "/resource/", body); w.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+	if w := serve(router, http.MethodPost, "/resource/", `{"id":"r4","name":"r4","type":"note","attributes":{"tags":["a"]}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create with a list attribute returned %d, want 400", w.Code)
+	}
+
+	tests := []struct {
+		filters []string
+		want    string
+	}{
+		{[]string{"owner=alice"}, "r1,r3"},
+		{[]string{"public=true"}, "r1"},
+		{[]string{"size=250"}, "r2"},
+		{[]string{"size>9"}, "r1,r2"},
+		{[]string{"size>10"}, "r2"},
+		{[]string{"size>=10"}, "r1,r2"},
+		{[]string{"size<=10"}, "r1"},
+		{[]string{"size<100"}, "r1"},
+		{[]string{"released<2021-01-01"}, "r1"},
+		{[]string{"released>=2020-06-01T00:00:00Z"}, "r2"},
+		{[]string{"size"}, "r1,r2"},
+		{[]string{"missing"}, ""},
+		{[]string{"owner=alice", "size"}, "r1"},
+	}
+	for _, tt := range tests {
+		q := url.Values{"attr": tt.filters}
+		w := serve(router, http.MethodGet, "/resource/?"+q.Encode(), "")
+		if w.Code != http.StatusOK {
+			t.Errorf("filter %v returned %d: %s", tt.filters, w.Code, w.Body)
+			continue
+		}
+		if got := resourceIDs(t, w); got != tt.want {
+			t.Errorf("filter %v matched %s, want %s", tt.filters, got, tt.want)
+		}
+	}
+
+	if w := serve(router, http.MethodGet, "/resource/?attr="+url.QueryEscape("=alice"), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("filter without a key returned %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/fx/fxtest"
+)
+
+func init() {
+	logrus.SetOutput(ioutil.Discard)
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// newTestRouter serves the handlers of the api from a repository on a fresh database in a temporary directory
+func newTestRouter(t *testing.T, configure ...func(config *internal.Configuration)) (*mux.Router, database.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	config := internal.Configuration{
+		DatabaseFile:   filepath.Join(dir, "db.bolt"),
+		GraphPath:      filepath.Join(dir, "db.bolt.graph"),
+		TagPolicy:      internal.DefaultTagPolicy(),
+		TrashRetention: 30 * 24 * time.Hour,
+		IdempotencyTTL: time.Hour,
+	}
+	for _, fn := range configure {
+		fn(&config)
+	}
+	lc := fxtest.NewLifecycle(t)
+	clock := &testClock{now: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	kv := database.NewBoltConnectionWithBackup(lc, config, clock, nil)
+	g := database.NewGraphDatabase(lc, config)
+	repo := database.NewRepository(lc, config, clock, kv, g)
+	lc.RequireStart()
+	t.Cleanup(lc.RequireStop)
+
+	router := mux.NewRouter().UseEncodedPath()
+	NewResourceHandler(router, repo)
+	NewTagHandler(router, repo)
+	NewTypeHandler(router, repo)
+	NewTrashHandler(router, repo)
+	NewTransferHandler(router, repo, config)
+	NewAdminHandler(router, repo, config)
+	return router, repo
+}
+
+// serve makes a request against the router, headers are given as name and value pairs
+func serve(router http.Handler, method string, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
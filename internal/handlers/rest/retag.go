@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/query"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// Retag adds and removes tags on every resource matching the filter of the request. With dry_run=true the
+// resources which would change are listed instead, otherwise the job is returned once finished or with 202
+// Accepted while it runs in the background.
+func (h *resourceHandler) Retag(w http.ResponseWriter, r *http.Request) {
+	var req internal.RetagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logrus.WithError(err).Error("unable to decode message")
+		EncodeError(w, http.StatusBadRequest, "resources", "Bad Request from unmarshalling", "retag")
+		return
+	}
+	defer r.Body.Close()
+
+	var resp interface{}
+	var err error
+	if r.URL.Query().Get("dry_run") == "true" {
+		resp, err = h.repo.PreviewRetag(req)
+	} else {
+		resp, err = h.repo.WithActor(Actor(r)).StartRetag(req)
+	}
+	if EncodeValidationError(w, err, "resources", "retag") {
+		return
+	}
+	var perr *query.ParseError
+	if errors.As(err, &perr) {
+		EncodeError(w, http.StatusBadRequest, "resources", perr.Error(), "retag")
+		return
+	}
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to retag resources", "retag")
+		return
+	}
+	if job, ok := resp.(internal.RetagJob); ok && job.Status == internal.JobRunning {
+		w.Header().Set("Location", path.Join(r.URL.Path, url.PathEscape(job.ID)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+func (h *resourceHandler) RetagJobs(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.repo.FindRetagJobs()
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find retag jobs", "retag jobs")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+func (h *resourceHandler) RetagJob(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.repo.FindRetagJob(PathVars(r)["job"])
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "resources", "retag job not found", "retag job")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "resources", "unable to find retag job", "retag job")
+	}
+}
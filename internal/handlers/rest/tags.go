@@ -16,19 +16,36 @@ type tagHandler struct {
 
 func NewTagHandler(mr *mux.Router, repo database.Repository) http.Handler {
 	r := mr.PathPrefix("/tag").Subrouter()
+	r.Use(Idempotent(repo))
 
 	h := &tagHandler{
 		repo: repo,
 	}
 
 	r.HandleFunc("/", h.FindAll).Methods("GET")
+	r.HandleFunc("/_resolve", h.Resolve).Methods("GET")
 	r.HandleFunc("/{id}", h.FindByID).Methods("GET")
 	r.HandleFunc("/{id}/resources/", h.FindResourcesByTag).Methods("GET")
+	r.HandleFunc("/{id}/children", h.FindChildren).Methods("GET")
+	r.HandleFunc("/{id}/ancestors", h.FindAncestors).Methods("GET")
 	r.HandleFunc("/", h.Create).Methods("POST")
+	r.HandleFunc("/{id}", h.Update).Methods("PUT")
+	r.HandleFunc("/{id}", h.Patch).Methods("PATCH")
+	r.HandleFunc("/{id}", h.Delete).Methods("DELETE")
+	r.HandleFunc("/{id}/rename", h.Rename).Methods("POST")
+	r.HandleFunc("/{id}/merge-into/{other}", h.Merge).Methods("POST")
+	r.HandleFunc("/{id}/aliases", h.FindAliases).Methods("GET")
+	r.HandleFunc("/{id}/aliases", h.AddAlias).Methods("POST")
+	r.HandleFunc("/{id}/aliases/{alias}", h.DeleteAlias).Methods("DELETE")
 
 	return r
 }
 
+// writer returns the repository to make the changes of a request with
+func (h *tagHandler) writer(r *http.Request) database.Repository {
+	return h.repo.WithActor(Actor(r)).WithPrecondition(Precondition(r))
+}
+
 func (h *tagHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 	var params *internal.TagParams
 	if len(r.URL.Query()) > 0 {
@@ -40,23 +57,39 @@ func (h *tagHandler) FindAll(w http.ResponseWriter, r *http.Request) {
 		}
 		params = &p
 	}
-	resp, err := h.repo.FindAllTags(params)
+	resp, next, err := h.repo.FindAllTags(params)
+	if err == internal.ErrInvalid {
+		EncodeError(w, http.StatusBadRequest, "tags", "invalid sort, limit or cursor", "find all")
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find tags", "find all")
 		return
 	}
+	EncodeNextLink(w, r, next)
 	EncodeJSONResponse(r.Context(), w, resp)
 }
 
 func (h *tagHandler) FindByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	vars := PathVars(r)
 
 	id := vars["id"]
+	EncodeResolvedAliases(w, h.repo, []string{id})
 	resp, err := h.repo.FindTagByName(id)
+	if err == internal.ErrNotFound {
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "find by id")
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find tags", "find all")
 		return
 	}
+	EncodeETag(w, resp.Revision)
+	cond := internal.Precondition{IfNoneMatch: r.Header.Get("If-None-Match")}
+	if cond.Check(true, resp.Revision) != nil {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	EncodeJSONResponse(r.Context(), w, resp)
 }
 
@@ -69,22 +102,302 @@ func (h *tagHandler) Create(w http.ResponseWriter, r *http.Request) {
 		EncodeError(w, http.StatusBadRequest, "tag", "Bad Request from unmarshalling", "create")
 		return
 	}
-	t, err := h.repo.CreateTag(tag)
+	EncodeResolvedAliases(w, h.repo, []string{tag.Name})
+	t, err := h.writer(r).CreateTag(tag)
+	if EncodeValidationError(w, err, "tag", "create") {
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "tag", "failed to create tag", "create")
 		return
 	}
+	EncodeETag(w, t.Revision)
 	EncodeJSONResponse(r.Context(), w, t)
 }
 
 func (h *tagHandler) FindResourcesByTag(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
+	vars := PathVars(r)
 	id := vars["id"]
-	params := internal.ResourceParams{Tag: id}
-	resp, err := h.repo.FindAllResources(&params)
+	params := internal.ResourceParams{}
+	if err := decoder.Decode(&params, r.URL.Query()); err != nil {
+		logrus.WithError(err).Error("unable to parse params")
+		EncodeError(w, http.StatusBadRequest, "tags", "unable to parse params", "find all resources")
+		return
+	}
+	params.Tag = id
+	EncodeResolvedAliases(w, h.repo, []string{id})
+	resp, next, err := h.repo.FindAllResources(&params)
+	if EncodeValidationError(w, err, "tags", "find all resources") {
+		return
+	}
+	if err == internal.ErrInvalid {
+		EncodeError(w, http.StatusBadRequest, "tags", "invalid sort, limit or cursor", "find all resources")
+		return
+	}
 	if err != nil {
 		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find tags", "find all resources")
 		return
 	}
+	EncodeNextLink(w, r, next)
 	EncodeJSONResponse(r.Context(), w, resp)
 }
+
+func (h *tagHandler) Patch(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+	id := vars["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	existing, err := h.repo.FindTagByName(id)
+	if err == internal.ErrNotFound {
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "patch")
+		return
+	}
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find tag", "patch")
+		return
+	}
+
+	original, err := json.Marshal(existing)
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to encode tag", "patch")
+		return
+	}
+	patched, err := MergePatch(original, b)
+	if err != nil {
+		EncodeError(w, http.StatusBadRequest, "tags", "Bad Request from unmarshalling", "patch")
+		return
+	}
+
+	var tag internal.Tag
+	if err := json.Unmarshal(patched, &tag); err != nil {
+		EncodeError(w, http.StatusBadRequest, "tags", "patch produced an invalid tag", "patch")
+		return
+	}
+	if tag.Name != existing.Name {
+		EncodeError(w, http.StatusBadRequest, "tags", "use rename to change the tag name", "patch")
+		return
+	}
+
+	h.update(w, r, tag, "patch")
+}
+
+// Update replaces the color and description of a tag, the other fields are kept by the repository
+func (h *tagHandler) Update(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var tag internal.Tag
+	if err := json.Unmarshal(b, &tag); err != nil {
+		EncodeError(w, http.StatusBadRequest, "tags", "Bad Request from unmarshalling", "update")
+		return
+	}
+	if tag.Name != "" && tag.Name != vars["id"] {
+		EncodeError(w, http.StatusBadRequest, "tags", "use rename to change the tag name", "update")
+		return
+	}
+	tag.Name = vars["id"]
+
+	h.update(w, r, tag, "update")
+}
+
+func (h *tagHandler) update(w http.ResponseWriter, r *http.Request, tag internal.Tag, method string) {
+	resp, err := h.writer(r).UpdateTag(tag)
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "tags", "invalid color", method)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", method)
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "tags", "precondition failed", method)
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to update tag", method)
+	}
+}
+
+type renameRequest struct {
+	Name string `json:"name"`
+}
+
+func (h *tagHandler) Rename(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req renameRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		EncodeError(w, http.StatusBadRequest, "tags", "Bad Request from unmarshalling", "rename")
+		return
+	}
+
+	resp, err := h.writer(r).RenameTag(vars["id"], req.Name)
+	if EncodeValidationError(w, err, "tags", "rename") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "tags", "missing name", "rename")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "tags", "precondition failed", "rename")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "rename")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "tags", "tag already exists or has children", "rename")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to rename tag", "rename")
+	}
+}
+
+func (h *tagHandler) Merge(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.writer(r).MergeTag(vars["id"], vars["other"])
+	switch err {
+	case nil:
+		EncodeETag(w, resp.Revision)
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "tags", "cannot merge a tag into itself", "merge")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "tags", "precondition failed", "merge")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "merge")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "tags", "tag has children", "merge")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to merge tag", "merge")
+	}
+}
+
+func (h *tagHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	cascade := r.URL.Query().Get("cascade") == "true"
+	err := h.writer(r).DeleteTag(vars["id"], cascade)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "delete")
+	case internal.ErrPrecondition:
+		EncodeError(w, http.StatusPreconditionFailed, "tags", "precondition failed", "delete")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "tags", "tag is in use or has children", "delete")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to delete tag", "delete")
+	}
+}
+
+func (h *tagHandler) FindChildren(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	resp, err := h.repo.FindTagChildren(vars["id"], recursive)
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "find children")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find children", "find children")
+	}
+}
+
+func (h *tagHandler) FindAncestors(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.repo.FindTagAncestors(vars["id"])
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "find ancestors")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find ancestors", "find ancestors")
+	}
+}
+
+// Resolve lists which of the names given in the name query parameter are aliases and the tags they resolve to
+func (h *tagHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.repo.ResolveTagNames(r.URL.Query()["name"])
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to resolve aliases", "resolve")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+type aliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+func (h *tagHandler) FindAliases(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.repo.FindTagAliases(vars["id"])
+	switch err {
+	case nil:
+		if resp == nil {
+			resp = []string{}
+		}
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "find aliases")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "unable to find aliases", "find aliases")
+	}
+}
+
+func (h *tagHandler) AddAlias(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var req aliasRequest
+	if err := json.Unmarshal(b, &req); err != nil {
+		EncodeError(w, http.StatusBadRequest, "tags", "Bad Request from unmarshalling", "add alias")
+		return
+	}
+
+	resp, err := h.writer(r).AddTagAlias(vars["id"], req.Alias)
+	if EncodeValidationError(w, err, "tags", "add alias") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrInvalid:
+		EncodeError(w, http.StatusBadRequest, "tags", "invalid alias", "add alias")
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag not found", "add alias")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "tags", "alias is already a tag or points to another tag", "add alias")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to add alias", "add alias")
+	}
+}
+
+func (h *tagHandler) DeleteAlias(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	err := h.writer(r).DeleteTagAlias(vars["id"], vars["alias"])
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "tags", "tag or alias not found", "delete alias")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "tags", "failed to delete alias", "delete alias")
+	}
+}
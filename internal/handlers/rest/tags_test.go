@@ -0,0 +1,198 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/holmes89/tags/internal"
+)
+
+func TestTagETags(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := serve(router, http.MethodPost, "/tag/", `{"name":"go"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("create returned no ETag")
+	}
+
+	w = serve(router, http.MethodGet, "/tag/go", "")
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("get returned ETag %q, want %q", w.Header().Get("ETag"), etag)
+	}
+	if w = serve(router, http.MethodGet, "/tag/go", "", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("get with a matching If-None-Match: %d, want 304", w.Code)
+	}
+
+	w = serve(router, http.MethodPatch, "/tag/go", `{"description":"language"}`, "If-Match", etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch with a matching If-Match: %d %s", w.Code, w.Body)
+	}
+	updated := w.Header().Get("ETag")
+	if updated == "" || updated == etag {
+		t.Errorf("patch returned ETag %q after %q", updated, etag)
+	}
+
+	if w = serve(router, http.MethodPut, "/tag/go", `{"color":"#00ff00"}`, "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("put with a stale If-Match: %d, want 412", w.Code)
+	}
+	if w = serve(router, http.MethodPatch, "/tag/go", `{"color":"#00ff00"}`, "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("patch with a stale If-Match: %d, want 412", w.Code)
+	}
+	if w = serve(router, http.MethodDelete, "/tag/go", "", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with a stale If-Match: %d, want 412", w.Code)
+	}
+	if w = serve(router, http.MethodPost, "/tag/go/rename", `{"name":"golang"}`, "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("rename with a stale If-Match: %d, want 412", w.Code)
+	}
+
+	w = serve(router, http.MethodPut, "/tag/go", `{"color":"#00ff00"}`, "If-Match", updated)
+	if w.Code != http.StatusOK {
+		t.Fatalf("put with a matching If-Match: %d %s", w.Code, w.Body)
+	}
+	if w = serve(router, http.MethodDelete, "/tag/go", "", "If-Match", w.Header().Get("ETag")); w.Code != http.StatusNoContent {
+		t.Errorf("delete with a matching If-Match: %d, want 204", w.Code)
+	}
+}
+
+func TestTagUpdateRejectsRename(t *testing.T) {
+	router, _ := newTestRouter(t)
+	serve(router, http.MethodPost, "/tag/", `{"name":"go"}`)
+
+	if w := serve(router, http.MethodPut, "/tag/go", `{"name":"golang"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("put with another name: %d, want 400", w.Code)
+	}
+}
+
+// listedTags decodes a list of tags from a response and returns their names in order
+func listedTags(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var tags []internal.Tag
+	if err := json.Unmarshal(w.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("decode %d %s: %v", w.Code, w.Body, err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return fmt.Sprint(names)
+}
+
+func TestTagHierarchy(t *testing.T) {
+	router, repo := newTestRouter(t)
+	for id, tag := range map[string]string{"r1": "lang/go", "r2": "lang", "r3": "cloud/gcp/storage", "r4": "cloud/gcp"} {
+		if _, err := repo.CreateResource(internal.Resource{ID: id, Name: id, Type: "note", Tags: []internal.Tag{{Name: tag}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/tag/cloud/children", "[cloud/gcp]"},
+		{"/tag/cloud/children?recursive=true", "[cloud/gcp cloud/gcp/storage]"},
+		{"/tag/cloud%2Fgcp%2Fstorage/children", "[]"},
+		{"/tag/cloud%2Fgcp%2Fstorage/ancestors", "[cloud/gcp cloud]"},
+		{"/tag/lang/ancestors", "[]"},
+	}
+	for _, tt := range tests {
+		w := serve(router, http.MethodGet, tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s returned %d: %s", tt.target, w.Code, w.Body)
+			continue
+		}
+		if got := listedTags(t, w); got != tt.want {
+			t.Errorf("GET %s listed %s, want %s", tt.target, got, tt.want)
+		}
+	}
+	for _, target := range []string{"/tag/missing/children", "/tag/missing/ancestors"} {
+		if w := serve(router, http.MethodGet, target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s returned %d, want 404", target, w.Code)
+		}
+	}
+
+	resources := []struct {
+		target string
+		want   string
+	}{
+		{"/resource/?tag=cloud", ""},
+		{"/resource/?tag=cloud&descendants=true", "r3,r4"},
+		{"/resource/?tag=cloud%2Fgcp&descendants=true", "r3,r4"},
+		{"/resource/?q=lang&descendants=true", "r1,r2"},
+		{"/resource/?q=lang", "r2"},
+	}
+	for _, tt := range resources {
+		if got := resourceIDs(t, serve(router, http.MethodGet, tt.target, "")); got != tt.want {
+			t.Errorf("GET %s matched %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestTagAliases(t *testing.T) {
+	router, repo := newTestRouter(t)
+	if _, err := repo.CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note"}); err != nil {
+		t.Fatal(err)
+	}
+	serve(router, http.MethodPost, "/tag/", `{"name":"kubernetes"}`)
+	serve(router, http.MethodPost, "/tag/", `{"name":"go"}`)
+
+	aliases := []struct {
+		tag   string
+		alias string
+		code  int
+	}{
+		{"kubernetes", "k8s", http.StatusOK},
+		{"kubernetes", "K8S", http.StatusOK},
+		{"go", "k8s", http.StatusConflict},
+		{"kubernetes", "go", http.StatusConflict},
+		{"kubernetes", "and", http.StatusBadRequest},
+		{"missing", "kube", http.StatusNotFound},
+	}
+	for _, tt := range aliases {
+		if w := serve(router, http.MethodPost, "/tag/"+tt.tag+"/aliases", `{"alias":"`+tt.alias+`"}`); w.Code != tt.code {
+			t.Errorf("alias %s for %s returned %d, want %d: %s", tt.alias, tt.tag, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serve(router, http.MethodPut, "/resource/r1/tags/K8s", "")
+	if w.Code != http.StatusOK || w.Header().Get("X-Resolved-Aliases") != "K8s=kubernetes" {
+		t.Errorf("tagging with an alias returned %d, resolved %q: %s", w.Code, w.Header().Get("X-Resolved-Aliases"), w.Body)
+	}
+	var res internal.Resource
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Tags) != 1 || res.Tags[0].Name != "kubernetes" {
+		t.Errorf("tagging with an alias stored %+v %v, want kubernetes", res.Tags, err)
+	}
+	if w := serve(router, http.MethodPost, "/tag/", `{"name":"k8s"}`); w.Code != http.StatusOK || listedTags(t, serve(router, http.MethodGet, "/tag/", "")) != "[go kubernetes]" {
+		t.Errorf("creating an alias made a tag: %d %s", w.Code, w.Body)
+	}
+
+	for _, target := range []string{"/resource/?tag=k8s", "/resource/?q=k8s", "/tag/k8s/resources/"} {
+		w := serve(router, http.MethodGet, target, "")
+		if w.Header().Get("X-Resolved-Aliases") != "k8s=kubernetes" {
+			t.Errorf("GET %s resolved %q, want k8s=kubernetes", target, w.Header().Get("X-Resolved-Aliases"))
+		}
+		if got := resourceIDs(t, w); got != "r1" {
+			t.Errorf("GET %s matched %s, want r1", target, got)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/tag/_resolve?name=k8s&name=go", ""); w.Body.String() != `{"k8s":"kubernetes"}`+"\n" {
+		t.Errorf("resolve returned %s", w.Body)
+	}
+
+	if w := serve(router, http.MethodGet, "/tag/kubernetes/aliases", ""); w.Body.String() != `["k8s"]`+"\n" {
+		t.Errorf("aliases listed %s, want [k8s]", w.Body)
+	}
+	if w := serve(router, http.MethodDelete, "/tag/kubernetes/aliases/k8s", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete alias returned %d", w.Code)
+	}
+	if w := serve(router, http.MethodDelete, "/tag/kubernetes/aliases/k8s", ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete of a deleted alias returned %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// transferContentTypes maps the export formats to their media types
+var transferContentTypes = map[string]string{
+	internal.FormatJSONL:  "application/x-ndjson",
+	internal.FormatCSV:    "text/csv",
+	internal.FormatNQuads: "application/n-quads",
+}
+
+var transferExtensions = map[string]string{
+	internal.FormatJSONL:  "jsonl",
+	internal.FormatCSV:    "csv",
+	internal.FormatNQuads: "nq",
+}
+
+type transferHandler struct {
+	repo database.Repository
+}
+
+func NewTransferHandler(mr *mux.Router, repo database.Repository, config internal.Configuration) http.Handler {
+	h := &transferHandler{
+		repo: repo,
+	}
+
+	mr.HandleFunc("/export", h.Export).Methods("GET")
+	// an import can replace every record, it needs the admin token
+	mr.Handle("/import", requireAdmin(config.AdminToken)(http.HandlerFunc(h.Import))).Methods("POST")
+
+	return mr
+}
+
+// Export streams every tag and resource in the format of the format parameter, JSON Lines by default. The
+// status is sent with the first record, a failure after it aborts the connection so the client sees a
+// truncated export rather than an error appended to it.
+func (h *transferHandler) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = internal.FormatJSONL
+	}
+	contentType, ok := transferContentTypes[format]
+	if !ok {
+		EncodeError(w, http.StatusBadRequest, "transfer", "unknown format", "export")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=tags."+transferExtensions[format])
+	cw := &countingWriter{w: w}
+	if err := h.repo.Export(cw, format); err != nil {
+		if cw.n == 0 {
+			EncodeError(w, http.StatusInternalServerError, "transfer", "unable to export", "export")
+			return
+		}
+		logrus.WithError(err).WithField("written", cw.n).Error("export aborted")
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Import reads tags and resources in the format of the format parameter or the content type, JSON Lines by
+// default. The mode is merge unless replace is requested, with dry_run=true nothing is written. A replace
+// has to be confirmed with confirm=replace. An import with invalid records is refused with the report
+// listing them.
+func (h *transferHandler) Import(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = internal.FormatJSONL
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			for f, t := range transferContentTypes {
+				if t == mediaType {
+					format = f
+				}
+			}
+		}
+	}
+	opts := internal.ImportOptions{
+		Mode:   r.URL.Query().Get("mode"),
+		DryRun: r.URL.Query().Get("dry_run") == "true",
+	}
+	opts.Confirm = opts.Mode == internal.ImportReplace && r.URL.Query().Get("confirm") == internal.ImportReplace
+	if opts.Mode == "" {
+		opts.Mode = internal.ImportMerge
+	}
+
+	resp, err := h.repo.WithActor(Actor(r)).Import(r.Body, format, opts)
+	if EncodeValidationError(w, err, "transfer", "import") {
+		return
+	}
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "transfer", "unable to import", "import")
+		return
+	}
+	if len(resp.Errors) > 0 {
+		EncodeJSONStatus(r.Context(), w, http.StatusUnprocessableEntity, resp)
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
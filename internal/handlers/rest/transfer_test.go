@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+)
+
+func withAdminToken(config *internal.Configuration) {
+	config.AdminToken = "secret"
+}
+
+func TestImportRequiresAdminToken(t *testing.T) {
+	body := `{"kind":"resource","resource":{"id":"r1","name":"r1","type":"note"}}`
+
+	router, _ := newTestRouter(t)
+	if w := serve(router, "POST", "/import", body); w.Code != http.StatusForbidden {
+		t.Errorf("import without a configured token returned %d, want 403", w.Code)
+	}
+
+	router, repo := newTestRouter(t, withAdminToken)
+	if w := serve(router, "POST", "/import", body, "Authorization", "Bearer wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("import with a wrong token returned %d, want 401", w.Code)
+	}
+	if _, err := repo.FindResourceByID("r1"); err != internal.ErrNotFound {
+		t.Errorf("refused import lookup returned %v, want ErrNotFound", err)
+	}
+	if w := serve(router, "POST", "/import", body, "Authorization", "Bearer secret"); w.Code != http.StatusOK {
+		t.Errorf("import with the token returned %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestImportReplaceRequiresConfirmation(t *testing.T) {
+	router, repo := newTestRouter(t, withAdminToken)
+	if _, err := repo.CreateResource(internal.Resource{ID: "r1", Name: "r1", Type: "note"}); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"kind":"resource","resource":{"id":"r2","name":"r2","type":"note"}}`
+
+	for _, target := range []string{"/import?mode=replace", "/import?mode=replace&confirm=true"} {
+		if w := serve(router, "POST", target, body, "Authorization", "Bearer secret"); w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d, want 400", target, w.Code)
+		}
+	}
+	if w := serve(router, "POST", "/import?mode=replace&confirm=replace", "", "Authorization", "Bearer secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("confirmed replace with an empty body returned %d, want 400", w.Code)
+	}
+	if _, err := repo.FindResourceByID("r1"); err != nil {
+		t.Fatalf("refused replaces deleted r1: %v", err)
+	}
+
+	if w := serve(router, "POST", "/import?mode=replace&confirm=replace", body, "Authorization", "Bearer secret"); w.Code != http.StatusOK {
+		t.Fatalf("confirmed replace returned %d: %s", w.Code, w.Body)
+	}
+	if _, err := repo.FindResourceByID("r1"); err != internal.ErrNotFound {
+		t.Errorf("replaced resource lookup returned %v, want ErrNotFound", err)
+	}
+}
+
+// failingExport writes part of an export before failing
+type failingExport struct {
+	database.Repository
+	written string
+}
+
+func (f failingExport) Export(w io.Writer, format string) error {
+	if f.written != "" {
+		if _, err := io.WriteString(w, f.written); err != nil {
+			return err
+		}
+	}
+	return errors.New("disk failure")
+}
+
+func TestExportFailure(t *testing.T) {
+	_, repo := newTestRouter(t)
+
+	router := mux.NewRouter()
+	NewTransferHandler(router, failingExport{Repository: repo}, internal.Configuration{})
+	if w := serve(router, "GET", "/export", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("export failing before writing returned %d, want 500", w.Code)
+	}
+
+	router = mux.NewRouter()
+	NewTransferHandler(router, failingExport{Repository: repo, written: `{"kind":"tag"}` + "\n"}, internal.Configuration{})
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("export failing after writing recovered %v, want the handler aborted", r)
+		}
+	}()
+	w := serve(router, "GET", "/export", "")
+	t.Errorf("export failing after writing completed with %d: %q", w.Code, strings.TrimSpace(w.Body.String()))
+}
@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"net/http"
+)
+
+type trashHandler struct {
+	repo database.Repository
+}
+
+func NewTrashHandler(mr *mux.Router, repo database.Repository) http.Handler {
+	r := mr.PathPrefix("/trash").Subrouter()
+
+	h := &trashHandler{
+		repo: repo,
+	}
+
+	r.HandleFunc("/", h.FindAll).Methods("GET")
+	r.HandleFunc("/{kind}/{id}/restore", h.Restore).Methods("POST")
+
+	return r
+}
+
+func (h *trashHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.repo.FindTrash(r.URL.Query().Get("kind"))
+	if EncodeValidationError(w, err, "trash", "find all") {
+		return
+	}
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "trash", "unable to find trash", "find all")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+func (h *trashHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.repo.WithActor(Actor(r)).RestoreFromTrash(vars["kind"], vars["id"])
+	if EncodeTypeError(r.Context(), w, err) {
+		return
+	}
+	if EncodeValidationError(w, err, "trash", "restore") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "trash", "item not found in trash", "restore")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "trash", "a record with this id already exists", "restore")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "trash", "unable to restore item", "restore")
+	}
+}
@@ -0,0 +1,124 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/holmes89/tags/internal"
+	"github.com/holmes89/tags/internal/database"
+	"io/ioutil"
+	"net/http"
+)
+
+type typeHandler struct {
+	repo database.Repository
+}
+
+func NewTypeHandler(mr *mux.Router, repo database.Repository) http.Handler {
+	r := mr.PathPrefix("/type").Subrouter()
+
+	h := &typeHandler{
+		repo: repo,
+	}
+
+	r.HandleFunc("/", h.FindAll).Methods("GET")
+	r.HandleFunc("/{id}", h.FindByID).Methods("GET")
+	r.HandleFunc("/", h.Create).Methods("POST")
+	r.HandleFunc("/{id}", h.Update).Methods("PUT")
+	r.HandleFunc("/{id}", h.Delete).Methods("DELETE")
+
+	return r
+}
+
+func (h *typeHandler) FindAll(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.repo.FindAllTypes()
+	if err != nil {
+		EncodeError(w, http.StatusInternalServerError, "types", "unable to find types", "find all")
+		return
+	}
+	EncodeJSONResponse(r.Context(), w, resp)
+}
+
+func (h *typeHandler) FindByID(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	resp, err := h.repo.FindTypeByName(vars["id"])
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "types", "type not found", "find by id")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "types", "unable to find type", "find by id")
+	}
+}
+
+func (h *typeHandler) Create(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var t internal.ResourceType
+	if err := json.Unmarshal(b, &t); err != nil {
+		EncodeError(w, http.StatusBadRequest, "types", "Bad Request from unmarshalling", "create")
+		return
+	}
+	resp, err := h.repo.CreateType(t)
+	if EncodeValidationError(w, err, "types", "create") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "types", "type exists", "create")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "types", "failed to create type", "create")
+	}
+}
+
+func (h *typeHandler) Update(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+	id := vars["id"]
+
+	b, _ := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+
+	var t internal.ResourceType
+	if err := json.Unmarshal(b, &t); err != nil {
+		EncodeError(w, http.StatusBadRequest, "types", "Bad Request from unmarshalling", "update")
+		return
+	}
+	if t.Name != "" && t.Name != id {
+		EncodeError(w, http.StatusBadRequest, "types", "name does not match path", "update")
+		return
+	}
+	t.Name = id
+
+	resp, err := h.repo.UpdateType(t)
+	if EncodeValidationError(w, err, "types", "update") {
+		return
+	}
+	switch err {
+	case nil:
+		EncodeJSONResponse(r.Context(), w, resp)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "types", "type not found", "update")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "types", "failed to update type", "update")
+	}
+}
+
+func (h *typeHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := PathVars(r)
+
+	err := h.repo.DeleteType(vars["id"])
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case internal.ErrNotFound:
+		EncodeError(w, http.StatusNotFound, "types", "type not found", "delete")
+	case internal.ErrConflict:
+		EncodeError(w, http.StatusConflict, "types", "type is in use", "delete")
+	default:
+		EncodeError(w, http.StatusInternalServerError, "types", "failed to delete type", "delete")
+	}
+}
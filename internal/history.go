@@ -0,0 +1,20 @@
+package internal
+
+import "time"
+
+// Revision is an entry in the history of a resource, Resource holds the state after the change and is nil
+// once the resource was deleted
+type Revision struct {
+	Revision uint64    `json:"revision"`
+	Op       string    `json:"op"`
+	At       time.Time `json:"at"`
+	By       string    `json:"by,omitempty"`
+	Resource *Resource `json:"resource,omitempty"`
+}
+
+type ResourceHistory interface {
+	FindResourceHistory(id string) ([]Revision, error)
+	// FindResourceAsOf returns the resource as it was at a revision number or RFC 3339 timestamp
+	FindResourceAsOf(id string, asOf string) (Resource, error)
+	RevertResource(id string, revision uint64) (Resource, error)
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// ID formats for server generated resource ids
+const (
+	IDFormatULID   = "ulid"
+	IDFormatUUIDv4 = "uuidv4"
+	IDFormatUUIDv7 = "uuidv7"
+)
+
+// NewIDGenerator returns a function generating ids in the given format, ULIDs are used when no format is given.
+// ULIDs and UUIDv7s sort by creation time which keeps the id index in insertion order.
+func NewIDGenerator(format string) (func() string, error) {
+	switch strings.ToLower(format) {
+	case "", IDFormatULID:
+		return func() string {
+			return ulid.Make().String()
+		}, nil
+	case IDFormatUUIDv4:
+		return func() string {
+			return uuid.New().String()
+		}, nil
+	case IDFormatUUIDv7:
+		return func() string {
+			return uuid.Must(uuid.NewV7()).String()
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown id format %q", format)
+	}
+}
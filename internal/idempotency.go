@@ -0,0 +1,27 @@
+package internal
+
+import "time"
+
+// IdempotentRequest is a write made with an idempotency key. It is stored before the write is made and
+// completed with the response, retries with the same key replay the response instead of writing again.
+type IdempotentRequest struct {
+	Key string `json:"key"`
+	// Fingerprint identifies the method, path and body of the request, a key may only be reused with an
+	// identical request
+	Fingerprint string              `json:"fingerprint"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Completed   bool                `json:"completed"`
+	Status      int                 `json:"status,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Body        []byte              `json:"body,omitempty"`
+}
+
+type IdempotencyStore interface {
+	// BeginIdempotentRequest reserves the key for the request, or returns the request already stored under
+	// the key and true when the key was used within the retention period
+	BeginIdempotentRequest(key string, fingerprint string) (IdempotentRequest, bool, error)
+	// CompleteIdempotentRequest stores the response of a reserved request
+	CompleteIdempotentRequest(req IdempotentRequest) error
+	// AbandonIdempotentRequest releases a reserved key so the request can be retried
+	AbandonIdempotentRequest(key string) error
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ValidationError explains which rule of the tag policy a name failed, it matches ErrInvalid with errors.Is
+type ValidationError struct {
+	Rule  string `json:"rule"`
+	Value string `json:"value"`
+	Msg   string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid tag %q, %s rule failed: %s", e.Value, e.Rule, e.Msg)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalid
+}
+
+// TagPolicy describes how tag names are normalized and validated before they are stored or looked up.
+// Names are always trimmed and converted to Unicode NFC.
+type TagPolicy struct {
+	// CaseFold folds names to lower case
+	CaseFold bool
+	// Slug collapses whitespace to '-' and only allows letters, digits, '-', '_' and '.' in each segment,
+	// segments are separated by the namespace ':' and hierarchy '/' and may not be empty
+	Slug bool
+	// MaxLength is the maximum length of a name in characters, zero means unlimited
+	MaxLength int
+	// Reserved names may not be used as tags
+	Reserved []string
+}
+
+// DefaultTagPolicy reserves the keywords of the query language as they cannot be used as tags in a query
+func DefaultTagPolicy() TagPolicy {
+	return TagPolicy{
+		CaseFold:  true,
+		Slug:      true,
+		MaxLength: 64,
+		Reserved:  []string{"and", "or", "not"},
+	}
+}
+
+// String describes the policy, it changes whenever the result of Normalize may change
+func (p TagPolicy) String() string {
+	return fmt.Sprintf("fold=%t slug=%t max=%d reserved=%s", p.CaseFold, p.Slug, p.MaxLength, strings.Join(p.Reserved, ","))
+}
+
+// Normalize returns the normalized form of a tag name or a *ValidationError naming the rule it failed
+func (p TagPolicy) Normalize(name string) (string, error) {
+	n := norm.NFC.String(strings.TrimSpace(name))
+	if n == "" {
+		return "", &ValidationError{Rule: "empty", Value: name, Msg: "name is empty"}
+	}
+	if p.CaseFold {
+		n = cases.Fold().String(n)
+	}
+	if p.Slug {
+		var err error
+		if n, err = slug(n); err != nil {
+			err.(*ValidationError).Value = name
+			return "", err
+		}
+	}
+	if p.MaxLength > 0 && utf8.RuneCountInString(n) > p.MaxLength {
+		return "", &ValidationError{Rule: "max_length", Value: name, Msg: fmt.Sprintf("name is longer than %d characters", p.MaxLength)}
+	}
+	for _, reserved := range p.Reserved {
+		if n == reserved || (p.CaseFold && n == cases.Fold().String(reserved)) {
+			return "", &ValidationError{Rule: "reserved", Value: name, Msg: fmt.Sprintf("%q is a reserved word", n)}
+		}
+	}
+	return n, nil
+}
+
+func slug(name string) (string, error) {
+	var namespace []string
+	path := name
+	if i := strings.Index(name, ":"); i >= 0 {
+		namespace = []string{name[:i]}
+		path = name[i+1:]
+	}
+	segments := strings.Split(path, "/")
+	for _, part := range [][]string{namespace, segments} {
+		for i, segment := range part {
+			segment = strings.Join(strings.Fields(segment), "-")
+			if segment == "" {
+				return "", &ValidationError{Rule: "slug", Msg: "namespace, value and path segments may not be empty"}
+			}
+			for _, c := range segment {
+				if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("-_.", c) {
+					return "", &ValidationError{Rule: "slug", Msg: fmt.Sprintf("character %q is not allowed", c)}
+				}
+			}
+			part[i] = segment
+		}
+	}
+	return JoinTagName(strings.Join(namespace, ""), strings.Join(segments, "/")), nil
+}
+
+// NormalizeReport lists the tags changed when existing records are brought in line with the tag policy
+type NormalizeReport struct {
+	Renamed map[string]string `json:"renamed"`
+	Merged  map[string]string `json:"merged"`
+	Aliases map[string]string `json:"aliases"`
+	Invalid map[string]string `json:"invalid"`
+	DryRun  bool              `json:"dry_run"`
+}
+
+type TagNormalizer interface {
+	NormalizeTags(dryRun bool) (NormalizeReport, error)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ETag formats a revision as an entity tag
+func ETag(revision uint64) string {
+	return strconv.Quote(strconv.FormatUint(revision, 10))
+}
+
+// Precondition is a conditional write, it holds the If-Match and If-None-Match header values of a request.
+// An empty precondition always holds.
+type Precondition struct {
+	IfMatch     string
+	IfNoneMatch string
+}
+
+// Check returns ErrPrecondition unless the record, found at the given revision when it exists, satisfies
+// the precondition
+func (p Precondition) Check(exists bool, revision uint64) error {
+	if p.IfMatch != "" && !(exists && matchETag(p.IfMatch, revision)) {
+		return ErrPrecondition
+	}
+	if p.IfNoneMatch != "" && exists && matchETag(p.IfNoneMatch, revision) {
+		return ErrPrecondition
+	}
+	return nil
+}
+
+// matchETag reports whether a header listing entity tags, or "*", matches the revision. Weak tags are
+// compared as strong ones.
+func matchETag(header string, revision uint64) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag := ETag(revision)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
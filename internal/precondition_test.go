@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+func TestPreconditionCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		cond     Precondition
+		exists   bool
+		revision uint64
+		want     error
+	}{
+		{"empty", Precondition{}, true, 3, nil},
+		{"empty missing", Precondition{}, false, 0, nil},
+		{"if-match", Precondition{IfMatch: `"3"`}, true, 3, nil},
+		{"if-match weak", Precondition{IfMatch: `W/"3"`}, true, 3, nil},
+		{"if-match list", Precondition{IfMatch: `"1", "3"`}, true, 3, nil},
+		{"if-match stale", Precondition{IfMatch: `"2"`}, true, 3, ErrPrecondition},
+		{"if-match any", Precondition{IfMatch: "*"}, true, 3, nil},
+		{"if-match any missing", Precondition{IfMatch: "*"}, false, 0, ErrPrecondition},
+		{"if-none-match", Precondition{IfNoneMatch: `"3"`}, true, 3, ErrPrecondition},
+		{"if-none-match other", Precondition{IfNoneMatch: `"2"`}, true, 3, nil},
+		{"if-none-match any", Precondition{IfNoneMatch: "*"}, true, 3, ErrPrecondition},
+		{"if-none-match any missing", Precondition{IfNoneMatch: "*"}, false, 0, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.Check(tt.exists, tt.revision); got != tt.want {
+			t.Errorf("%s: Check returned %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a node of a parsed tag query
+type Expr interface {
+	String() string
+}
+
+// Tag matches resources tagged with the given name
+type Tag struct {
+	Name string
+}
+
+// Namespace matches resources tagged with any tag in the namespace, written as `team:*`
+type Namespace struct {
+	Name string
+}
+
+// And matches resources matched by both sides
+type And struct {
+	Left  Expr
+	Right Expr
+}
+
+// Or matches resources matched by either side
+type Or struct {
+	Left  Expr
+	Right Expr
+}
+
+// Not matches every resource not matched by the inner expression
+type Not struct {
+	Expr Expr
+}
+
+func (t Tag) String() string {
+	return fmt.Sprintf("%q", t.Name)
+}
+
+func (n Namespace) String() string {
+	return fmt.Sprintf("%s:*", n.Name)
+}
+
+func (a And) String() string {
+	return fmt.Sprintf("(%s AND %s)", a.Left, a.Right)
+}
+
+func (o Or) String() string {
+	return fmt.Sprintf("(%s OR %s)", o.Left, o.Right)
+}
+
+func (n Not) String() string {
+	return fmt.Sprintf("NOT %s", n.Expr)
+}
+
+// MapTags returns a copy of the expression with every tag name replaced by the result of fn
+func MapTags(expr Expr, fn func(name string) (string, error)) (Expr, error) {
+	switch e := expr.(type) {
+	case Tag:
+		name, err := fn(e.Name)
+		return Tag{Name: name}, err
+	case And:
+		left, err := MapTags(e.Left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := MapTags(e.Right, fn)
+		return And{Left: left, Right: right}, err
+	case Or:
+		left, err := MapTags(e.Left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := MapTags(e.Right, fn)
+		return Or{Left: left, Right: right}, err
+	case Not:
+		inner, err := MapTags(e.Expr, fn)
+		return Not{Expr: inner}, err
+	default:
+		return expr, nil
+	}
+}
+
+// MapNamespaces returns a copy of the expression with every namespace name replaced by the result of fn
+func MapNamespaces(expr Expr, fn func(name string) (string, error)) (Expr, error) {
+	switch e := expr.(type) {
+	case Namespace:
+		name, err := fn(e.Name)
+		return Namespace{Name: name}, err
+	case And:
+		left, err := MapNamespaces(e.Left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := MapNamespaces(e.Right, fn)
+		return And{Left: left, Right: right}, err
+	case Or:
+		left, err := MapNamespaces(e.Left, fn)
+		if err != nil {
+			return nil, err
+		}
+		right, err := MapNamespaces(e.Right, fn)
+		return Or{Left: left, Right: right}, err
+	case Not:
+		inner, err := MapNamespaces(e.Expr, fn)
+		return Not{Expr: inner}, err
+	default:
+		return expr, nil
+	}
+}
+
+// Tags lists every tag name referenced by the expression
+func Tags(expr Expr) []string {
+	var names []string
+	MapTags(expr, func(name string) (string, error) {
+		names = append(names, name)
+		return name, nil
+	})
+	return names
+}
+
+// ParseError describes where a query failed to parse
+type ParseError struct {
+	Pos     int
+	Token   string
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at end of query", e.Message)
+	}
+	return fmt.Sprintf("%s at position %d near %q", e.Message, e.Pos, e.Token)
+}
+
+// Parse turns a query such as `go AND (cli OR library) AND NOT deprecated` into an expression tree.
+// Operators are case insensitive, bind in the order NOT, AND, OR and tag names containing spaces or
+// operator words can be double quoted. Namespaced tags are matched exactly with `env:prod` or by
+// namespace with `env:*`.
+func Parse(q string) (Expr, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected token")
+	}
+	return expr, nil
+}
+
+// maxDepth bounds the nesting of parentheses and NOT, deeper queries are refused rather than recursing
+// without limit
+const maxDepth = 64
+
+type parser struct {
+	tokens []token
+	pos    int
+	// depth is the number of parentheses and NOT operators enclosing the current token
+	depth int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, message string) error {
+	return &ParseError{Pos: t.pos, Token: t.text, Message: message}
+}
+
+// nest enters the expression opened by t, it fails at t when the query is nested too deeply
+func (p *parser) nest(t token) error {
+	if p.depth >= maxDepth {
+		return p.errorf(t, "query nested too deeply")
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNot:
+		if err := p.nest(t); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	case tokenLParen:
+		if err := p.nest(t); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, p.errorf(closing, "expected closing parenthesis")
+		}
+		return expr, nil
+	case tokenTag:
+		return Tag{Name: t.value}, nil
+	case tokenNamespace:
+		return Namespace{Name: t.value}, nil
+	default:
+		return nil, p.errorf(t, "expected tag")
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenTag
+	tokenNamespace
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value string
+	pos   int
+}
+
+func lex(q string) ([]token, error) {
+	var tokens []token
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			start := i
+			var value strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					value.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+				i++
+			}
+			text := string(runes[start:i])
+			if !closed {
+				return nil, &ParseError{Pos: start, Token: text, Message: "unterminated string"}
+			}
+			if value.Len() == 0 {
+				return nil, &ParseError{Pos: start, Token: text, Message: "empty tag"}
+			}
+			tokens = append(tokens, token{kind: tokenTag, text: text, value: value.String(), pos: start})
+		default:
+			start := i
+			for i < len(runes) && !strings.ContainsRune(" \t\n\r()\"", runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			t := token{kind: tokenTag, text: text, value: text, pos: start}
+			switch strings.ToUpper(text) {
+			case "AND":
+				t.kind = tokenAnd
+			case "OR":
+				t.kind = tokenOr
+			case "NOT":
+				t.kind = tokenNot
+			default:
+				if strings.HasSuffix(text, ":*") && len(text) > 2 {
+					t.kind = tokenNamespace
+					t.value = strings.TrimSuffix(text, ":*")
+				}
+			}
+			tokens = append(tokens, t)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"go", `"go"`},
+		{"go AND cli", `("go" AND "cli")`},
+		{"go and cli or library", `(("go" AND "cli") OR "library")`},
+		{"go AND (cli OR library)", `("go" AND ("cli" OR "library"))`},
+		{"NOT deprecated AND go", `(NOT "deprecated" AND "go")`},
+		{"not not go", `NOT NOT "go"`},
+		{`"and" OR "two words"`, `("and" OR "two words")`},
+		{`"say \"hi\""`, `"say \"hi\""`},
+		{"team:* AND env:prod", `(team:* AND "env:prod")`},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if got := expr.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{"", 0, "expected tag"},
+		{"go AND", 6, "expected tag"},
+		{"(go OR cli", 10, "expected closing parenthesis"},
+		{"go cli", 3, "unexpected token"},
+		{`go AND "cli`, 7, "unterminated string"},
+		{`""`, 0, "empty tag"},
+		{"go )", 3, "unexpected token"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse(%q) returned %v, want a ParseError", tt.query, err)
+			continue
+		}
+		if perr.Pos != tt.pos || perr.Message != tt.msg {
+			t.Errorf("Parse(%q) failed with %q at %d, want %q at %d", tt.query, perr.Message, perr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	nested := strings.Repeat("(", maxDepth) + "go" + strings.Repeat(")", maxDepth)
+	if _, err := Parse(nested); err != nil {
+		t.Errorf("Parse of %d nested parentheses: %v", maxDepth, err)
+	}
+
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{strings.Repeat("(", maxDepth+1) + "go" + strings.Repeat(")", maxDepth+1), maxDepth},
+		{strings.Repeat("NOT ", maxDepth+1) + "go", 4 * maxDepth},
+		{"go AND " + strings.Repeat("(NOT ", maxDepth), 7 + 5*(maxDepth/2)},
+		{strings.Repeat("(", 100000), maxDepth},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("Parse of %d characters returned %v, want a ParseError", len(tt.query), err)
+			continue
+		}
+		if perr.Pos != tt.pos || perr.Message != "query nested too deeply" {
+			t.Errorf("Parse of %d characters failed with %q at %d, want nested too deeply at %d", len(tt.query), perr.Message, perr.Pos, tt.pos)
+		}
+	}
+}
+
+func TestMapNamespaces(t *testing.T) {
+	expr, err := Parse("Team:* AND NOT (Env:* OR Go)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := MapNamespaces(expr, func(name string) (string, error) {
+		return strings.ToLower(name), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mapped.String(), `(team:* AND NOT (env:* OR "Go"))`; got != want {
+		t.Errorf("mapped to %s, want %s", got, want)
+	}
+	if got, want := expr.String(), `(Team:* AND NOT (Env:* OR "Go"))`; got != want {
+		t.Errorf("original changed to %s, want %s", got, want)
+	}
+
+	failed := errors.New("invalid")
+	if _, err := MapNamespaces(expr, func(string) (string, error) { return "", failed }); err != failed {
+		t.Errorf("mapping error returned as %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	expr, err := Parse("go AND team:* AND NOT (cli OR go)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(Tags(expr), ","); got != "go,cli,go" {
+		t.Errorf("tags %s, want go,cli,go", got)
+	}
+}
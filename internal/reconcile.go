@@ -0,0 +1,15 @@
+package internal
+
+// ReconcileReport describes the drift found between the kv store and the graph
+type ReconcileReport struct {
+	Resources int      `json:"resources"`
+	Tags      int      `json:"tags"`
+	Pending   int      `json:"pending"`
+	Missing   []string `json:"missing"`
+	Extra     []string `json:"extra"`
+	Fixed     bool     `json:"fixed"`
+}
+
+type Reconciler interface {
+	Reconcile(fix bool) (ReconcileReport, error)
+}
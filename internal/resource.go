@@ -1,28 +1,61 @@
 package internal
 
+import "time"
+
 type Resource struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Type string `json:"type"`
-	Tags []Tag  `json:"tags"`
+	ID string `json:"id"`
+	// Revision increases with every write to the resource, it is the number of the latest entry in its
+	// history
+	Revision   uint64     `json:"revision"`
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Tags       []Tag      `json:"tags"`
+	Attributes Attributes `json:"attributes,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	UpdatedBy  string     `json:"updated_by,omitempty"`
 }
 
 type ResourceFactory interface {
 	CreateResource(resource Resource) (Resource, error)
 }
 
+type ResourceUpdater interface {
+	UpdateResource(resource Resource) (Resource, error)
+	DeleteResource(id string) error
+}
+
 type ResourceRepository interface {
 	FindResourceByID(id string) (Resource, error)
-	FindAllResources(params *ResourceParams) ([]Resource, error)
+	FindAllResources(params *ResourceParams) ([]Resource, string, error)
 }
 
 type ResourceTagger interface {
 	AddTagToResource(resource Resource, tag string) (Resource, error)
 	DeleteTagFromResource(resource Resource, tag string) error
+	ReplaceResourceTags(resource Resource, tags []string) (Resource, error)
 }
 
 type ResourceParams struct {
-	Type string `schema:"type"`
-	Name string `schema:"name"`
-	Tag  string `schema:"tag"`
+	Type  string `schema:"type" json:"type,omitempty"`
+	Name  string `schema:"name" json:"name,omitempty"`
+	Tag   string `schema:"tag" json:"tag,omitempty"`
+	Query string `schema:"q" graph:"-" json:"q,omitempty"`
+	// Limit is the size of a page, 100 when it is zero and at most 1000
+	Limit  int    `schema:"limit" json:"-"`
+	Cursor string `schema:"cursor" graph:"-" json:"-"`
+	Sort   string `schema:"sort" graph:"-" json:"-"`
+	// Descendants includes resources tagged with any tag below the requested tags in the hierarchy
+	Descendants bool `schema:"descendants" json:"descendants,omitempty"`
+	// Attr filters by attributes, see AttributeFilter for the syntax
+	Attr []string `schema:"attr" graph:"-" json:"attr,omitempty"`
+	// TaggedSince limits the results to resources given a tag, or the requested tag, since a timestamp or
+	// within a duration such as 24h
+	TaggedSince string `schema:"tagged_since" graph:"-" json:"tagged_since,omitempty"`
+}
+
+// Filtered reports whether the params restrict the resources listed, as opposed to only paging them
+func (p ResourceParams) Filtered() bool {
+	return p.Type != "" || p.Name != "" || p.Tag != "" || p.Query != "" || len(p.Attr) > 0 || p.TaggedSince != ""
 }
@@ -0,0 +1,60 @@
+package internal
+
+import "time"
+
+// job statuses
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// RetagRequest adds and removes tags on every resource matched by the filter, which selects resources as
+// the listing does, all matches are changed regardless of paging
+type RetagRequest struct {
+	Filter ResourceParams `json:"filter"`
+	Add    []string       `json:"add,omitempty"`
+	Remove []string       `json:"remove,omitempty"`
+}
+
+// RetagPreview lists the resources a retag request would change, with the tag names resolved as they
+// would be applied
+type RetagPreview struct {
+	Matched int      `json:"matched"`
+	Add     []string `json:"add,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
+	IDs     []string `json:"ids"`
+}
+
+// RetagJob tracks the progress of a retag request. The matching resources are listed when the job starts,
+// those deleted or already tagged as requested by the time they are reached are skipped.
+type RetagJob struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Request    RetagRequest `json:"request"`
+	Matched    int          `json:"matched"`
+	Processed  int          `json:"processed"`
+	Changed    int          `json:"changed"`
+	Failed     int          `json:"failed"`
+	Errors     []RetagError `json:"errors,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	CreatedBy  string       `json:"created_by,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// RetagError records a resource the job could not change, such as one the change would make violate its
+// type
+type RetagError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+type ResourceRetagger interface {
+	PreviewRetag(req RetagRequest) (RetagPreview, error)
+	// StartRetag creates a job for the request, small jobs are finished before it returns and larger ones
+	// keep running in the background
+	StartRetag(req RetagRequest) (RetagJob, error)
+	FindRetagJob(id string) (RetagJob, error)
+	FindRetagJobs() ([]RetagJob, error)
+}
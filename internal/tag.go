@@ -1,19 +1,108 @@
 package internal
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
 type Tag struct {
-	Name  string `json:"name"`
-	Color Color  `json:"color"`
+	Name        string    `json:"name"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Value       string    `json:"value,omitempty"`
+	Parent      string    `json:"parent,omitempty"`
+	Color       Color     `json:"color"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	// TaggedAt and TaggedBy record when and by whom the tag was assigned, they are only set on the tags
+	// of a resource
+	TaggedAt *time.Time `json:"tagged_at,omitempty"`
+	TaggedBy string     `json:"tagged_by,omitempty"`
+	// Revision increases with every write to the tag, it is not set on the tags of a resource
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// UnmarshalJSON accepts either a full name such as env:prod or a namespace and value pair, the namespace,
+// value and parent are always derived from the name so they cannot disagree.
+func (t *Tag) UnmarshalJSON(b []byte) error {
+	type tag Tag
+	var v tag
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*t = Tag(v)
+	if t.Name == "" && t.Value != "" {
+		t.Name = JoinTagName(t.Namespace, t.Value)
+	}
+	t.Namespace, t.Value = SplitTagName(t.Name)
+	t.Parent = ParentTagName(t.Name)
+	return nil
+}
+
+// SplitTagName separates a namespaced tag such as env:prod into its namespace and value. Tags without a
+// namespace return empty strings.
+func SplitTagName(name string) (string, string) {
+	i := strings.Index(name, ":")
+	if i <= 0 || i == len(name)-1 {
+		return "", ""
+	}
+	return name[:i], name[i+1:]
+}
+
+// ParentTagName returns the parent of a hierarchical tag such as cloud/gcp for cloud/gcp/storage, top level
+// tags have no parent.
+func ParentTagName(name string) string {
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return ""
+	}
+	return name[:i]
+}
+
+// JoinTagName builds a tag name from a namespace and value
+func JoinTagName(namespace string, value string) string {
+	if namespace == "" {
+		return value
+	}
+	return namespace + ":" + value
 }
 
 type TagFactory interface {
 	CreateTag(tag Tag) (Tag, error)
 }
 
+type TagUpdater interface {
+	UpdateTag(tag Tag) (Tag, error)
+	RenameTag(name string, newName string) (Tag, error)
+	MergeTag(name string, into string) (Tag, error)
+	DeleteTag(name string, cascade bool) error
+}
+
 type TagRepository interface {
 	FindTagByName(name string) (Tag, error)
-	FindAllTags(params *TagParams) ([]Tag, error)
+	FindAllTags(params *TagParams) ([]Tag, string, error)
+}
+
+type TagAliaser interface {
+	AddTagAlias(name string, alias string) ([]string, error)
+	DeleteTagAlias(name string, alias string) error
+	FindTagAliases(name string) ([]string, error)
+	ResolveTagNames(names []string) (map[string]string, error)
+}
+
+type TagHierarchy interface {
+	FindTagChildren(name string, recursive bool) ([]Tag, error)
+	FindTagAncestors(name string) ([]Tag, error)
 }
 
 type TagParams struct {
-	Type string `schema:"type"`
+	Type      string `schema:"type"`
+	Namespace string `schema:"namespace"`
+	// Limit is the size of a page, 100 when it is zero and at most 1000
+	Limit  int    `schema:"limit"`
+	Cursor string `schema:"cursor" graph:"-"`
+	Sort   string `schema:"sort" graph:"-"`
 }
@@ -0,0 +1,61 @@
+package internal
+
+import "io"
+
+// formats tags and resources are exported and imported in
+const (
+	// FormatJSONL is a JSON record per line for each tag, alias and resource
+	FormatJSONL = "jsonl"
+	// FormatCSV is a row per resource and tag pair, preceded by a row per tag without a resource
+	FormatCSV = "csv"
+	// FormatNQuads is the quads of the graph database, it does not carry timestamps or aliases
+	FormatNQuads = "nquads"
+)
+
+// import modes
+const (
+	// ImportMerge creates and updates the imported records and keeps the others
+	ImportMerge = "merge"
+	// ImportReplace also deletes the records missing from the import, they are moved to the trash
+	ImportReplace = "replace"
+)
+
+// ImportOptions selects how an import is applied, a dry run reports the changes without making them.
+// Confirm acknowledges that a replace deletes the records missing from the import, it is required to
+// replace unless it is a dry run.
+type ImportOptions struct {
+	Mode    string
+	DryRun  bool
+	Confirm bool
+}
+
+// ImportReport counts the changes an import made, or would make when it is a dry run. Nothing is written
+// when any record is invalid.
+type ImportReport struct {
+	Format    string        `json:"format"`
+	Mode      string        `json:"mode"`
+	DryRun    bool          `json:"dry_run"`
+	Tags      ImportCounts  `json:"tags"`
+	Aliases   ImportCounts  `json:"aliases"`
+	Resources ImportCounts  `json:"resources"`
+	Errors    []ImportError `json:"errors,omitempty"`
+}
+
+type ImportCounts struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Deleted   int `json:"deleted"`
+}
+
+// ImportError is an invalid record, Line is the line or row it was read from when the format has one
+type ImportError struct {
+	Line  int    `json:"line,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+type DataTransfer interface {
+	Export(w io.Writer, format string) error
+	Import(r io.Reader, format string, opts ImportOptions) (ImportReport, error)
+}
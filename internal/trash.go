@@ -0,0 +1,31 @@
+package internal
+
+import "time"
+
+// kinds of records kept in the trash
+const (
+	TrashResource = "resource"
+	TrashTag      = "tag"
+)
+
+// TrashItem is a deleted resource or tag kept until it is restored or the retention period passes
+type TrashItem struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	Resource  *Resource `json:"resource,omitempty"`
+	Tag       *Tag      `json:"tag,omitempty"`
+	// Resources and Aliases are the resources and aliases a deleted tag had, they are given back on restore
+	Resources []string `json:"resources,omitempty"`
+	Aliases   []string `json:"aliases,omitempty"`
+}
+
+type Trash interface {
+	// FindTrash lists the trash, limited to one kind unless kind is empty
+	FindTrash(kind string) ([]TrashItem, error)
+	// RestoreFromTrash puts a deleted record back, returning it as restored
+	RestoreFromTrash(kind string, id string) (TrashItem, error)
+	// PurgeTrash permanently removes everything deleted before the given time
+	PurgeTrash(before time.Time) (int, error)
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ResourceType declares what a resource of a given type must contain. Types which are not registered
+// are not checked.
+type ResourceType struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Schema is a JSON Schema the attributes of the resource must satisfy
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// AllowedTags limits the tags of the resource, a pattern ending in '*' allows every tag with that
+	// prefix such as "team:*" or "lang/*". An empty list allows every tag.
+	AllowedTags  []string `json:"allowed_tags,omitempty"`
+	RequiredTags []string `json:"required_tags,omitempty"`
+	// DefaultColor is given to tags created through resources of this type
+	DefaultColor Color `json:"default_color,omitempty"`
+}
+
+// Validate checks the type definition itself, including that the schema compiles
+func (t ResourceType) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("%w: type name is empty", ErrInvalid)
+	}
+	if t.DefaultColor != "" && !t.DefaultColor.Valid() {
+		return fmt.Errorf("%w: default color must be of the form #RRGGBB", ErrInvalid)
+	}
+	if len(t.Schema) > 0 {
+		if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(t.Schema)); err != nil {
+			return fmt.Errorf("%w: schema: %s", ErrInvalid, err)
+		}
+	}
+	return nil
+}
+
+// Violation is a single reason a resource does not match its type
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// TypeError lists every way a resource violates its type
+type TypeError struct {
+	Type       string      `json:"type"`
+	Violations []Violation `json:"violations"`
+}
+
+func (e *TypeError) Error() string {
+	var msgs []string
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Field+": "+v.Message)
+	}
+	return fmt.Sprintf("resource violates type %q: %s", e.Type, strings.Join(msgs, "; "))
+}
+
+// Check validates the attributes and tag names of a resource against the type, returning a *TypeError
+// listing every violation.
+func (t ResourceType) Check(attributes Attributes, tags []string) error {
+	terr := &TypeError{Type: t.Name}
+
+	if len(t.Schema) > 0 {
+		if attributes == nil {
+			attributes = Attributes{}
+		}
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(t.Schema), gojsonschema.NewGoLoader(attributes))
+		if err != nil {
+			return fmt.Errorf("%w: schema: %s", ErrInvalid, err)
+		}
+		for _, e := range result.Errors() {
+			field := "attributes"
+			if e.Field() != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+				field += "." + e.Field()
+			}
+			terr.Violations = append(terr.Violations, Violation{Field: field, Message: e.Description()})
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, tag := range tags {
+		present[tag] = true
+		if len(t.AllowedTags) > 0 && !t.allows(tag) {
+			terr.Violations = append(terr.Violations, Violation{Field: "tags", Message: fmt.Sprintf("tag %q is not allowed", tag)})
+		}
+	}
+	for _, tag := range t.RequiredTags {
+		if !present[tag] {
+			terr.Violations = append(terr.Violations, Violation{Field: "tags", Message: fmt.Sprintf("tag %q is required", tag)})
+		}
+	}
+
+	if len(terr.Violations) > 0 {
+		return terr
+	}
+	return nil
+}
+
+func (t ResourceType) allows(tag string) bool {
+	for _, pattern := range t.AllowedTags {
+		if pattern == tag || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(tag, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+type ResourceTypeRegistry interface {
+	CreateType(t ResourceType) (ResourceType, error)
+	UpdateType(t ResourceType) (ResourceType, error)
+	DeleteType(name string) error
+	FindTypeByName(name string) (ResourceType, error)
+	FindAllTypes() ([]ResourceType, error)
+}